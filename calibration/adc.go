@@ -60,10 +60,11 @@ func manipulateADC(bars *serialpkg.Leo485, finalLabel string) ([]int64, bool) {
 				}
 			default:
 			}
-		} // Get current readings
+		} // Get current readings, every bus in parallel
 		currentSample := make([][]int64, len(bars.Bars))
+		reads, errs := bars.GetAllADs(0)
 		for i := range bars.Bars {
-			bruts, err := bars.GetADs(i)
+			bruts, err := reads[i], errs[i]
 			if err == nil && len(bruts) > 0 {
 				// capture all load cells for proper matrix population
 				full := make([]int64, len(bruts))
@@ -72,7 +73,7 @@ func manipulateADC(bars *serialpkg.Leo485, finalLabel string) ([]int64, bool) {
 				}
 				currentSample[i] = full
 			} else {
-				currentSample[i] = make([]int64, bars.NLCs)
+				currentSample[i] = make([]int64, bars.NLCs[i])
 			}
 		}
 
@@ -106,11 +107,12 @@ func manipulateADC(bars *serialpkg.Leo485, finalLabel string) ([]int64, bool) {
 			// Show final averages once, then automatically advance (no key required)
 			ui.PrintFinalLine(bars, finalAverages, finalLabel)
 			// Flatten final averages to []int64 for downstream use
-			flat := make([]int64, len(bars.Bars)*bars.NLCs)
+			flat := make([]int64, bars.TotalLCs())
 			for i := range bars.Bars {
 				if i < len(finalAverages) {
-					for lc := 0; lc < bars.NLCs && lc < len(finalAverages[i]); lc++ {
-						flat[i*bars.NLCs+lc] = finalAverages[i][lc]
+					off := bars.Offset(i)
+					for lc := 0; lc < bars.NLCs[i] && lc < len(finalAverages[i]); lc++ {
+						flat[off+lc] = finalAverages[i][lc]
 					}
 				}
 			}
@@ -122,9 +124,12 @@ func manipulateADC(bars *serialpkg.Leo485, finalLabel string) ([]int64, bool) {
 	}
 }
 
-func calculateFinalAverages(samples [][][]int64, nlcs int) [][]int64 {
+// calculateFinalAverages averages each bar's samples; nlcs holds the number
+// of cells of every bar.
+func calculateFinalAverages(samples [][][]int64, nlcs []int) [][]int64 {
 	finalAverages := make([][]int64, len(samples))
 	for i, barSamples := range samples {
+		nlcs := nlcs[i]
 		if len(barSamples) == 0 {
 			finalAverages[i] = make([]int64, nlcs)
 			continue
@@ -0,0 +1,125 @@
+package calibration
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/file"
+	models "github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	ui "github.com/CK6170/Calrunrilla-go/ui"
+)
+
+// BackupConfig implements `calrunrilla backup`: it reads the zeros and factors
+// every bar of the config holds in flash and saves them as a calibrated JSON,
+// so a shelf can be restored or re-flashed without recalibrating.
+func BackupConfig(args []string, appVer, appBuild string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("out", "", "calibrated JSON to write (default <config>_backup_calibrated.json)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: calrunrilla backup [-out file] <config.json>")
+	}
+	configPath := fs.Arg(0)
+
+	jsonData, err := os.ReadFile(configPath)
+	if err != nil {
+		log.Fatalf("Error reading file: %v", err)
+	}
+	var parameters models.PARAMETERS
+	if err := json.Unmarshal(jsonData, &parameters); err != nil {
+		log.Fatalf("JSON error: %v", err)
+	}
+	if err := serialpkg.ValidateBars(parameters.BARS); err != nil {
+		log.Fatalf("Invalid BARS: %v", err)
+	}
+	if parameters.SERIAL == nil {
+		log.Fatal("Missing SERIAL section in JSON")
+	}
+	if parameters.SERIAL.PORT == "" {
+		p := serialpkg.AutoDetectPort(&parameters)
+		if p == "" {
+			log.Fatal("Could not auto-detect serial port for backup")
+		}
+		parameters.SERIAL.PORT = p
+	}
+	bars := serialpkg.NewShelf(&parameters)
+	defer func() { _ = bars.Close() }()
+	if !ProbeVersion(bars, &parameters) {
+		log.Fatalf("ProbeVersion failed on %s", parameters.SERIAL.PORT)
+	}
+	if _, ok := PrintVersions(bars, &parameters); !ok {
+		ui.Warningf("Warning: version check failed, continuing anyway\n")
+	}
+
+	for i := range parameters.BARS {
+		cal, err := bars.ReadCalibration(i)
+		if err != nil {
+			log.Fatalf("Bar %d: cannot read calibration: %v", i+1, err)
+		}
+		parameters.BARS[i].LC = cal.LCs()
+		ui.Greenf("\nBAR(%02d) ID=%d total factor %.10f\n", i+1, parameters.BARS[i].ID, cal.TotalFactor)
+		if cal.Zeros == nil {
+			ui.Warningf(" Firmware does not report zeros; ZERO is saved as 0\n")
+		}
+		for j, lc := range parameters.BARS[i].LC {
+			fmt.Printf(" [%03d] %9d  % .12f  %s\n", j, lc.ZERO, float64(lc.FACTOR), lc.IEEE)
+		}
+	}
+
+	path := *out
+	if path == "" {
+		path = backupBase(configPath) + "_backup_calibrated.json"
+	}
+	file.SaveToJSON(path, &parameters, appVer, appBuild)
+}
+
+// backupDevice reads the calibration every bar of parameters holds and writes
+// it as a calibrated JSON to path. parameters itself is left untouched.
+func backupDevice(bars *serialpkg.Leo485, parameters *models.PARAMETERS, path string) error {
+	backup := *parameters
+	backup.BARS = make([]*models.BAR, len(parameters.BARS))
+	for i, bar := range parameters.BARS {
+		cal, err := bars.ReadCalibration(i)
+		if err != nil {
+			return fmt.Errorf("bar %d: %w", i+1, err)
+		}
+		b := *bar
+		b.LC = cal.LCs()
+		backup.BARS[i] = &b
+	}
+	return file.WriteCalibrated(path, &backup)
+}
+
+// backupBase strips .json and _calibrated from a config path, so a config
+// and its calibrated file share one set of backups.
+func backupBase(configPath string) string {
+	base := strings.TrimSuffix(configPath, ".json")
+	return strings.TrimSuffix(base, "_calibrated")
+}
+
+// newBackupPath names the pre-flash backup of configPath taken at t.
+func newBackupPath(configPath string, t time.Time) string {
+	return fmt.Sprintf("%s_backup_%s_calibrated.json", backupBase(configPath), t.Format("20060102_150405"))
+}
+
+// latestBackup returns the newest pre-flash backup of configPath.
+func latestBackup(configPath string) (string, error) {
+	matches, err := filepath.Glob(backupBase(configPath) + "_backup_*_calibrated.json")
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no backup found for %s", configPath)
+	}
+	// The timestamp format sorts chronologically.
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
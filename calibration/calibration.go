@@ -13,7 +13,6 @@ import (
 	models "github.com/CK6170/Calrunrilla-go/models"
 	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
 	"github.com/CK6170/Calrunrilla-go/ui"
-	"github.com/tarm/serial"
 )
 
 // at the exported types in the models package.
@@ -64,6 +63,9 @@ func CalRunrilla(args0 string, barsPerRow int, appVer string, appBuild string) {
 	if len(parameters.BARS) == 0 {
 		log.Fatal("No Bars defined")
 	}
+	if err := serialpkg.ValidateBars(parameters.BARS); err != nil {
+		log.Fatalf("Invalid BARS: %v", err)
+	}
 
 	// Ensure we have a working serial port: if PORT missing OR cannot be opened OR version probe fails, auto-detect.
 	if parameters.SERIAL == nil {
@@ -77,8 +79,10 @@ func CalRunrilla(args0 string, barsPerRow int, appVer string, appBuild string) {
 	} else {
 		// Try opening specified port directly before constructing Leo485 to avoid fatal inside NewLeo485
 		ui.Debugf(parameters.DEBUG, "Trying configured port: %s (baud %d)\n", parameters.SERIAL.PORT, parameters.SERIAL.BAUDRATE)
-		cfg := &serial.Config{Name: parameters.SERIAL.PORT, Baud: parameters.SERIAL.BAUDRATE, Parity: serial.ParityNone, Size: 8, StopBits: serial.Stop1, ReadTimeout: time.Millisecond * 300}
-		sp, err := serial.OpenPort(cfg)
+		// Probe without CAPTURE so the check does not leave an empty capture file.
+		probe := *parameters.SERIAL
+		probe.CAPTURE = ""
+		sp, err := serialpkg.OpenTransport(&probe)
 		if err != nil {
 			log.Printf("Port %s open failed (%v), attempting auto-detect...\n", parameters.SERIAL.PORT, err)
 			needDetect = true
@@ -87,8 +91,8 @@ func CalRunrilla(args0 string, barsPerRow int, appVer string, appBuild string) {
 		}
 	}
 	if needDetect {
-		ui.Debugf(parameters.DEBUG, "Starting serial auto-detect across COM ports (this may take a few seconds)...\n")
-		p := serialpkg.AutoDetectPort(&parameters)
+		ui.Debugf(parameters.DEBUG, "Starting serial auto-detect across candidate ports (this may take a few seconds)...\n")
+		p := detectPort(&parameters)
 		if p == "" {
 			log.Fatal("Could not auto-detect serial port")
 		}
@@ -98,25 +102,16 @@ func CalRunrilla(args0 string, barsPerRow int, appVer string, appBuild string) {
 	}
 
 	ui.Debugf(parameters.DEBUG, "Opening Leo485 with port %s...\n", parameters.SERIAL.PORT)
-	bars := serialpkg.NewLeo485(parameters.SERIAL, parameters.BARS)
+	bars := serialpkg.NewShelf(&parameters)
 	defer func() { _ = bars.Close() }()
 
 	// Quick version probe; if fails, try auto-detect fallback (in case wrong but openable port)
 	ui.Debugf(parameters.DEBUG, "Probing device version...\n")
 	if !ProbeVersion(bars, &parameters) {
 		log.Printf("No version response from %s. Attempting reboot of all bars...\n", parameters.SERIAL.PORT)
-		// Try to reboot each bar once and allow time to recover
-		for i := range bars.Bars {
-			if bars.Reboot(i) {
-				ui.Greenf("Bar %d reboot command sent\n", i+1)
-			} else {
-				log.Printf("Bar %d reboot command failed or no response\n", i+1)
-			}
-			time.Sleep(200 * time.Millisecond)
-		}
-		// Wait a short while for devices to restart
+		// Reboot every bar and wait for each to answer again
 		ui.Greenf("Waiting for bars to reboot...\n")
-		time.Sleep(1500 * time.Millisecond)
+		printReboot(bars.RebootAndWait(nil, 3*time.Second))
 		// Try probing again
 		if ProbeVersion(bars, &parameters) {
 			ui.Greenf("Version response received after reboot\n")
@@ -128,7 +123,7 @@ func CalRunrilla(args0 string, barsPerRow int, appVer string, appBuild string) {
 				parameters.SERIAL.PORT = p
 				file.PersistParameters(args0, &parameters)
 				ui.Debugf(parameters.DEBUG, "Updated serial port after probe: %s (saved)\n", p)
-				bars = serialpkg.NewLeo485(parameters.SERIAL, parameters.BARS)
+				bars = serialpkg.NewShelf(&parameters)
 				defer func() { _ = bars.Close() }()
 			}
 		}
@@ -190,8 +185,9 @@ func CalRunrilla(args0 string, barsPerRow int, appVer string, appBuild string) {
 		switch resp {
 		case 'Y':
 			file.SaveToJSON(strings.Replace(args0, ".json", "_calibrated.json", 1), &parameters, appVer, appBuild)
+			backedUp := false
 			for {
-				if err := flashParameters(bars, &parameters); err != nil {
+				if err := flashWithBackup(bars, &parameters, nil, false, args0, &backedUp); err != nil {
 					log.Printf("Flash error: %v", err)
 					// Ask user whether to retry flashing, skip, or exit
 					a := ui.NextFlashAction()
@@ -246,24 +242,21 @@ func zeroCalibration(bars *serialpkg.Leo485, parameters *PARAMETERS) *matrix.Mat
 	}
 	// Empty line between final data and next phase instructions
 	fmt.Println()
-	return updateMatrixZero(ads, 3*(len(parameters.BARS)-1), bars.NLCs)
+	return updateMatrixZero(ads, len(models.CalibrationPlan(parameters.BARS)))
 }
 
 func weightCalibration(bars *serialpkg.Leo485, parameters *PARAMETERS) *Matrix {
-	nlcs := bars.NLCs
-	nbars := len(parameters.BARS)
-	nloads := 3 * (nbars - 1) * nlcs
-	nbars *= nlcs
-	adv := matrix.NewMatrix(nloads, nbars)
+	plan := models.CalibrationPlan(parameters.BARS)
+	adv := matrix.NewMatrix(len(plan), bars.TotalLCs())
 
-	for j := 0; j < nloads; j++ {
-		adv = weightCalibrationSingle(bars, parameters, adv, j)
+	for j, ld := range plan {
+		adv = weightCalibrationSingle(bars, parameters, adv, j, ld)
 	}
 	return adv
 }
 
-func weightCalibrationSingle(bars *serialpkg.Leo485, parameters *PARAMETERS, adv *matrix.Matrix, index int) *matrix.Matrix {
-	sb := fmt.Sprintf(calibmsg, parameters.WEIGHT, (BAY)(index/6), (LMR)((index/2)%3), (FB)(index%2))
+func weightCalibrationSingle(bars *serialpkg.Leo485, parameters *PARAMETERS, adv *matrix.Matrix, index int, ld models.LoadStep) *matrix.Matrix {
+	sb := fmt.Sprintf(calibmsg, parameters.WEIGHT, ld.Bay, ld.Side, ld.DepthString())
 	// Label as running index (left side): [0001], [0002], ...
 	lbl := fmt.Sprintf("[%04d]", index+1)
 	ads, ok := showADCLabel(bars, sb, lbl)
@@ -272,7 +265,7 @@ func weightCalibrationSingle(bars *serialpkg.Leo485, parameters *PARAMETERS, adv
 	}
 	// Empty line between final data and next phase instructions
 	fmt.Println()
-	return updateMatrixWeight(adv, ads, index, bars.NLCs)
+	return updateMatrixWeight(adv, ads, index)
 }
 
 func calcZerosFactors(adv, ad0 *matrix.Matrix, parameters *PARAMETERS) string {
@@ -320,12 +313,13 @@ func calcZerosFactors(adv, ad0 *matrix.Matrix, parameters *PARAMETERS) string {
 	}
 
 	nbars := len(parameters.BARS)
-	nlcs := zeros.Length / nbars
+	offs, _ := models.CellOffsets(parameters.BARS)
 
 	for i := 0; i < nbars; i++ {
+		nlcs := parameters.BARS[i].NLCs()
 		parameters.BARS[i].LC = make([]*LC, nlcs)
 		for j := 0; j < nlcs; j++ {
-			index := i*nlcs + j
+			index := offs[i] + j
 			lc := &LC{
 				ZERO:   uint64(zeros.Values[index]),
 				FACTOR: float32(factors.Values[index]),
@@ -337,97 +331,93 @@ func calcZerosFactors(adv, ad0 *matrix.Matrix, parameters *PARAMETERS) string {
 	return debug
 }
 
-func ProbeVersion(bars *serialpkg.Leo485, parameters *PARAMETERS) bool {
-	_, _, _, err := bars.GetVersion(0)
-	return err == nil
-}
-
-func checkVersion(bars *serialpkg.Leo485, parameters *PARAMETERS) bool {
-	// If no VERSION section in JSON, skip validation and just discover current version
-	if parameters.VERSION == nil {
-		parameters.VERSION = &VERSION{}
+// detectPort probes candidate ports (sweeping standard baud rates) and returns
+// the first that answered, listing every responder so the operator can pin the
+// right one in the JSON. A different working baud rate is applied to parameters.
+func detectPort(parameters *PARAMETERS) string {
+	found := serialpkg.DetectPortsWithOptions(parameters, serialpkg.DetectOptions{Sweep: true})
+	if len(found) == 0 {
+		return ""
 	}
-
-	// Get expected values from JSON configuration (0 means not specified)
-	expectedID := parameters.VERSION.ID
-	expectedMajor := parameters.VERSION.MAJOR
-	expectedMinor := parameters.VERSION.MINOR
-
-	var firstID, firstMajor, firstMinor int
-	anyError := false
-
-	for i := range bars.Bars {
-		id, major, minor, e := bars.GetVersion(i)
-		if e != nil {
-			log.Printf("Bar %d: version probe error: %v", i+1, e)
-			anyError = true
-			continue
-		}
-
-		// Print discovered version info with coloring for clarity
-		if expectedID != 0 && id != expectedID {
-			log.Printf("\033[31mBar %d: Unexpected Version ID %d (expected %d)\033[0m", i+1, id, expectedID)
-			anyError = true
-		} else if expectedMajor != 0 && major != expectedMajor {
-			ui.Greenf("Bar %d: Version major %d (expected %d)\n", i+1, major, expectedMajor)
-			// non-fatal
-		} else if expectedMinor != 0 && minor != expectedMinor {
-			ui.Greenf("Bar %d: Version minor %d (expected %d)\n", i+1, minor, expectedMinor)
-			// non-fatal
-		} else {
-			if expectedID == 0 && expectedMajor == 0 && expectedMinor == 0 {
-				// No expectations set, just show discovered version
-				ui.Greenf("Bar %d: Version discovered (ID=%d %d.%d)\n", i+1, id, major, minor)
-			} else {
-				ui.Greenf("Bar %d: Version OK (ID=%d %d.%d)\n", i+1, id, major, minor)
-			}
+	if len(found) > 1 {
+		ui.Warningf("Several ports answered the version probe:\n")
+		for _, c := range found {
+			ui.Warningf("  %s @ %d: %s (bars %v)\n", c.Port, c.Baud, c.Version, c.BarIDs)
 		}
+		ui.Warningf("Using %s; set SERIAL.PORT to choose another.\n", found[0].Port)
+	}
+	if found[0].Baud != parameters.SERIAL.BAUDRATE {
+		ui.Warningf("Device answered at %d baud (config says %d)\n", found[0].Baud, parameters.SERIAL.BAUDRATE)
+		parameters.SERIAL.BAUDRATE = found[0].Baud
+	}
+	return found[0].Port
+}
 
-		if firstID == 0 {
-			firstID = id
-			firstMajor = major
-			firstMinor = minor
+func ProbeVersion(bars *serialpkg.Leo485, parameters *PARAMETERS) bool {
+	// The first bar of every bus must answer.
+	for _, bus := range bars.Buses() {
+		if _, _, _, err := bus.GetVersion(0); err != nil {
+			return false
 		}
-
-		time.Sleep(200 * time.Millisecond)
 	}
+	return true
+}
+
+func checkVersion(bars *serialpkg.Leo485, parameters *PARAMETERS) bool {
+	checks, ok := PrintVersions(bars, parameters)
 
 	// Store discovered version in parameters if available
-	if firstID != 0 {
-		if parameters.VERSION == nil {
-			parameters.VERSION = &VERSION{}
+	for _, c := range checks {
+		if c.Firmware != nil {
+			v := *c.Firmware
+			parameters.VERSION = &v
+			break
 		}
-		parameters.VERSION.ID = firstID
-		parameters.VERSION.MAJOR = firstMajor
-		parameters.VERSION.MINOR = firstMinor
 	}
+	return ok
+}
 
-	return !anyError
+// PrintVersions asks every bar for its firmware version and prints a verdict
+// per bar against VERSIONS (or the firmware family of VERSION), plus a warning
+// when the shelf mixes versions. ok is false if a bar did not answer or runs
+// a version that is not allowed.
+func PrintVersions(bars *serialpkg.Leo485, parameters *PARAMETERS) (checks []serialpkg.VersionCheck, ok bool) {
+	checks = bars.CheckVersions(serialpkg.AllowedVersions(parameters))
+	for _, c := range checks {
+		switch {
+		case c.Error != "":
+			log.Printf("Bar %d: version probe error: %s", c.Bar, c.Error)
+		case !c.Compatible:
+			log.Printf("\033[31mBar %d: Version %s not allowed: %s\033[0m", c.Bar, c.Version, c.Reason)
+		default:
+			ui.Greenf("Bar %d: Version OK (%s)\n", c.Bar, c.Version)
+		}
+	}
+	if serialpkg.MixedVersions(checks) {
+		ui.Warningf("Warning: bars run different firmware versions\n")
+	}
+	return checks, serialpkg.Compatible(checks)
 }
 
-func updateMatrixZero(ads []int64, calibs, nlcs int) *matrix.Matrix {
+// updateMatrixZero repeats the flat zero reading on every one of the calibs
+// load rows.
+func updateMatrixZero(ads []int64, calibs int) *matrix.Matrix {
 	ad := matrix.NewVector(len(ads))
 	for i, v := range ads {
 		ad.Values[i] = float64(v)
 	}
 
-	// Suppress extra right-side marker in batch output
-	nbars := len(ads) / nlcs
-	ad0 := matrix.NewMatrix(calibs*nlcs, nbars*nlcs)
-	for i := 0; i < calibs*nlcs; i++ {
+	ad0 := matrix.NewMatrix(calibs, len(ads))
+	for i := 0; i < calibs; i++ {
 		ad0.SetRow(i, ad)
 	}
 	return ad0
 }
 
-func updateMatrixWeight(adc *matrix.Matrix, ads []int64, index, nlcs int) *matrix.Matrix {
-	// Suppress extra right-side stage number; left side shows it via interactive label
-	nbars := len(ads) / nlcs
-	for j := 0; j < nbars; j++ {
-		for i := 0; i < nlcs; i++ {
-			curr := j*nlcs + i
-			adc.Values[index][curr] = float64(ads[curr])
-		}
+// updateMatrixWeight stores the flat reading of load index as its row of adc.
+func updateMatrixWeight(adc *matrix.Matrix, ads []int64, index int) *matrix.Matrix {
+	for k := 0; k < adc.Cols && k < len(ads); k++ {
+		adc.Values[index][k] = float64(ads[k])
 	}
 	return adc
 }
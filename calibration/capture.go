@@ -0,0 +1,45 @@
+package calibration
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// DecodeCapture implements `calrunrilla decode`: it pretty-prints a frame
+// capture file written when SERIAL.CAPTURE is set.
+func DecodeCapture(args []string) {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	bar := fs.Int("bar", -1, "only show frames for this bar ID")
+	badOnly := fs.Bool("bad", false, "only show frames with an invalid CRC")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: calrunrilla decode [-bar N] [-bad] <capture.jsonl>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error reading file: %v", err)
+	}
+	defer f.Close()
+	recs, err := serialpkg.ReadCapture(f)
+	if err != nil {
+		log.Fatalf("Capture error: %v", err)
+	}
+	if len(recs) == 0 {
+		return
+	}
+	start := recs[0].Time
+	for _, rec := range recs {
+		if *bar >= 0 && rec.BarID != *bar {
+			continue
+		}
+		if *badOnly && rec.CRCValid {
+			continue
+		}
+		fmt.Println(serialpkg.FormatRecord(rec, start))
+	}
+}
@@ -0,0 +1,76 @@
+package calibration
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// DiscoverConfig implements `calrunrilla discover`: it scans the bus for bars,
+// infers each bar's LCS mask and firmware version, and writes a ready-to-use
+// config.json (or prints it when -out is empty).
+func DiscoverConfig(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	port := fs.String("port", "", "serial port or tcp://host:port (auto-detect when empty)")
+	baud := fs.Int("baud", 115200, "baud rate")
+	command := fs.String("command", "M", "measure command")
+	weight := fs.Int("weight", 500, "calibration WEIGHT for the generated config")
+	avg := fs.Int("avg", 10, "AVG for the generated config")
+	ignore := fs.Int("ignore", 0, "IGNORE for the generated config (defaults to AVG)")
+	out := fs.String("out", "", "write config to this file instead of stdout")
+	maxID := fs.Int("maxid", 9, fmt.Sprintf("highest bar address to scan (max %d)", serialpkg.MaxBarID))
+	_ = fs.Parse(args)
+
+	// Progress goes to stderr so the JSON on stdout can be redirected as-is.
+	info := func(format string, a ...interface{}) { fmt.Fprintf(os.Stderr, format, a...) }
+
+	ser := &models.SERIAL{PORT: *port, BAUDRATE: *baud, COMMAND: *command}
+	ids, err := serialpkg.DiscoverIDs(*maxID)
+	if err != nil {
+		log.Fatalf("Invalid -maxid: %v", err)
+	}
+	if ser.PORT == "" {
+		probe := &models.PARAMETERS{SERIAL: ser}
+		for _, id := range ids {
+			probe.BARS = append(probe.BARS, &models.BAR{ID: id})
+		}
+		found := serialpkg.DetectPortsWithOptions(probe, serialpkg.DetectOptions{Sweep: true})
+		if len(found) == 0 {
+			log.Fatal("Could not auto-detect serial port for discover")
+		}
+		ser.PORT = found[0].Port
+		ser.BAUDRATE = found[0].Baud
+		info("Using %s @ %d\n", ser.PORT, ser.BAUDRATE)
+	}
+
+	p, found, err := serialpkg.DiscoverParameters(ser, ids)
+	for _, b := range found {
+		info("Bar ID=%d LCS=%d Version %d.%d.%d ADC=%v\n", b.ID, b.LCS, b.Version.ID, b.Version.MAJOR, b.Version.MINOR, b.ADCs)
+	}
+	if err != nil {
+		log.Fatalf("Discover failed: %v", err)
+	}
+	p.WEIGHT = *weight
+	p.AVG = *avg
+	p.IGNORE = *ignore
+	if p.IGNORE <= 0 {
+		p.IGNORE = p.AVG
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		log.Fatalf("JSON error: %v", err)
+	}
+	if *out == "" {
+		fmt.Println(string(data))
+		return
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		log.Fatalf("Cannot write %s: %v", *out, err)
+	}
+	info("%s Saved\n", *out)
+}
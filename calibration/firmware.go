@@ -0,0 +1,124 @@
+package calibration
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	ui "github.com/CK6170/Calrunrilla-go/ui"
+)
+
+// FirmwareConfig implements `calrunrilla firmware`: it uploads a firmware
+// image to every bar of the config (or the bars named by -bars) over the
+// bootloader, one bar at a time, and checks the version each bar reports
+// after its reboot. The first failing bar stops the update.
+func FirmwareConfig(args []string) {
+	fs := flag.NewFlagSet("firmware", flag.ExitOnError)
+	barList := fs.String("bars", "", "comma-separated bar IDs to update (default all)")
+	ready := fs.Duration("ready", 10*time.Second, "how long a bar may take to answer V after the reboot")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatal("Usage: calrunrilla firmware [-bars 1,2] [-ready 10s] <image.bin> <config.json>")
+	}
+	img, err := serialpkg.LoadFirmware(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Firmware: %v", err)
+	}
+	configPath := fs.Arg(1)
+
+	jsonData, err := os.ReadFile(configPath)
+	if err != nil {
+		log.Fatalf("Error reading file: %v", err)
+	}
+	var parameters models.PARAMETERS
+	if err := json.Unmarshal(jsonData, &parameters); err != nil {
+		log.Fatalf("JSON error: %v", err)
+	}
+	if err := serialpkg.ValidateBars(parameters.BARS); err != nil {
+		log.Fatalf("Invalid BARS: %v", err)
+	}
+	ids, err := parseBarIDs(*barList)
+	if err != nil {
+		log.Fatalf("Invalid -bars: %v", err)
+	}
+	sel, err := serialpkg.SelectBars(parameters.BARS, ids)
+	if err != nil {
+		log.Fatalf("Invalid -bars: %v", err)
+	}
+	if sel == nil {
+		for i := range parameters.BARS {
+			sel = append(sel, i)
+		}
+	}
+	if parameters.SERIAL == nil {
+		log.Fatal("Missing SERIAL section in JSON")
+	}
+	if parameters.SERIAL.PORT == "" {
+		p := serialpkg.AutoDetectPort(&parameters)
+		if p == "" {
+			log.Fatal("Could not auto-detect serial port for firmware update")
+		}
+		parameters.SERIAL.PORT = p
+	}
+	bars := serialpkg.NewShelf(&parameters)
+	defer func() { _ = bars.Close() }()
+	if !ProbeVersion(bars, &parameters) {
+		log.Fatalf("ProbeVersion failed on %s", parameters.SERIAL.PORT)
+	}
+
+	version := "no version banner"
+	if img.Version != nil {
+		version = "version " + serialpkg.FormatVersion(*img.Version)
+	}
+	ui.Greenf("Firmware %s: %d bytes, %d blocks, CRC32 %08X, %s\n", fs.Arg(0), len(img.Data), serialpkg.Blocks(len(img.Data)), img.CRC, version)
+
+	for _, i := range sel {
+		ui.Greenf("\nBAR(%02d) ID=%d\n", i+1, parameters.BARS[i].ID)
+		inBlocks := false
+		res := bars.UploadFirmware(i, img, *ready, func(p serialpkg.FirmwareProgress) {
+			if p.Stage == "block" {
+				fmt.Printf("\r Block %d/%d (%d retries)", p.Block, p.Blocks, p.Retries)
+				inBlocks = true
+				return
+			}
+			if inBlocks {
+				fmt.Println()
+				inBlocks = false
+			}
+			ui.Debugf(parameters.DEBUG, " %s\n", p.Stage)
+		})
+		if inBlocks {
+			fmt.Println()
+		}
+		if !res.OK {
+			// Stop at the first failure: an image that breaks one bar would
+			// break the rest too.
+			log.Fatalf("Bar %d: firmware update failed: %s", i+1, res.Error)
+		}
+		ui.Greenf(" Updated to %s in %.1fs (%d retries)\n", serialpkg.FormatVersion(*res.Version), res.Duration/1000, res.Retries)
+	}
+}
+
+// parseBarIDs parses a comma-separated list of bar IDs; empty means none.
+func parseBarIDs(list string) ([]int, error) {
+	var ids []int
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("bar ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -8,15 +8,41 @@ import (
 	"strings"
 	"time"
 
-	"github.com/CK6170/Calrunrilla-go/matrix"
 	models "github.com/CK6170/Calrunrilla-go/models"
 	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
 	ui "github.com/CK6170/Calrunrilla-go/ui"
 )
 
-// flashOnly loads the parameters and performs a headless flash of bar parameters.
-func FlashOnly(configPath string) {
-	jsonData, err := os.ReadFile(configPath)
+// FlashOptions tunes a headless flash.
+type FlashOptions struct {
+	DryRun bool  // print the frames a flash would send without opening the port
+	Bars   []int // flash only these bar IDs; empty flashes every bar
+	Force  bool  // write bars that already hold the values too
+}
+
+// flashOnly loads the parameters and performs a headless flash of bar
+// parameters. The bars' current calibration is saved next to configPath first
+// so RollbackFlash can restore it.
+func FlashOnly(configPath string, opts FlashOptions) {
+	backedUp := false
+	flashFile(configPath, configPath, &backedUp, opts)
+}
+
+// RollbackFlash re-flashes the newest backup taken before a flash of
+// configPath (or of its _calibrated.json). No new backup is taken, so
+// repeating a rollback keeps restoring the same calibration.
+func RollbackFlash(configPath string, opts FlashOptions) {
+	path, err := latestBackup(configPath)
+	if err != nil {
+		log.Fatalf("Rollback failed: %v", err)
+	}
+	ui.Greenf("Rolling back to %s\n", path)
+	backedUp := true
+	flashFile(path, configPath, &backedUp, opts)
+}
+
+func flashFile(path, configPath string, backedUp *bool, opts FlashOptions) {
+	jsonData, err := os.ReadFile(path)
 	if err != nil {
 		log.Fatalf("Error reading file: %v", err)
 	}
@@ -24,6 +50,24 @@ func FlashOnly(configPath string) {
 	if err := json.Unmarshal(jsonData, &parameters); err != nil {
 		log.Fatalf("JSON error: %v", err)
 	}
+	if err := serialpkg.ValidateBars(parameters.BARS); err != nil {
+		log.Fatalf("Invalid BARS: %v", err)
+	}
+	sel, err := serialpkg.SelectBars(parameters.BARS, opts.Bars)
+	if err != nil {
+		log.Fatalf("Invalid --bars: %v", err)
+	}
+	if opts.DryRun {
+		if err := checkFlashable(&parameters); err != nil {
+			log.Fatalf("Dry run failed: %v", err)
+		}
+		frames, err := serialpkg.PlanFlash(parameters.BARS, sel)
+		if err != nil {
+			log.Fatalf("Dry run failed: %v", err)
+		}
+		printFlashPlan(frames)
+		return
+	}
 	if parameters.SERIAL == nil {
 		log.Fatal("Missing SERIAL section in JSON")
 	}
@@ -34,30 +78,121 @@ func FlashOnly(configPath string) {
 		}
 		parameters.SERIAL.PORT = p
 	}
-	bars := serialpkg.NewLeo485(parameters.SERIAL, parameters.BARS)
+	bars := serialpkg.NewShelf(&parameters)
 	defer func() { _ = bars.Close() }()
 	if !ProbeVersion(bars, &parameters) {
 		log.Fatalf("ProbeVersion failed on %s", parameters.SERIAL.PORT)
 	}
-	if err := flashParameters(bars, &parameters); err != nil {
+	if _, ok := PrintVersions(bars, &parameters); !ok {
+		ui.Warningf("Warning: version check failed, continuing anyway\n")
+	}
+	if err := flashWithBackup(bars, &parameters, sel, opts.Force, configPath, backedUp); err != nil {
 		log.Fatalf("Flash failed: %v", err)
 	}
 }
 
-func flashParameters(bars *serialpkg.Leo485, parameters *models.PARAMETERS) error {
+// flashWithBackup flashes the bars of parameters at positions sel (nil for
+// all). Unless force is set, bars that already hold the values are skipped
+// first. The calibration the bars hold is saved to a timestamped backup next
+// to configPath unless *backedUp is already set; a failed backup aborts
+// before anything is written.
+func flashWithBackup(bars *serialpkg.Leo485, parameters *models.PARAMETERS, sel []int, force bool, configPath string, backedUp *bool) error {
+	if !force {
+		sel = skipUnchanged(bars, parameters, sel)
+		if len(sel) == 0 {
+			ui.Greenf("All bars already hold these values; nothing to flash\n")
+			return nil
+		}
+	}
+	if !*backedUp {
+		path := newBackupPath(configPath, time.Now())
+		if err := backupDevice(bars, parameters, path); err != nil {
+			return fmt.Errorf("backup before flash: %w", err)
+		}
+		ui.Greenf("Current calibration saved to %s\n", path)
+		*backedUp = true
+	}
+	return flashParameters(bars, parameters, sel)
+}
+
+// skipUnchanged drops the bars at positions sel (nil for all) that already
+// hold their zeros, total and factors and returns the positions left to
+// flash.
+func skipUnchanged(bars *serialpkg.Leo485, parameters *models.PARAMETERS, sel []int) []int {
+	all := sel
+	if all == nil {
+		for i := range parameters.BARS {
+			all = append(all, i)
+		}
+	}
+	keep := make([]int, 0, len(all))
+	for _, i := range all {
+		cal, err := bars.ReadCalibration(i)
+		if err == nil && serialpkg.Unchanged(cal, parameters.BARS[i].LC) {
+			ui.Greenf("BAR(%02d) ID=%d unchanged, skipped\n", i+1, parameters.BARS[i].ID)
+			continue
+		}
+		if err != nil {
+			ui.Debugf(parameters.DEBUG, "Bar %d read-back failed, flashing it: %v\n", i+1, err)
+		} else if cal.Zeros == nil {
+			ui.Warningf("BAR(%02d) skip unavailable: %s; flashing it\n", i+1, serialpkg.ZerosUnavailable(bars.ZeroRead))
+		}
+		keep = append(keep, i)
+	}
+	return keep
+}
+
+// verifyReadyTimeout bounds how long a bar may take to answer 'V' after the
+// post-flash reboot.
+const verifyReadyTimeout = 5 * time.Second
+
+func flashParameters(bars *serialpkg.Leo485, parameters *models.PARAMETERS, sel []int) error {
 	if len(parameters.BARS) == 0 || len(parameters.BARS[0].LC) == 0 {
 		return nil
 	}
-	if err := bars.OpenToUpdate(); err != nil {
-		// Try one recovery step: reboot all bars and wait briefly, then retry OpenToUpdate once.
-		log.Printf("OpenToUpdate failed: %v. Attempting reboot of all bars and retrying...", err)
-		for i := range bars.Bars {
-			bars.Reboot(i)
-			time.Sleep(100 * time.Millisecond)
+	if err := checkFlashable(parameters); err != nil {
+		return err
+	}
+	// Hold the bus for the whole handshake-and-write sequence.
+	return bars.Exclusive(serialpkg.PriorityAdmin, func(bus *serialpkg.Leo485) error {
+		return flashParametersLocked(bus, parameters, sel)
+	})
+}
+
+// openAllToUpdate sends the broadcast Euler sequence. If no bar takes it, it
+// reboots every bar once and retries.
+func openAllToUpdate(bars *serialpkg.Leo485) error {
+	err := bars.OpenToUpdate()
+	if err == nil {
+		return nil
+	}
+	// Try one recovery step: reboot all bars and wait briefly, then retry OpenToUpdate once.
+	log.Printf("OpenToUpdate failed: %v. Attempting reboot of all bars and retrying...", err)
+	for i := range bars.Bars {
+		bars.Reboot(i)
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if err2 := bars.OpenToUpdate(); err2 != nil {
+		return fmt.Errorf("cannot enter update mode: %v; retry: %v", err, err2)
+	}
+	return nil
+}
+
+// flashParametersLocked flashes the bars at positions sel, or every bar when
+// sel is nil. Only a flash of every bar sends the broadcast Euler sequence; a
+// partial one relies on the addressed handshake so the other bars see no
+// traffic. The selected bars are then rebooted and each written one is
+// verified once it answers 'V' again.
+func flashParametersLocked(bars *serialpkg.Leo485, parameters *models.PARAMETERS, sel []int) error {
+	if sel == nil {
+		for i := range parameters.BARS {
+			sel = append(sel, i)
 		}
-		time.Sleep(1500 * time.Millisecond)
-		if err2 := bars.OpenToUpdate(); err2 != nil {
-			return fmt.Errorf("cannot enter update mode: %v; retry: %v", err, err2)
+	}
+	if len(sel) == len(parameters.BARS) {
+		if err := openAllToUpdate(bars); err != nil {
+			return err
 		}
 	}
 
@@ -65,16 +200,14 @@ func flashParameters(bars *serialpkg.Leo485, parameters *models.PARAMETERS) erro
 	// "Enter" asynchronously. Wait until all bars report the Enter prompt before
 	// proceeding to flash data. This prevents the earlier-first-attempt-fail behavior
 	// where one bar responds later than others.
-	notReady := make([]int, 0)
-	for i := 0; i < len(parameters.BARS); i++ {
-		notReady = append(notReady, i)
-	}
+	notReady := append([]int(nil), sel...)
 	// Retry loop: try up to 6 times (about ~3s total) to collect Enter from all bars.
 	for attempt := 1; attempt <= 6 && len(notReady) > 0; attempt++ {
 		remaining := make([]int, 0)
 		for _, idx := range notReady {
 			cmd := serialpkg.GetCommand(parameters.BARS[idx].ID, []byte(serialpkg.Euler))
-			resp, err := serialpkg.ChangeState(bars.Serial, cmd, 400)
+			link, _ := bars.Bus(idx)
+			resp, err := serialpkg.ChangeState(link.Serial, cmd, link.Timeouts.Handshake)
 			if err != nil {
 				if parameters.DEBUG {
 					ui.Debugf(true, "Euler handshake bar %d attempt %d err=%v resp=%q\n", idx+1, attempt, err, resp)
@@ -108,49 +241,38 @@ func flashParameters(bars *serialpkg.Leo485, parameters *models.PARAMETERS) erro
 	if parameters.DEBUG {
 		ui.Debugf(true, "All bars entered update mode; sending dummy CR to bays\n")
 	}
-	// send a single CR once to prime all bootloaders
-	_, _ = bars.Serial.Write([]byte{0x0D})
-	// small read to clear any immediate reply (use lower-level readUntil)
-	_, _ = serialpkg.ReadUntil(bars.Serial, 50)
+	// send a single CR once per bus to prime all bootloaders
+	for _, link := range bars.Buses() {
+		_, _ = link.Serial.Write([]byte{0x0D})
+		// small read to clear any immediate reply (use lower-level readUntil)
+		_, _ = serialpkg.ReadUntil(link.Serial, 50)
+	}
 
-	nbars := len(parameters.BARS)
-	for i := 0; i < nbars; i++ {
+	// failed collects bars whose write or verify went wrong; a bar that
+	// could not be written is still rebooted below but never verified.
+	var flashed []int
+	var failed []string
+	for _, i := range sel {
 		ui.Greenf("\nBAR(%02d)\n", i+1)
 		ui.Greenf(" ID=%d\n", parameters.BARS[i].ID)
-		lcs := activeLCs(parameters.BARS[i], 4)
+		lcs := activeLCs(parameters.BARS[i])
 		ui.Greenf(" LCS=%d\n", lcs)
 
-		nlcs := len(parameters.BARS[i].LC)
-		zero := matrix.NewVector(nlcs)
-		facs := matrix.NewVector(nlcs)
-		zeravg := 0.0
-		for j := 0; j < nlcs; j++ {
-			zero.Values[j] = float64(parameters.BARS[i].LC[j].ZERO)
-			facs.Values[j] = float64(parameters.BARS[i].LC[j].FACTOR)
-			zeravg += zero.Values[j] * facs.Values[j]
-		}
-		if zeravg < 0 {
-			zeravg = 0
+		if _, negative := serialpkg.ZeroTotal(parameters.BARS[i].LC); negative {
 			ui.Warningf("Avg. Zero reference is negative\n")
 		}
 		ui.Greenf(" Flashing Zeros:\n")
 		// Attempt to write zeros with retries and debug logging
-		// Build the O command payload same as WriteZeros expects
-		sb := "O"
-		k := 0
-		for ii := 0; ii < 4; ii++ {
-			if (parameters.BARS[i].LCS & (1 << ii)) != 0 {
-				sb += fmt.Sprintf("%09.0f|", zero.Values[k])
-				k++
-			} else {
-				sb += fmt.Sprintf("%09d|", 0)
-			}
+		// Build the O and X payloads exactly as a dry run lists them
+		sb, sb2, err := serialpkg.FlashPayloads(parameters.BARS[i])
+		if err != nil {
+			return fmt.Errorf("bar %d: %w", i+1, err)
 		}
-		sb += fmt.Sprintf("%09d|", uint64(zeravg/float64(nlcs)+0.5))
 		zeroCmd := serialpkg.GetCommand(parameters.BARS[i].ID, []byte(sb))
+		link, _ := bars.Bus(i)
 		wroteZeros := false
 		for attempt := 1; attempt <= 3; attempt++ {
-			resp, err := serialpkg.UpdateValue(bars.Serial, zeroCmd, 200)
+			resp, err := serialpkg.UpdateValue(link.Serial, zeroCmd, link.Timeouts.Write)
 			if err == nil && strings.Contains(resp, "OK") {
 				wroteZeros = true
 				if parameters.DEBUG {
@@ -165,25 +287,15 @@ func flashParameters(bars *serialpkg.Leo485, parameters *models.PARAMETERS) erro
 		}
 		if !wroteZeros {
 			fmt.Println(" Cannot flash Zeros to Bar")
+			failed = append(failed, fmt.Sprintf("bar %d (zeros not written)", i+1))
 			continue
 		}
 
 		ui.Greenf(" Flashing factors:\n")
-		// Build X command payload
-		sb2 := "X"
-		k2 := 0
-		for ii := 0; ii < 4; ii++ {
-			if (parameters.BARS[i].LCS & (1 << ii)) != 0 {
-				sb2 += fmt.Sprintf("%.10f|", facs.Values[k2])
-				k2++
-			} else {
-				sb2 += "1.0000000000|"
-			}
-		}
 		facCmd := serialpkg.GetCommand(parameters.BARS[i].ID, []byte(sb2))
 		wroteFacs := false
 		for attempt := 1; attempt <= 3; attempt++ {
-			resp, err := serialpkg.UpdateValue(bars.Serial, facCmd, 200)
+			resp, err := serialpkg.UpdateValue(link.Serial, facCmd, link.Timeouts.Write)
 			if err == nil && strings.Contains(resp, "OK") {
 				wroteFacs = true
 				if parameters.DEBUG {
@@ -198,22 +310,93 @@ func flashParameters(bars *serialpkg.Leo485, parameters *models.PARAMETERS) erro
 		}
 		if !wroteFacs {
 			fmt.Println(" Cannot flash Factors to Bar")
+			failed = append(failed, fmt.Sprintf("bar %d (factors not written)", i+1))
 			continue
 		}
 
-		if bars.Reboot(i) {
-			ui.Debugf(parameters.DEBUG, "Bar %d reboot command sent\n", i+1)
-		} else {
-			log.Printf("Bar %d reboot command failed or no response\n", i+1)
-		}
 		ui.Greenf(" Flashed!\n")
+		flashed = append(flashed, i)
+	}
+
+	// Reboot every selected bar, written or not, since all of them are in
+	// update mode; verify waits until each answers 'V' again.
+	ui.Greenf("\nRebooting %d bars\n", len(sel))
+	boot := make(map[int]serialpkg.RebootResult, len(sel))
+	for _, r := range bars.RebootAndWait(sel, verifyReadyTimeout) {
+		if !r.OK {
+			ui.Warningf(" %s\n", r.Error)
+		}
+		boot[r.Bar-1] = r
+	}
+
+	// Read back what survived the reboot.
+	for _, i := range flashed {
+		ui.Greenf("\nBAR(%02d) verify:\n", i+1)
+		res := serialpkg.VerifyResult{Bar: i + 1, ID: parameters.BARS[i].ID, Error: boot[i].Error}
+		if boot[i].OK {
+			res = bars.VerifyCalibration(i, parameters.BARS[i].LC)
+		}
+		switch {
+		case res.Error != "":
+			ui.Warningf(" Verify failed: %s\n", res.Error)
+		case !res.OK:
+			for _, m := range res.Mismatches {
+				ui.Warningf(" %s\n", m)
+			}
+		case !res.Zeros:
+			ui.Greenf(" Factors match (firmware does not report zeros)\n")
+		default:
+			ui.Greenf(" Zeros and factors match\n")
+		}
+		if !res.OK {
+			failed = append(failed, fmt.Sprintf("bar %d", i+1))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("flash failed for %s", strings.Join(failed, ", "))
 	}
 	return nil
 }
 
-func activeLCs(bar *models.BAR, maxLCs int) int {
+// checkFlashable makes sure every bar carries one zero/factor per active load
+// cell.
+func checkFlashable(parameters *models.PARAMETERS) error {
+	if len(parameters.BARS) == 0 || len(parameters.BARS[0].LC) == 0 {
+		return fmt.Errorf("no calibration values to flash")
+	}
+	for i, bar := range parameters.BARS {
+		if len(bar.LC) != bar.NLCs() {
+			return fmt.Errorf("bar %d: %d calibration values for %d load cells", i+1, len(bar.LC), bar.NLCs())
+		}
+	}
+	return nil
+}
+
+// printFlashPlan prints a dry run: every frame in send order with its CRC
+// and decoded payload fields.
+func printFlashPlan(frames []serialpkg.PlannedFrame) {
+	ui.Greenf("Dry run: %d frames, nothing is sent\n", len(frames))
+	for _, f := range frames {
+		target := "broadcast"
+		if f.BarID >= 0 {
+			target = fmt.Sprintf("bar %d (ID %d)", f.Bar, f.BarID)
+		}
+		crc := ""
+		if f.CRC != "" {
+			crc = "  CRC " + f.CRC
+		}
+		fmt.Printf("#%03d %-9s %-14s %-5s %s%s\n", f.Seq, f.Step, target, f.Command, f.Text, crc)
+		for _, fld := range f.Fields {
+			fmt.Printf("      %-28s %s\n", fld.Name, fld.Value)
+		}
+	}
+}
+
+// activeLCs lists the active slots as digits (LCS=5 gives 13). Slots are
+// numbered from 1, so an eight-slot bar can show up to 12345678.
+func activeLCs(bar *models.BAR) int {
 	n := 0
-	for i := 0; i < maxLCs; i++ {
+	for i := 0; i < bar.Slots(); i++ {
 		if (bar.LCS & (1 << i)) != 0 {
 			n = n*10 + (i + 1)
 		}
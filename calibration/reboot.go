@@ -0,0 +1,80 @@
+package calibration
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	ui "github.com/CK6170/Calrunrilla-go/ui"
+)
+
+// RebootConfig implements `calrunrilla reboot`: it reboots every bar of the
+// config (or the bars named by -bars), waits for each to answer V again and
+// prints how long each took to boot. It exits non-zero if a bar does not come
+// back.
+func RebootConfig(args []string) {
+	fs := flag.NewFlagSet("reboot", flag.ExitOnError)
+	barList := fs.String("bars", "", "comma-separated bar IDs to reboot (default all)")
+	ready := fs.Duration("ready", 10*time.Second, "how long a bar may take to answer V after the reboot")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: calrunrilla reboot [-bars 1,2] [-ready 10s] <config.json>")
+	}
+	configPath := fs.Arg(0)
+
+	jsonData, err := os.ReadFile(configPath)
+	if err != nil {
+		log.Fatalf("Error reading file: %v", err)
+	}
+	var parameters models.PARAMETERS
+	if err := json.Unmarshal(jsonData, &parameters); err != nil {
+		log.Fatalf("JSON error: %v", err)
+	}
+	if err := serialpkg.ValidateBars(parameters.BARS); err != nil {
+		log.Fatalf("Invalid BARS: %v", err)
+	}
+	ids, err := parseBarIDs(*barList)
+	if err != nil {
+		log.Fatalf("Invalid -bars: %v", err)
+	}
+	sel, err := serialpkg.SelectBars(parameters.BARS, ids)
+	if err != nil {
+		log.Fatalf("Invalid -bars: %v", err)
+	}
+	if parameters.SERIAL == nil {
+		log.Fatal("Missing SERIAL section in JSON")
+	}
+	if parameters.SERIAL.PORT == "" {
+		p := serialpkg.AutoDetectPort(&parameters)
+		if p == "" {
+			log.Fatal("Could not auto-detect serial port for reboot")
+		}
+		parameters.SERIAL.PORT = p
+	}
+	bars := serialpkg.NewShelf(&parameters)
+	defer func() { _ = bars.Close() }()
+
+	if !printReboot(bars.RebootAndWait(sel, *ready)) {
+		_ = bars.Close()
+		os.Exit(1)
+	}
+}
+
+// printReboot prints each bar's boot time or failure and reports whether
+// every bar came back.
+func printReboot(results []serialpkg.RebootResult) bool {
+	ok := true
+	for _, r := range results {
+		if !r.OK {
+			log.Printf("Bar %d (ID %d): %s", r.Bar, r.ID, r.Error)
+			ok = false
+			continue
+		}
+		ui.Greenf("Bar %d (ID %d): ready after %.0f ms\n", r.Bar, r.ID, r.BootMS)
+	}
+	return ok
+}
@@ -23,6 +23,9 @@ func TestWeightsConfig(configPath string) {
 	if err := json.Unmarshal(jsonData, &parameters); err != nil {
 		log.Fatalf("JSON error: %v", err)
 	}
+	if err := serialpkg.ValidateBars(parameters.BARS); err != nil {
+		log.Fatalf("Invalid BARS: %v", err)
+	}
 	if parameters.SERIAL == nil {
 		log.Fatal("Missing SERIAL section in JSON")
 	}
@@ -33,11 +36,14 @@ func TestWeightsConfig(configPath string) {
 		}
 		parameters.SERIAL.PORT = p
 	}
-	bars := serialpkg.NewLeo485(parameters.SERIAL, parameters.BARS)
+	bars := serialpkg.NewShelf(&parameters)
 	defer func() { _ = bars.Close() }()
 	if !ProbeVersion(bars, &parameters) {
 		log.Fatalf("ProbeVersion failed on %s", parameters.SERIAL.PORT)
 	}
+	if _, ok := PrintVersions(bars, &parameters); !ok {
+		ui.Warningf("Warning: version check failed, continuing anyway\n")
+	}
 	// If the config is not a calibrated file, attempt to read factors from the device.
 	if !strings.HasSuffix(strings.ToLower(configPath), "_calibrated.json") {
 		for i := 0; i < len(bars.Bars); i++ {
@@ -103,12 +109,11 @@ func TestWeights(bars *serialpkg.Leo485, parameters *PARAMETERS) {
 	// auto collect averaged zeros
 	// Only show the green countdown line from collectAveragedZeros
 	flatZeros := collectAveragedZeros(bars, parameters, parameters.AVG)
-	nlcs := bars.NLCs
 	zerosPerBar := make([][]int64, nbars)
 	for i := 0; i < nbars; i++ {
-		zerosPerBar[i] = make([]int64, nlcs)
-		for j := 0; j < nlcs; j++ {
-			idx := i*nlcs + j
+		zerosPerBar[i] = make([]int64, bars.NLCs[i])
+		for j := 0; j < bars.NLCs[i]; j++ {
+			idx := bars.Offset(i) + j
 			if idx < len(flatZeros) {
 				zerosPerBar[i][j] = flatZeros[idx]
 			}
@@ -121,7 +126,7 @@ func TestWeights(bars *serialpkg.Leo485, parameters *PARAMETERS) {
 	fmt.Println("zeros (averaged)")
 	for i := 0; i < nbars; i++ {
 		fmt.Printf("Bar %d zeros:\n", i+1)
-		for j := 0; j < nlcs; j++ {
+		for j := 0; j < bars.NLCs[i]; j++ {
 			fmt.Printf("[%03d]  %12d\n", j, zerosPerBar[i][j])
 		}
 		fmt.Println(matrix.MatrixLine)
@@ -135,8 +140,11 @@ func TestWeights(bars *serialpkg.Leo485, parameters *PARAMETERS) {
 	keyEvents := ui.StartKeyEvents()
 	firstPrint := false
 	lineWidth := 80
-	linesPerBar := nlcs + 3
-	totalLines := 3 + nbars*linesPerBar
+	// Each bar prints a title, one line per cell, the bar total and a blank line.
+	totalLines := 3
+	for i := 0; i < nbars; i++ {
+		totalLines += bars.NLCs[i] + 3
+	}
 	for {
 		if !firstPrint {
 			fmt.Printf("\033[%dA", totalLines)
@@ -145,15 +153,16 @@ func TestWeights(bars *serialpkg.Leo485, parameters *PARAMETERS) {
 		header := "Weight check results (press 'R' to Recalibrate, 'Z' to Re-zero, <ESC> to exit):"
 		fmt.Printf("\033[92m%-80s\033[0m\n\n", header)
 		grandTotal := 0.0
+		reads, errs := bars.GetAllADs(0)
 		for i := 0; i < nbars; i++ {
 			fmt.Printf("%-80s\n", fmt.Sprintf("Bar %d:", i+1))
 			barTotal := 0.0
-			ad, err := bars.GetADs(i)
+			ad, err := reads[i], errs[i]
 			if err != nil {
 				log.Printf("Bar %d read error: %v", i+1, err)
 				continue
 			}
-			for lc := 0; lc < nlcs; lc++ {
+			for lc := 0; lc < bars.NLCs[i]; lc++ {
 				adc := int64(0)
 				if lc < len(ad) {
 					adc = int64(ad[lc])
@@ -197,8 +206,8 @@ func TestWeights(bars *serialpkg.Leo485, parameters *PARAMETERS) {
 				// re-collect zeros silently and force header refresh
 				newZeros := collectAveragedZeros(bars, parameters, parameters.AVG)
 				for i := 0; i < nbars; i++ {
-					for j := 0; j < nlcs; j++ {
-						idx := i*nlcs + j
+					for j := 0; j < bars.NLCs[i]; j++ {
+						idx := bars.Offset(i) + j
 						if idx < len(newZeros) {
 							zerosPerBar[i][j] = newZeros[idx]
 						}
@@ -219,8 +228,7 @@ func TestWeights(bars *serialpkg.Leo485, parameters *PARAMETERS) {
 // collectAveragedZeros samples ADCs and returns averaged values
 func collectAveragedZeros(bars *serialpkg.Leo485, parameters *PARAMETERS, samples int) []int64 {
 	nb := len(bars.Bars)
-	nlcs := bars.NLCs
-	sums := make([]int64, nb*nlcs)
+	sums := make([]int64, bars.TotalLCs())
 	count := 0
 	// Warm-up/ignore: use IGNORE from parameters when available (fall back to 5)
 	warmup := 5
@@ -230,9 +238,7 @@ func collectAveragedZeros(bars *serialpkg.Leo485, parameters *PARAMETERS, sample
 	// Print a short warming-up message (magenta) which will be overwritten by the green countdown
 	fmt.Printf("\r\033[95mWarming up: %d quick samples...\033[0m\n", warmup)
 	for w := 0; w < warmup; w++ {
-		for i := 0; i < nb; i++ {
-			_, _ = bars.GetADs(i)
-		}
+		_, _ = bars.GetAllADs(0)
 		time.Sleep(5 * time.Millisecond)
 	}
 	for s := 0; s < samples; s++ {
@@ -248,18 +254,19 @@ func collectAveragedZeros(bars *serialpkg.Leo485, parameters *PARAMETERS, sample
 		}
 		// Only consider this iteration a valid sample if we received at least one ADC reading
 		gotAny := false
+		reads, errs := bars.GetAllADs(0)
 		for i := 0; i < nb; i++ {
-			ad, err := bars.GetADs(i)
+			ad, err := reads[i], errs[i]
 			if err != nil || len(ad) == 0 {
 				continue
 			}
 			gotAny = true
-			for lc := 0; lc < nlcs; lc++ {
+			for lc := 0; lc < bars.NLCs[i]; lc++ {
 				val := int64(0)
 				if lc < len(ad) {
 					val = int64(ad[lc])
 				}
-				idx := i*nlcs + lc
+				idx := bars.Offset(i) + lc
 				sums[idx] += val
 			}
 		}
@@ -268,21 +275,22 @@ func collectAveragedZeros(bars *serialpkg.Leo485, parameters *PARAMETERS, sample
 		}
 		time.Sleep(5 * time.Millisecond)
 	}
-	avg := make([]int64, nb*nlcs)
+	avg := make([]int64, bars.TotalLCs())
 	if count == 0 {
 		// If we collected no valid samples, try a one-shot read to fill zeros
 		if parameters != nil && parameters.DEBUG {
 			ui.Debugf(true, "No valid averaging samples collected; performing one-shot read for zeros\n")
 		}
 		any := false
+		reads, errs := bars.GetAllADs(0)
 		for i := 0; i < nb; i++ {
-			ad, err := bars.GetADs(i)
+			ad, err := reads[i], errs[i]
 			if err != nil || len(ad) == 0 {
 				continue
 			}
 			any = true
-			for lc := 0; lc < nlcs; lc++ {
-				idx := i*nlcs + lc
+			for lc := 0; lc < bars.NLCs[i]; lc++ {
+				idx := bars.Offset(i) + lc
 				if lc < len(ad) {
 					avg[idx] = int64(ad[lc])
 				} else {
@@ -305,20 +313,20 @@ func collectAveragedZeros(bars *serialpkg.Leo485, parameters *PARAMETERS, sample
 // used in the live loop) so the operator sees initial values immediately.
 func printWeightSnapshot(bars *serialpkg.Leo485, zerosPerBar [][]int64, parameters *PARAMETERS) {
 	nbars := len(parameters.BARS)
-	nlcs := bars.NLCs
 	lineWidth := 80
 	header := "Weight check results (press 'R' to Recalibrate, 'Z' to Re-zero, <ESC> to exit):"
 	fmt.Printf("\033[92m%-80s\033[0m\n\n", header)
 	grandTotal := 0.0
+	reads, errs := bars.GetAllADs(0)
 	for i := 0; i < nbars; i++ {
 		fmt.Printf("%-80s\n", fmt.Sprintf("Bar %d:", i+1))
 		barTotal := 0.0
-		ad, err := bars.GetADs(i)
+		ad, err := reads[i], errs[i]
 		if err != nil {
 			log.Printf("Bar %d read error: %v", i+1, err)
 			continue
 		}
-		for lc := 0; lc < nlcs; lc++ {
+		for lc := 0; lc < bars.NLCs[i]; lc++ {
 			adc := int64(0)
 			if lc < len(ad) {
 				adc = int64(ad[lc])
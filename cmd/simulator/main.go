@@ -0,0 +1,215 @@
+// Command `calrunrilla-sim` runs a simulated Leo485 shelf so the unmodified
+// CLI and `calrunrilla-server` can be exercised without hardware.
+//
+// By default the bus is exposed on a Linux pseudo-terminal whose path is
+// printed at startup (use it as SERIAL.PORT). With -tcp the same bus is also
+// served to raw TCP clients (SERIAL.PORT "tcp://host:port").
+//
+// Flags:
+//
+//	-config: build bars from an existing config.json (BARS, COMMAND, VERSION)
+//	-bars:   number of bars when no config is given (default 2)
+//	-lcs:    active load-cell mask for every bar (default 3)
+//	-slots:  load-cell fields per frame (default 4, or 8 when -lcs uses bits 4-7)
+//	-seed:   random seed for cell parameters and noise
+//	-link:   also create a symlink to the PTY at this path
+//	-tcp:    listen address for raw TCP clients
+//	-pty:    expose the bus on a pseudo-terminal (default true)
+//	-block-errors: fraction of firmware upload blocks answered ERR
+//	-make-firmware: write a fake firmware image carrying -firmware-version
+//	         (e.g. "12009.1.203") to this path and exit
+//
+// Weights are placed by typing commands on stdin; type "help" for the list.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+func main() {
+	var (
+		configPath = flag.String("config", "", "config.json to take BARS/COMMAND/VERSION from")
+		nbars      = flag.Int("bars", 2, "number of bars (ignored with -config)")
+		lcs        = flag.Int("lcs", 3, "active load-cell mask per bar (ignored with -config)")
+		slots      = flag.Int("slots", 0, "load-cell fields per frame, 0 derives it from -lcs (ignored with -config)")
+		seed       = flag.Int64("seed", 1, "random seed")
+		link       = flag.String("link", "", "create a symlink to the PTY at this path")
+		tcpAddr    = flag.String("tcp", "", "also serve raw TCP clients on this address")
+		usePTY     = flag.Bool("pty", true, "expose the bus on a pseudo-terminal")
+		blockErrs  = flag.Float64("block-errors", 0, "fraction of firmware blocks answered ERR")
+		crcErrs    = flag.Float64("crc-errors", 0, "fraction of replies sent with a broken CRC")
+		makeFW     = flag.String("make-firmware", "", "write a fake firmware image to this path and exit")
+		fwVersion  = flag.String("firmware-version", "12009.1.203", "version banner of -make-firmware")
+		fwSize     = flag.Int("firmware-size", 16384, "size in bytes of -make-firmware")
+	)
+	flag.Parse()
+
+	if *makeFW != "" {
+		v, err := parseVersion(*fwVersion)
+		if err != nil {
+			log.Fatalf("invalid -firmware-version: %v", err)
+		}
+		if err := os.WriteFile(*makeFW, simulator.FirmwareImage(v, *fwSize, *seed), 0o644); err != nil {
+			log.Fatalf("write firmware: %v", err)
+		}
+		log.Printf("Firmware %s (%d bytes) written to %s", *fwVersion, *fwSize, *makeFW)
+		return
+	}
+
+	var bus *simulator.Bus
+	if *configPath != "" {
+		raw, err := os.ReadFile(*configPath)
+		if err != nil {
+			log.Fatalf("Error reading file: %v", err)
+		}
+		var p models.PARAMETERS
+		if err := json.Unmarshal(raw, &p); err != nil {
+			log.Fatalf("JSON error: %v", err)
+		}
+		if err := serialpkg.ValidateBars(p.BARS); err != nil {
+			log.Fatalf("Invalid BARS: %v", err)
+		}
+		bus = simulator.NewFromParameters(&p, *seed)
+	} else {
+		if *lcs <= 0 || *lcs > 0xFF || *slots < 0 || *slots > models.MAXLCS {
+			log.Fatalf("invalid -lcs %d / -slots %d", *lcs, *slots)
+		}
+		bus = simulator.New(simulator.Config{Bars: *nbars, LCS: byte(*lcs), Slots: *slots, Seed: *seed})
+	}
+	bus.BlockErrorRate = *blockErrs
+	bus.CRCErrorRate = *crcErrs
+
+	if *usePTY {
+		pty, err := simulator.OpenPTY()
+		if err != nil {
+			log.Fatalf("PTY: %v", err)
+		}
+		defer func() { _ = pty.Close() }()
+		if *link != "" {
+			_ = os.Remove(*link)
+			if err := os.Symlink(pty.Path, *link); err != nil {
+				log.Fatalf("symlink: %v", err)
+			}
+			defer func() { _ = os.Remove(*link) }()
+		}
+		log.Printf("Simulated bus on %s", pty.Path)
+		go func() {
+			if err := pty.Serve(bus); err != nil {
+				log.Printf("PTY serve: %v", err)
+			}
+		}()
+	}
+	if *tcpAddr != "" {
+		ln, err := net.Listen("tcp", *tcpAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", *tcpAddr, err)
+		}
+		log.Printf("Simulated bus on tcp://%s", ln.Addr())
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					_ = bus.Serve(conn)
+				}()
+			}
+		}()
+	}
+	for _, b := range bus.Bars() {
+		log.Printf("Bar ID=%d LCS=%d slots=%d cells=%d", b.ID, b.LCS, b.Slots, len(b.Cells))
+	}
+	repl(bus)
+}
+
+// parseVersion parses "<id>.<major>.<minor>".
+func parseVersion(s string) (models.VERSION, error) {
+	var v models.VERSION
+	if _, err := fmt.Sscanf(s, "%d.%d.%d", &v.ID, &v.MAJOR, &v.MINOR); err != nil {
+		return v, fmt.Errorf("%q: want <id>.<major>.<minor>", s)
+	}
+	return v, nil
+}
+
+// repl reads weight placement commands from stdin until EOF or "quit".
+func repl(bus *simulator.Bus) {
+	sides := map[string]models.LMR{"L": models.LEFT, "M": models.MIDDLE, "R": models.RIGHT}
+	fbs := map[string]models.FB{"F": models.FRONT, "B": models.BACK}
+	sc := bufio.NewScanner(os.Stdin)
+	fmt.Println(`Type "help" for commands.`)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		var err error
+		switch strings.ToLower(f[0]) {
+		case "help":
+			fmt.Println("clear                          remove all weights")
+			fmt.Println("place <bay> <L|M|R> <F|B> <w>  put weight w on a bay position (bay is 0-based)")
+			fmt.Println("step <j> <w>                   put weight w where calibration load j (0-based) goes")
+			fmt.Println("stored                         show zeros/factors held by each bar")
+			fmt.Println("version                        show the firmware version of each bar")
+			fmt.Println("quit                           exit")
+		case "clear":
+			bus.Clear()
+		case "place":
+			if len(f) != 5 {
+				err = fmt.Errorf("usage: place <bay> <L|M|R> <F|B> <w>")
+				break
+			}
+			bay, e1 := strconv.Atoi(f[1])
+			w, e2 := strconv.ParseFloat(f[4], 64)
+			side, ok1 := sides[strings.ToUpper(f[2])]
+			fb, ok2 := fbs[strings.ToUpper(f[3])]
+			if e1 != nil || e2 != nil || !ok1 || !ok2 {
+				err = fmt.Errorf("usage: place <bay> <L|M|R> <F|B> <w>")
+				break
+			}
+			err = bus.PlaceWeight(models.BAY(bay), side, fb, w)
+		case "step":
+			if len(f) != 3 {
+				err = fmt.Errorf("usage: step <j> <w>")
+				break
+			}
+			j, e1 := strconv.Atoi(f[1])
+			w, e2 := strconv.ParseFloat(f[2], 64)
+			if e1 != nil || e2 != nil {
+				err = fmt.Errorf("usage: step <j> <w>")
+				break
+			}
+			err = bus.PlaceStep(j, w)
+		case "stored":
+			for _, b := range bus.Bars() {
+				zeros, factors, _ := bus.Stored(b.ID)
+				fmt.Printf("Bar ID=%d zeros=%v factors=%v\n", b.ID, zeros, factors)
+			}
+		case "version":
+			for _, b := range bus.Bars() {
+				v, _ := bus.Version(b.ID)
+				fmt.Printf("Bar ID=%d version %s\n", b.ID, serialpkg.FormatVersion(v))
+			}
+		case "quit", "exit":
+			return
+		default:
+			err = fmt.Errorf("unknown command %q", f[0])
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
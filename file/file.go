@@ -31,23 +31,7 @@ func PersistParameters(path string, parameters *PARAMETERS) {
 	}
 }
 func SaveToJSON(file string, parameters *PARAMETERS, appVer string, appBuild string) {
-	// Build a small payload that includes SERIAL, BARS and desired runtime
-	// defaults so the saved _calibrated.json contains AVG, IGNORE and DEBUG.
-	payload := struct {
-		SERIAL *SERIAL `json:"SERIAL"`
-		BARS   []*BAR  `json:"BARS"`
-		AVG    int     `json:"AVG"`
-		IGNORE int     `json:"IGNORE"`
-		DEBUG  bool    `json:"DEBUG"`
-	}{
-		SERIAL: parameters.SERIAL,
-		BARS:   parameters.BARS,
-		AVG:    parameters.AVG,
-		IGNORE: parameters.IGNORE,
-		DEBUG:  parameters.DEBUG,
-	}
-	data, _ := json.MarshalIndent(payload, "", "  ")
-	if err := os.WriteFile(file, data, 0644); err != nil {
+	if err := WriteCalibrated(file, parameters); err != nil {
 		ui.Warningf("Warning: failed to write JSON file: %v\n", err)
 		return
 	}
@@ -63,6 +47,31 @@ func SaveToJSON(file string, parameters *PARAMETERS, appVer string, appBuild str
 	}
 }
 
+// WriteCalibrated writes the _calibrated.json payload: SERIAL, BUSES, BARS and the
+// runtime defaults AVG, IGNORE and DEBUG.
+func WriteCalibrated(file string, parameters *PARAMETERS) error {
+	payload := struct {
+		SERIAL *SERIAL   `json:"SERIAL"`
+		BUSES  []*SERIAL `json:"BUSES,omitempty"`
+		BARS   []*BAR    `json:"BARS"`
+		AVG    int       `json:"AVG"`
+		IGNORE int       `json:"IGNORE"`
+		DEBUG  bool      `json:"DEBUG"`
+	}{
+		SERIAL: parameters.SERIAL,
+		BUSES:  parameters.BUSES,
+		BARS:   parameters.BARS,
+		AVG:    parameters.AVG,
+		IGNORE: parameters.IGNORE,
+		DEBUG:  parameters.DEBUG,
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
 func AppendToFile(file, content string) {
 	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -6,7 +6,6 @@ require (
 	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203
 	github.com/gorilla/websocket v1.5.3
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
+	golang.org/x/sys v0.38.0
 	gonum.org/v1/gonum v0.16.0
 )
-
-require golang.org/x/sys v0.38.0 // indirect
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// handleDeviceBackup reads the calibration every bar currently holds in flash
+// and stores it as a calibrated JSON that can be downloaded or re-flashed.
+//
+// Response shape: BackupResponse.
+func (s *Server) handleDeviceBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	s.dev.mu.Lock()
+	if s.dev.bars == nil || s.dev.params == nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 400, APIError{Error: "not connected"})
+		return
+	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
+	// Reads may interleave with the test loop, but not with a write or reboot
+	// in progress.
+	if s.dev.writingLocked() {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 400, APIError{Error: "busy"})
+		return
+	}
+	bars := s.dev.bars.WithPriority(serialpkg.PriorityAdmin)
+	p := s.dev.params
+	configID := s.dev.configID
+	s.dev.mu.Unlock()
+
+	backup, err := cloneParameters(p)
+	if err != nil {
+		s.writeJSON(w, 500, APIError{Error: err.Error()})
+		return
+	}
+	zeros, err := readFactorsFromDevice(bars, backup)
+	if err != nil {
+		s.writeJSON(w, 500, apiError(err))
+		return
+	}
+	raw, err := encodeCalibratedJSON(backup)
+	if err != nil {
+		s.writeJSON(w, 500, APIError{Error: err.Error()})
+		return
+	}
+	name := "backup_calibrated.json"
+	if baseRec, ok := s.store.Get(configID); ok && baseRec != nil && baseRec.Filename != "" {
+		base := filepath.Base(baseRec.Filename)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		base = strings.TrimSuffix(base, "_calibrated")
+		name = base + "_backup_calibrated.json"
+	}
+	rec, err := s.store.Put(kindCalibrated, raw, backup, name)
+	if err != nil {
+		s.writeJSON(w, 500, APIError{Error: err.Error()})
+		return
+	}
+	s.writeJSON(w, 200, BackupResponse{CalibratedID: rec.ID, Filename: name, Zeros: zeros})
+}
+
+// backupHook returns the backup callback for flashParameters: it stores the
+// read-back calibration as a timestamped calibrated record, remembers it and
+// the flashed barIDs as the rollback target and reports it through progress.
+func (s *Server) backupHook(progress func(map[string]interface{}), barIDs []int) func(*models.PARAMETERS) error {
+	return func(backup *models.PARAMETERS) error {
+		raw, err := encodeCalibratedJSON(backup)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("backup_%s_calibrated.json", time.Now().Format("20060102_150405"))
+		rec, err := s.store.Put(kindCalibrated, raw, backup, name)
+		if err != nil {
+			return err
+		}
+		s.dev.mu.Lock()
+		s.dev.lastBackupID = rec.ID
+		s.dev.lastBackupBars = barIDs
+		s.dev.mu.Unlock()
+		progress(map[string]interface{}{
+			"stage":    "backup",
+			"message":  "Current calibration saved as " + name,
+			"backupId": rec.ID,
+			"filename": name,
+			"created":  rec.Created,
+		})
+		return nil
+	}
+}
+
+// handleFlashRollback re-flashes a pre-flash backup, by default the one taken
+// before the most recent flash, to the bars that flash touched. The rollback
+// itself takes no new backup, so repeating it keeps restoring the same
+// calibration.
+//
+// Request shape: FlashRollbackRequest (body optional).
+func (s *Server) handleFlashRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var req FlashRollbackRequest
+	if r.ContentLength != 0 {
+		if err := s.readJSON(r, &req); err != nil {
+			s.writeJSON(w, 400, APIError{Error: err.Error()})
+			return
+		}
+	}
+	if req.BackupID == "" {
+		s.dev.mu.Lock()
+		req.BackupID = s.dev.lastBackupID
+		if req.Bars == nil {
+			req.Bars = s.dev.lastBackupBars
+		}
+		s.dev.mu.Unlock()
+	}
+	if req.BackupID == "" {
+		s.writeJSON(w, 404, APIError{Error: "no backup to roll back to (flash once first)"})
+		return
+	}
+	rec, ok := s.store.Get(req.BackupID)
+	if !ok || rec.Kind != kindCalibrated {
+		s.writeJSON(w, 404, APIError{Error: "backupId not found"})
+		return
+	}
+	s.startFlash(w, rec.P, flashOptions{barIDs: req.Bars, force: req.Force}, false)
+}
+
+// cloneParameters deep-copies p so device read-backs do not overwrite the
+// session's configuration.
+func cloneParameters(p *models.PARAMETERS) (*models.PARAMETERS, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var out models.PARAMETERS
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -2,65 +2,57 @@ package server
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/CK6170/Calrunrilla-go/models"
 	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
-	goserial "github.com/tarm/serial"
 )
 
-// openBars opens the serial port and constructs a Leo485 without using serial.NewLeo485
+// openBars opens every configured bus (serial port or TCP gateway) of p and
+// constructs one Leo485 over all of its bars without using serial.NewShelf
 // (which log.Fatal's on error).
-func openBars(ser *models.SERIAL, bars []*models.BAR) (*serialpkg.Leo485, error) {
-	if ser == nil {
+func openBars(p *models.PARAMETERS) (*serialpkg.Leo485, error) {
+	if p.SERIAL == nil {
 		return nil, fmt.Errorf("missing SERIAL")
 	}
-	if ser.PORT == "" {
-		return nil, fmt.Errorf("missing SERIAL.PORT")
-	}
-	if len(bars) == 0 {
+	if len(p.BARS) == 0 {
 		return nil, fmt.Errorf("no BARS configured")
 	}
+	return serialpkg.OpenShelf(p)
+}
 
-	cfg := &goserial.Config{
-		Name:        ser.PORT,
-		Baud:        ser.BAUDRATE,
-		Parity:      goserial.ParityNone,
-		Size:        8,
-		StopBits:    goserial.Stop1,
-		ReadTimeout: time.Millisecond * 300,
-	}
-	port, err := goserial.OpenPort(cfg)
+// connectBars opens p like openBars and checks that the first bar of every bus
+// answers a version probe, so a dead or wrong port is not taken as connected.
+func connectBars(p *models.PARAMETERS) (*serialpkg.Leo485, error) {
+	bars, err := openBars(p)
 	if err != nil {
 		return nil, err
 	}
-
-	l := &serialpkg.Leo485{
-		Serial:       port,
-		Bars:         bars,
-		NLCs:         countActiveLCs(bars[0].LCS),
-		SerialConfig: ser,
-	}
-	if l.NLCs <= 0 {
-		_ = port.Close()
-		return nil, fmt.Errorf("invalid LCS bitmask on first bar")
-	}
-	for _, b := range bars {
-		if countActiveLCs(b.LCS) != l.NLCs {
-			_ = port.Close()
-			return nil, fmt.Errorf("number of active load cells per bar must match")
+	// Every bus must answer, not just the one carrying the first bar.
+	for _, bus := range bars.Buses() {
+		if _, _, _, err := bus.GetVersion(0); err != nil {
+			_ = bars.Close()
+			if len(p.BUSES) > 0 {
+				return nil, fmt.Errorf("device version probe failed on bus %s: %w", serialpkg.BusName(bus.SerialConfig), err)
+			}
+			return nil, fmt.Errorf("device version probe failed: %w", err)
 		}
 	}
-	return l, nil
+	return bars, nil
 }
 
-func countActiveLCs(lcs byte) int {
-	n := 0
-	for i := 0; i < 8; i++ {
-		if (lcs & (1 << i)) != 0 {
-			n++
-		}
-	}
-	return n
+// withPort returns a copy of p whose SERIAL uses port and, when baud is not
+// 0, baud. p itself is left alone, so a port that fails to connect never
+// reaches the stored config.
+func withPort(p *models.PARAMETERS, port string, baud int) *models.PARAMETERS {
+	if p.SERIAL == nil {
+		return p
+	}
+	c := *p
+	ser := *p.SERIAL
+	ser.PORT = port
+	if baud > 0 {
+		ser.BAUDRATE = baud
+	}
+	c.SERIAL = &ser
+	return &c
 }
-
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// diagnosticsInterval is how often /ws/diagnostics clients get fresh counters.
+const diagnosticsInterval = time.Second
+
+// diagnostics snapshots the link counters of the connected bus; ok is false
+// when not connected.
+func (s *Server) diagnostics() (DiagnosticsResponse, bool) {
+	s.dev.mu.Lock()
+	bars := s.dev.bars
+	out := DiagnosticsResponse{LinkState: s.dev.linkState, Reconnects: s.dev.reconnects}
+	s.dev.mu.Unlock()
+	if bars == nil {
+		return out, false
+	}
+	out.Link = bars.LinkReport()
+	out.Bus = bars.BusStats()
+	return out, true
+}
+
+// handleDiagnostics returns per-bar link counters (requests, timeouts, CRC
+// errors, wrong-ID frames, reply latency) since connect or the last reset.
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	d, ok := s.diagnostics()
+	if !ok {
+		s.writeJSON(w, 400, APIError{Error: "not connected"})
+		return
+	}
+	s.writeJSON(w, 200, d)
+}
+
+// handleDiagnosticsReset zeroes the link counters and pushes the cleared
+// snapshot to /ws/diagnostics.
+func (s *Server) handleDiagnosticsReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	s.dev.mu.Lock()
+	bars := s.dev.bars
+	s.dev.mu.Unlock()
+	if bars == nil {
+		s.writeJSON(w, 400, APIError{Error: "not connected"})
+		return
+	}
+	bars.ResetLinkStats()
+	d, _ := s.diagnostics()
+	s.wsDiag.Broadcast(WSMessage{Type: "linkStats", Data: d})
+	s.writeJSON(w, 200, d)
+}
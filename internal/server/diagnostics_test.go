@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+func TestDiagnosticsJSON(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3}, &models.BAR{ID: 1, LCS: 3})
+	bars, _ := simShelf(t, p)
+	s := New(t.TempDir())
+
+	call := func(method, path string) (int, DiagnosticsResponse, string) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader("")))
+		var d DiagnosticsResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+				t.Fatalf("%s %s: %v in %s", method, path, err, w.Body.String())
+			}
+		}
+		return w.Code, d, w.Body.String()
+	}
+
+	if code, _, body := call("GET", "/api/diagnostics"); code != http.StatusBadRequest || !strings.Contains(body, "not connected") {
+		t.Errorf("diagnostics without a device: %d %s", code, body)
+	}
+
+	s.dev.bars, s.dev.params = bars, p
+	for i := range p.BARS {
+		for k := 0; k < i+1; k++ {
+			if _, _, _, err := bars.GetVersion(i); err != nil {
+				t.Fatalf("GetVersion(%d): %v", i, err)
+			}
+		}
+	}
+
+	code, d, body := call("GET", "/api/diagnostics")
+	if code != http.StatusOK {
+		t.Fatalf("GET /api/diagnostics: %d %s", code, body)
+	}
+	for _, key := range []string{`"link"`, `"bus"`, `"since"`, `"latencyBucketsMs"`, `"barId"`, `"crcErrors"`, `"reconnects"`} {
+		if !strings.Contains(body, key) {
+			t.Errorf("diagnostics JSON lacks %s: %s", key, body)
+		}
+	}
+	if len(d.Link.Bars) != len(p.BARS) {
+		t.Fatalf("%d bars in diagnostics, want %d", len(d.Link.Bars), len(p.BARS))
+	}
+	for i, b := range d.Link.Bars {
+		if b.BarID != p.BARS[i].ID || b.Requests != i+1 || b.OK != i+1 || b.Timeouts != 0 || b.CRCErrors != 0 {
+			t.Errorf("bar %d: %+v, want %d clean requests", i+1, b, i+1)
+		}
+	}
+
+	code, d, body = call("POST", "/api/diagnostics/reset")
+	if code != http.StatusOK {
+		t.Fatalf("POST /api/diagnostics/reset: %d %s", code, body)
+	}
+	for _, b := range d.Link.Bars {
+		if b.Requests != 0 || b.OK != 0 {
+			t.Errorf("bar ID %d after reset: %+v", b.BarID, b)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// firmwareReadyTimeout bounds how long a bar may take to answer 'V' after
+// booting a new image.
+const firmwareReadyTimeout = 10 * time.Second
+
+// handleFirmwareUpload uploads a firmware image to the connected bars over
+// the bootloader, one bar at a time, in the background. Progress, per-bar
+// results and the final outcome are sent on /ws/firmware; the first failing
+// bar stops the update.
+//
+// Request: multipart form with "file" (raw binary image) and optional "bars"
+// (comma-separated bar IDs, default all). Response shape:
+// FirmwareUploadResponse.
+func (s *Server) handleFirmwareUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	f, _, err := fileFromMultipart(r, "file")
+	if err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	defer f.Close()
+	raw, err := io.ReadAll(io.LimitReader(f, 4<<20))
+	if err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	img, err := serialpkg.ParseFirmware(raw)
+	if err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	var ids []int
+	for _, field := range strings.Split(r.FormValue("bars"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			s.writeJSON(w, 400, APIError{Error: "invalid bar ID " + strconv.Quote(field)})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	s.dev.mu.Lock()
+	if s.dev.bars == nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 400, APIError{Error: "not connected"})
+		return
+	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
+	if s.dev.writingLocked() {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 409, APIError{Error: "busy"})
+		return
+	}
+	bars := s.dev.bars
+	sel, err := serialpkg.SelectBars(bars.Bars, ids)
+	if err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	if sel == nil {
+		for i := range bars.Bars {
+			sel = append(sel, i)
+		}
+	}
+	s.dev.cancelLocked()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.dev.opCancel = cancel
+	s.dev.opKind = "firmware"
+	s.dev.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.dev.mu.Lock()
+			if s.dev.opKind == "firmware" {
+				s.dev.opKind = ""
+				s.dev.opCancel = nil
+			}
+			s.dev.mu.Unlock()
+		}()
+		var results []serialpkg.FirmwareResult
+		for _, i := range sel {
+			// A bar's transfer is never cut short; stop only between bars.
+			if ctx.Err() != nil {
+				s.wsFirmware.Broadcast(WSMessage{Type: "error", Data: apiError(ctx.Err())})
+				return
+			}
+			res := bars.UploadFirmware(i, img, firmwareReadyTimeout, func(p serialpkg.FirmwareProgress) {
+				s.wsFirmware.Broadcast(WSMessage{Type: "progress", Data: p})
+			})
+			results = append(results, res)
+			s.wsFirmware.Broadcast(WSMessage{Type: "result", Data: res})
+			if !res.OK {
+				s.wsFirmware.Broadcast(WSMessage{Type: "error", Data: map[string]interface{}{"error": res.Error, "bar": res.Bar, "results": results}})
+				return
+			}
+		}
+		s.wsFirmware.Broadcast(WSMessage{Type: "done", Data: map[string]interface{}{"results": results}})
+	}()
+
+	resp := FirmwareUploadResponse{Size: len(img.Data), Blocks: serialpkg.Blocks(len(img.Data)), CRC: fmt.Sprintf("%08X", img.CRC)}
+	if img.Version != nil {
+		resp.Version = serialpkg.FormatVersion(*img.Version)
+	}
+	s.writeJSON(w, 200, resp)
+}
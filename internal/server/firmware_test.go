@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+func TestWritersDoNotCancelEachOther(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3}, &models.BAR{ID: 1, LCS: 3})
+	bars, _ := simShelf(t, p)
+	s := New(t.TempDir())
+	rec, err := s.store.Put(kindCalibrated, []byte("{}"), p, "shelf_calibrated.json")
+	if err != nil {
+		t.Fatalf("store.Put: %v", err)
+	}
+	s.dev.bars, s.dev.params, s.dev.configID = bars, p, rec.ID
+
+	var fw bytes.Buffer
+	mw := multipart.NewWriter(&fw)
+	part, _ := mw.CreateFormFile("file", "fw.bin")
+	_, _ = part.Write(simulator.FirmwareImage(simulator.DefaultVersion, 256, 1))
+	_ = mw.Close()
+
+	requests := []struct {
+		path, contentType string
+		body              func() *bytes.Reader
+	}{
+		{"/api/flash/start", "application/json", func() *bytes.Reader {
+			return bytes.NewReader([]byte(`{"calibratedId": "` + rec.ID + `"}`))
+		}},
+		{"/api/calibration/startStep", "application/json", func() *bytes.Reader {
+			return bytes.NewReader([]byte(`{"stepIndex": 0}`))
+		}},
+		{"/api/firmware/upload", mw.FormDataContentType(), func() *bytes.Reader {
+			return bytes.NewReader(fw.Bytes())
+		}},
+	}
+	for _, running := range []string{"flash", "calibrationFlash", "firmware", "reboot"} {
+		cancelled := false
+		s.dev.opKind, s.dev.opCancel = running, func() { cancelled = true }
+		for _, r := range requests {
+			req := httptest.NewRequest("POST", r.path, r.body())
+			req.Header.Set("Content-Type", r.contentType)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, req)
+			if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "busy") {
+				t.Errorf("%s during %s: %d %s", r.path, running, w.Code, strings.TrimSpace(w.Body.String()))
+			}
+		}
+		if cancelled || s.dev.opKind != running {
+			t.Errorf("%s was cancelled or replaced (opKind %q)", running, s.dev.opKind)
+		}
+	}
+}
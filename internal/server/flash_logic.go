@@ -6,34 +6,180 @@ import (
 	"strings"
 	"time"
 
-	"github.com/CK6170/Calrunrilla-go/matrix"
 	"github.com/CK6170/Calrunrilla-go/models"
 	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
 )
 
-func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARAMETERS, onProgress func(map[string]interface{})) error {
+// verifyReadyTimeout bounds how long a bar may take to answer 'V' after the
+// post-flash reboot before its verify pass is reported as failed.
+const verifyReadyTimeout = 5 * time.Second
+
+// flashOptions narrows and guards a flash.
+type flashOptions struct {
+	// barIDs limits the flash to these bars; empty flashes every bar.
+	barIDs []int
+	// force writes every selected bar even if it already holds the values.
+	force bool
+	// backup, when set, receives the calibration every bar on the bus holds
+	// before anything is written; the flash is aborted if reading it fails.
+	backup func(*models.PARAMETERS) error
+}
+
+// flashParameters writes zeros and factors to the selected bars, then reads
+// them back after the reboot and checks they match. Unless forced, bars that
+// already hold the values are reported "unchanged" and left alone. Unless
+// every bar on the bus is flashed, update mode is entered with the addressed
+// handshake only, so the other bars see no traffic. The whole sequence holds
+// the bus at admin priority so no polling frame lands between the update-mode
+// handshake and the writes.
+func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARAMETERS, opts flashOptions, onProgress func(map[string]interface{})) error {
 	if bars == nil {
 		return fmt.Errorf("not connected")
 	}
 	if p == nil || len(p.BARS) == 0 || len(p.BARS[0].LC) == 0 {
 		return fmt.Errorf("missing calibration factors")
 	}
+	if err := checkCellCounts(p); err != nil {
+		return err
+	}
+	sel, err := serialpkg.SelectBars(p.BARS, opts.barIDs)
+	if err != nil {
+		return err
+	}
+	onBus, err := busIndexes(bars, p)
+	if err != nil {
+		return err
+	}
 	emit := func(m map[string]interface{}) {
 		if onProgress != nil {
 			onProgress(m)
 		}
 	}
+	return bars.Exclusive(serialpkg.PriorityAdmin, func(bus *serialpkg.Leo485) error {
+		if !opts.force {
+			sel = skipUnchanged(bus, p, sel, onBus, emit)
+			if len(sel) == 0 {
+				emit(map[string]interface{}{"stage": "done", "message": "All bars already hold these values; nothing flashed"})
+				return nil
+			}
+		}
+		if opts.backup != nil {
+			emit(map[string]interface{}{"stage": "backup", "message": "Backing up current calibration..."})
+			backup, err := cloneParameters(&models.PARAMETERS{SERIAL: p.SERIAL, BUSES: p.BUSES, AVG: p.AVG, IGNORE: p.IGNORE, DEBUG: p.DEBUG, BARS: bus.Bars})
+			if err != nil {
+				return err
+			}
+			if _, err := readFactorsFromDevice(bus, backup); err != nil {
+				return fmt.Errorf("backup before flash: %w", err)
+			}
+			if err := opts.backup(backup); err != nil {
+				return fmt.Errorf("backup before flash: %w", err)
+			}
+		}
+		return flashParametersLocked(ctx, bus, p, sel, onBus, emit)
+	})
+}
 
-	emit(map[string]interface{}{"stage": "enter_update", "message": "Entering update mode..."})
-	if err := bars.OpenToUpdate(); err != nil {
-		return err
+// skipUnchanged reads back the bars of p at positions sel (nil for all) and
+// returns the positions of those that still need flashing, reporting each
+// dropped bar as "unchanged". Bars that cannot be read stay in the flash, and
+// so do bars whose zeros are not read back; those are reported as
+// "skip_unavailable" with the reason.
+func skipUnchanged(bus *serialpkg.Leo485, p *models.PARAMETERS, sel, onBus []int, emit func(map[string]interface{})) []int {
+	all := sel
+	if all == nil {
+		for i := range p.BARS {
+			all = append(all, i)
+		}
+	}
+	keep := make([]int, 0, len(all))
+	for _, i := range all {
+		cal, err := bus.ReadCalibration(onBus[i])
+		switch {
+		case err == nil && serialpkg.Unchanged(cal, p.BARS[i].LC):
+			emit(map[string]interface{}{"stage": "unchanged", "barIndex": i, "message": "Already holds these values; skipped"})
+			continue
+		case err == nil && cal.Zeros == nil:
+			msg := fmt.Sprintf("Skip unavailable: %s; flashing", serialpkg.ZerosUnavailable(bus.ZeroRead))
+			emit(map[string]interface{}{"stage": "skip_unavailable", "barIndex": i, "message": msg})
+		}
+		keep = append(keep, i)
 	}
+	return keep
+}
 
-	// Some devices respond later; ensure all are ready by repeating Euler handshake per bar.
-	notReady := make([]int, 0, len(p.BARS))
-	for i := 0; i < len(p.BARS); i++ {
-		notReady = append(notReady, i)
+// busIndexes maps each bar of p to its position on the bus, so a calibrated
+// file whose BARS are ordered differently still reboots and verifies the
+// right bar.
+func busIndexes(bars *serialpkg.Leo485, p *models.PARAMETERS) ([]int, error) {
+	pos := make(map[int]int, len(bars.Bars))
+	for i, b := range bars.Bars {
+		pos[b.ID] = i
+	}
+	out := make([]int, len(p.BARS))
+	for i, b := range p.BARS {
+		j, ok := pos[b.ID]
+		if !ok {
+			return nil, fmt.Errorf("bar ID %d is not on the connected bus", b.ID)
+		}
+		out[i] = j
+	}
+	return out, nil
+}
+
+// planFlash is the dry-run counterpart of flashParameters: it lists the
+// frames a flash of p would send without touching the bus.
+func planFlash(p *models.PARAMETERS, barIDs []int) ([]serialpkg.PlannedFrame, error) {
+	if p == nil || len(p.BARS) == 0 || len(p.BARS[0].LC) == 0 {
+		return nil, fmt.Errorf("missing calibration factors")
+	}
+	if err := checkCellCounts(p); err != nil {
+		return nil, err
+	}
+	sel, err := serialpkg.SelectBars(p.BARS, barIDs)
+	if err != nil {
+		return nil, err
 	}
+	return serialpkg.PlanFlash(p.BARS, sel)
+}
+
+// checkCellCounts makes sure every bar carries one zero/factor per active
+// load cell, so a calibrated JSON made for another layout is not flashed.
+func checkCellCounts(p *models.PARAMETERS) error {
+	for i, bar := range p.BARS {
+		if len(bar.LC) != bar.NLCs() {
+			return fmt.Errorf("bar %d: %d calibration values for %d load cells", i+1, len(bar.LC), bar.NLCs())
+		}
+	}
+	return nil
+}
+
+// flashParametersLocked flashes the bars of p at positions sel (nil for all);
+// onBus maps those positions to bus indexes.
+func flashParametersLocked(ctx context.Context, bars *serialpkg.Leo485, p *models.PARAMETERS, sel, onBus []int, emit func(map[string]interface{})) error {
+	if sel == nil {
+		for i := range p.BARS {
+			sel = append(sel, i)
+		}
+	}
+	flashed := make([]int, len(sel))
+	for k, i := range sel {
+		flashed[k] = onBus[i]
+	}
+	if len(flashed) == len(bars.Bars) {
+		// Whole bus: the broadcast Euler sequence opens every bar at once.
+		emit(map[string]interface{}{"stage": "enter_update", "message": "Entering update mode..."})
+		if err := bars.OpenToUpdate(); err != nil {
+			return err
+		}
+	} else {
+		// Partial flash: only the addressed handshake below, so the other bars
+		// see no traffic and never leave normal mode.
+		emit(map[string]interface{}{"stage": "enter_update", "message": fmt.Sprintf("Entering update mode on %d of %d bars...", len(flashed), len(bars.Bars))})
+	}
+
+	// Some devices respond later; ensure all are ready by repeating Euler handshake per bar.
+	notReady := append([]int(nil), sel...)
 	for attempt := 1; attempt <= 6 && len(notReady) > 0; attempt++ {
 		select {
 		case <-ctx.Done():
@@ -43,7 +189,8 @@ func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARA
 		remaining := make([]int, 0)
 		for _, idx := range notReady {
 			cmd := serialpkg.GetCommand(p.BARS[idx].ID, []byte(serialpkg.Euler))
-			resp, err := serialpkg.ChangeState(bars.Serial, cmd, 400)
+			link, _ := bars.Bus(onBus[idx])
+			resp, err := serialpkg.ChangeState(link.Serial, cmd, link.Timeouts.Handshake)
 			if err != nil || !strings.Contains(resp, "Enter") {
 				remaining = append(remaining, idx)
 			}
@@ -57,12 +204,13 @@ func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARA
 		return fmt.Errorf("not all bars entered update mode: still missing %v", notReady)
 	}
 
-	// Prime bootloaders
-	_, _ = bars.Serial.Write([]byte{0x0D})
-	_, _ = serialpkg.ReadUntil(bars.Serial, 50)
+	// Prime bootloaders, once per bus
+	for _, link := range bars.Buses() {
+		_, _ = link.Serial.Write([]byte{0x0D})
+		_, _ = serialpkg.ReadUntil(link.Serial, 50)
+	}
 
-	nbars := len(p.BARS)
-	for i := 0; i < nbars; i++ {
+	for _, i := range sel {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -71,34 +219,15 @@ func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARA
 
 		emit(map[string]interface{}{"stage": "zeros", "barIndex": i, "message": "Flashing zeros..."})
 
-		nlcs := len(p.BARS[i].LC)
-		zero := matrix.NewVector(nlcs)
-		facs := matrix.NewVector(nlcs)
-		zeravg := 0.0
-		for j := 0; j < nlcs; j++ {
-			zero.Values[j] = float64(p.BARS[i].LC[j].ZERO)
-			facs.Values[j] = float64(p.BARS[i].LC[j].FACTOR)
-			zeravg += zero.Values[j] * facs.Values[j]
-		}
-		if zeravg < 0 {
-			zeravg = 0
-		}
-
-		sb := "O"
-		k := 0
-		for ii := 0; ii < 4; ii++ {
-			if (p.BARS[i].LCS & (1 << ii)) != 0 {
-				sb += fmt.Sprintf("%09.0f|", zero.Values[k])
-				k++
-			} else {
-				sb += fmt.Sprintf("%09d|", 0)
-			}
+		sb, sb2, err := serialpkg.FlashPayloads(p.BARS[i])
+		if err != nil {
+			return fmt.Errorf("bar %d: %w", i+1, err)
 		}
-		sb += fmt.Sprintf("%09d|", uint64(zeravg/float64(nlcs)+0.5))
 		zeroCmd := serialpkg.GetCommand(p.BARS[i].ID, []byte(sb))
+		link, _ := bars.Bus(onBus[i])
 		ok := false
 		for attempt := 1; attempt <= 3; attempt++ {
-			resp, err := serialpkg.UpdateValue(bars.Serial, zeroCmd, 200)
+			resp, err := serialpkg.UpdateValue(link.Serial, zeroCmd, link.Timeouts.Write)
 			if err == nil && strings.Contains(resp, "OK") {
 				ok = true
 				break
@@ -111,20 +240,10 @@ func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARA
 
 		emit(map[string]interface{}{"stage": "factors", "barIndex": i, "message": "Flashing factors..."})
 
-		sb2 := "X"
-		k2 := 0
-		for ii := 0; ii < 4; ii++ {
-			if (p.BARS[i].LCS & (1 << ii)) != 0 {
-				sb2 += fmt.Sprintf("%.10f|", facs.Values[k2])
-				k2++
-			} else {
-				sb2 += "1.0000000000|"
-			}
-		}
 		facCmd := serialpkg.GetCommand(p.BARS[i].ID, []byte(sb2))
 		ok = false
 		for attempt := 1; attempt <= 3; attempt++ {
-			resp, err := serialpkg.UpdateValue(bars.Serial, facCmd, 200)
+			resp, err := serialpkg.UpdateValue(link.Serial, facCmd, link.Timeouts.Write)
 			if err == nil && strings.Contains(resp, "OK") {
 				ok = true
 				break
@@ -134,12 +253,47 @@ func flashParameters(ctx context.Context, bars *serialpkg.Leo485, p *models.PARA
 		if !ok {
 			return fmt.Errorf("bar %d: cannot flash factors", i+1)
 		}
+	}
 
-		emit(map[string]interface{}{"stage": "reboot", "barIndex": i, "message": "Rebooting..."})
-		_ = bars.Reboot(i)
+	// Reboot the flashed bars and wait for each to answer 'V' before anything
+	// is read back.
+	emit(map[string]interface{}{"stage": "reboot", "message": fmt.Sprintf("Rebooting %d bars...", len(flashed))})
+	boot := make(map[int]serialpkg.RebootResult, len(flashed))
+	for _, r := range bars.RebootAndWait(flashed, verifyReadyTimeout) {
+		boot[r.Bar-1] = r
+	}
+
+	// Read everything back once the bars are up again.
+	var failed []string
+	for _, i := range sel {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		emit(map[string]interface{}{"stage": "verify", "barIndex": i, "message": "Verifying..."})
+		res := serialpkg.VerifyResult{Bar: onBus[i] + 1, ID: p.BARS[i].ID, Error: boot[onBus[i]].Error}
+		if boot[onBus[i]].OK {
+			res = bars.VerifyCalibration(onBus[i], p.BARS[i].LC)
+		}
+		msg := "Verified"
+		switch {
+		case res.Error != "":
+			msg = "Verify failed: " + res.Error
+		case !res.OK:
+			msg = "Verify failed: " + strings.Join(res.Mismatches, "; ")
+		case !res.Zeros:
+			msg = "Verified (factors only; firmware does not report zeros)"
+		}
+		emit(map[string]interface{}{"stage": "verified", "barIndex": i, "message": msg, "verify": res})
+		if !res.OK {
+			failed = append(failed, fmt.Sprintf("bar %d", i+1))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("verify failed for %s", strings.Join(failed, ", "))
 	}
 
 	emit(map[string]interface{}{"stage": "done", "message": "Flashing complete"})
 	return nil
 }
-
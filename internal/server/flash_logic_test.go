@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// simShelf connects a Leo485 to an in-memory simulated bus built from p.
+func simShelf(t *testing.T, p *models.PARAMETERS) (*serialpkg.Leo485, *simulator.Bus) {
+	bars, sim, _ := tapShelf(t, p)
+	return bars, sim
+}
+
+// tapShelf is simShelf that also records every frame the host sends.
+func tapShelf(t *testing.T, p *models.PARAMETERS) (*serialpkg.Leo485, *simulator.Bus, *txLog) {
+	t.Helper()
+	sim := simulator.NewFromParameters(p, 1)
+	sim.BootTime = 50 * time.Millisecond
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	tap := &txLog{Transport: host}
+	bars, err := serialpkg.NewLeo485WithTransport(tap, p.SERIAL, p.BARS)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	bars.ZeroRead = p.ZEROREAD
+	t.Cleanup(func() {
+		_ = bars.Close()
+		_ = dev.Close()
+	})
+	return bars, sim, tap
+}
+
+// txLog decodes every frame written through it.
+type txLog struct {
+	serialpkg.Transport
+	mu     sync.Mutex
+	frames []serialpkg.CaptureRecord
+}
+
+func (l *txLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.frames = append(l.frames, serialpkg.DecodeFrame("tx", p))
+	l.mu.Unlock()
+	return l.Transport.Write(p)
+}
+
+func (l *txLog) sent() []serialpkg.CaptureRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]serialpkg.CaptureRecord(nil), l.frames...)
+}
+
+// calibrated returns a calibrated config for bars with one LC entry per
+// active cell. The factors are small enough that their 10-decimal wire text
+// does not round-trip, as with real calibrations.
+func calibrated(bars ...*models.BAR) *models.PARAMETERS {
+	for i, bar := range bars {
+		bar.LC = nil
+		for k := 0; k < bar.NLCs(); k++ {
+			f := float32(0.000123456789 * float64(k+2+i))
+			bar.LC = append(bar.LC, &models.LC{
+				ZERO:   uint64(145000000 + 1000*i + k),
+				FACTOR: f,
+				IEEE:   fmt.Sprintf("%08X", math.Float32bits(f)),
+			})
+		}
+	}
+	return &models.PARAMETERS{
+		SERIAL:   &models.SERIAL{COMMAND: "M"},
+		ZEROREAD: &models.VERSIONRANGE{ID: 12009}, // the simulator's firmware family
+		BARS:     bars,
+	}
+}
+
+func TestFlashVerifyMixedCellCounts(t *testing.T) {
+	p := calibrated(
+		&models.BAR{ID: 0, LCS: 3},
+		&models.BAR{ID: 1, LCS: 15},
+		&models.BAR{ID: 2, LCS: 5, SLOTS: 4},
+	)
+	bars, sim := simShelf(t, p)
+
+	var verified int
+	err := flashParameters(context.Background(), bars, p, flashOptions{}, func(m map[string]interface{}) {
+		if m["stage"] == "verified" {
+			if res := m["verify"].(serialpkg.VerifyResult); res.OK && res.Zeros {
+				verified++
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("flashParameters: %v", err)
+	}
+	if verified != len(p.BARS) {
+		t.Errorf("%d bars verified, want %d", verified, len(p.BARS))
+	}
+	for _, bar := range p.BARS {
+		zeros, factors, _ := sim.Stored(bar.ID)
+		if len(zeros) != bar.NLCs() {
+			t.Fatalf("bar ID %d stores %d zeros, want %d", bar.ID, len(zeros), bar.NLCs())
+		}
+		for k, lc := range bar.LC {
+			want := serialpkg.WireFactor(float64(lc.FACTOR))
+			if zeros[k] != lc.ZERO || factors[k] != want {
+				t.Errorf("bar ID %d LC %d stores %d/%v, want %d/%v", bar.ID, k+1, zeros[k], factors[k], lc.ZERO, want)
+			}
+		}
+	}
+
+	// A second flash finds every bar already holding the values.
+	var unchanged int
+	err = flashParameters(context.Background(), bars, p, flashOptions{}, func(m map[string]interface{}) {
+		if m["stage"] == "unchanged" {
+			unchanged++
+		}
+	})
+	if err != nil {
+		t.Fatalf("second flashParameters: %v", err)
+	}
+	if unchanged != len(p.BARS) {
+		t.Errorf("%d bars unchanged, want %d", unchanged, len(p.BARS))
+	}
+}
+
+func TestFlashVerifyFactorsOnly(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3}, &models.BAR{ID: 1, LCS: 15})
+	p.ZEROREAD = nil
+	bars, _ := simShelf(t, p)
+
+	for pass := 1; pass <= 2; pass++ {
+		var verified, unchanged, unavailable int
+		err := flashParameters(context.Background(), bars, p, flashOptions{}, func(m map[string]interface{}) {
+			switch m["stage"] {
+			case "verified":
+				if res := m["verify"].(serialpkg.VerifyResult); res.OK && !res.Zeros {
+					verified++
+				}
+			case "unchanged":
+				unchanged++
+			case "skip_unavailable":
+				if msg := m["message"].(string); !strings.Contains(msg, "ZEROREAD disabled") {
+					t.Errorf("pass %d: skip_unavailable message %q does not name the cause", pass, msg)
+				}
+				unavailable++
+			}
+		})
+		if err != nil {
+			t.Fatalf("pass %d: flashParameters: %v", pass, err)
+		}
+		// Without read-back zeros no bar can be proven unchanged, so the
+		// second pass flashes again and says why.
+		if verified != len(p.BARS) || unchanged != 0 || unavailable != len(p.BARS) {
+			t.Errorf("pass %d: %d bars verified on factors, %d unchanged, %d skip unavailable; want %d, 0 and %d",
+				pass, verified, unchanged, unavailable, len(p.BARS), len(p.BARS))
+		}
+	}
+}
+
+func TestPartialFlashLeavesOtherBarsAlone(t *testing.T) {
+	p := calibrated(
+		&models.BAR{ID: 0, LCS: 3},
+		&models.BAR{ID: 1, LCS: 15},
+		&models.BAR{ID: 2, LCS: 7},
+	)
+	bars, sim, tap := tapShelf(t, p)
+
+	err := flashParameters(context.Background(), bars, p, flashOptions{barIDs: []int{1}, force: true}, nil)
+	if err != nil {
+		t.Fatalf("flashParameters: %v", err)
+	}
+	// Only the bootloader-priming CR carries no address; everything else
+	// must be addressed to the selected bar.
+	for _, f := range tap.sent() {
+		if f.BarID != 1 && f.Hex != "0D" {
+			t.Errorf("partial flash sent %s frame %s to bar ID %d", f.Command, f.Hex, f.BarID)
+		}
+	}
+	for _, bar := range p.BARS {
+		if updating, _ := sim.UpdateMode(bar.ID); updating {
+			t.Errorf("bar ID %d left in update mode", bar.ID)
+		}
+		zeros, _, _ := sim.Stored(bar.ID)
+		if written := zeros[0] == bar.LC[0].ZERO; written != (bar.ID == 1) {
+			t.Errorf("bar ID %d written=%v", bar.ID, written)
+		}
+	}
+
+	// The dry run lists no frame for the other bars either.
+	frames, err := planFlash(p, []int{1})
+	if err != nil {
+		t.Fatalf("planFlash: %v", err)
+	}
+	for _, f := range frames {
+		if f.Step != "prime" && f.BarID != 1 {
+			t.Errorf("dry run lists %s frame #%d for bar ID %d", f.Step, f.Seq, f.BarID)
+		}
+	}
+}
+
+func TestFlashDryRunSendsNothing(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3}, &models.BAR{ID: 1, LCS: 15})
+	bars, sim, tap := tapShelf(t, p)
+	s := New(t.TempDir())
+	rec, err := s.store.Put(kindCalibrated, []byte("{}"), p, "shelf_calibrated.json")
+	if err != nil {
+		t.Fatalf("store.Put: %v", err)
+	}
+	s.dev.bars, s.dev.params = bars, p
+
+	w := httptest.NewRecorder()
+	body := `{"calibratedId": "` + rec.ID + `", "dryRun": true}`
+	s.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/api/flash/start", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("dry run: %d %s", w.Code, w.Body.String())
+	}
+	var resp FlashDryRunResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("dry run response: %v", err)
+	}
+	want, err := serialpkg.PlanFlash(p.BARS, nil)
+	if err != nil {
+		t.Fatalf("PlanFlash: %v", err)
+	}
+	if len(resp.Frames) != len(want) {
+		t.Errorf("dry run lists %d frames, want %d", len(resp.Frames), len(want))
+	}
+	if sent := tap.sent(); len(sent) != 0 {
+		t.Errorf("dry run sent %d frames, first %s", len(sent), sent[0].Hex)
+	}
+	for _, bar := range p.BARS {
+		if updating, _ := sim.UpdateMode(bar.ID); updating {
+			t.Errorf("dry run put bar ID %d in update mode", bar.ID)
+		}
+	}
+	s.dev.mu.Lock()
+	kind := s.dev.opKind
+	s.dev.mu.Unlock()
+	if kind != "" {
+		t.Errorf("dry run claimed the session as %q", kind)
+	}
+}
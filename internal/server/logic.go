@@ -24,18 +24,20 @@ type CalStep struct {
 	Prompt string
 }
 
-func buildCalibrationPlan(p *models.PARAMETERS, nlcs int) ([]CalStep, int, error) {
+func buildCalibrationPlan(p *models.PARAMETERS) ([]CalStep, int, error) {
 	if p == nil {
 		return nil, 0, fmt.Errorf("parameters nil")
 	}
 	if len(p.BARS) == 0 {
 		return nil, 0, fmt.Errorf("no bars configured")
 	}
-	if nlcs <= 0 {
-		return nil, 0, fmt.Errorf("nlcs must be > 0")
+	for i, b := range p.BARS {
+		if n := b.NLCs(); n <= 0 || n > models.MAXLCS {
+			return nil, 0, fmt.Errorf("bar %d: load cells must be 1..%d", i+1, models.MAXLCS)
+		}
 	}
-	nbars := len(p.BARS)
-	nloads := 3 * (nbars - 1) * nlcs
+	plan := models.CalibrationPlan(p.BARS)
+	nloads := len(plan)
 	steps := make([]CalStep, 0, 1+nloads)
 	steps = append(steps, CalStep{
 		Kind:   CalStepZero,
@@ -43,13 +45,13 @@ func buildCalibrationPlan(p *models.PARAMETERS, nlcs int) ([]CalStep, int, error
 		Label:  "[ZERO]",
 		Prompt: "Clear the Bay(s) and start sampling.",
 	})
-	for j := 0; j < nloads; j++ {
+	for j, ld := range plan {
 		msg := fmt.Sprintf(
 			"Put %d on the %s Bay on the %s side in the %s of the Shelf.",
 			p.WEIGHT,
-			models.BAY(j/6),
-			models.LMR((j/2)%3),
-			models.FB(j%2),
+			ld.Bay,
+			ld.Side,
+			ld.DepthString(),
 		)
 		steps = append(steps, CalStep{
 			Kind:   CalStepWeight,
@@ -61,6 +63,43 @@ func buildCalibrationPlan(p *models.PARAMETERS, nlcs int) ([]CalStep, int, error
 	return steps, nloads, nil
 }
 
+// readFailures counts failed ADC reads by serial error class (see
+// serialpkg.ErrorClass) so progress events can show why samples are missing.
+type readFailures struct {
+	byClass map[string]int
+	last    error
+}
+
+func newReadFailures() *readFailures {
+	return &readFailures{byClass: make(map[string]int)}
+}
+
+func (f *readFailures) add(err error) {
+	f.byClass[serialpkg.ErrorClass(err)]++
+	f.last = err
+}
+
+func (f *readFailures) total() int {
+	n := 0
+	for _, c := range f.byClass {
+		n += c
+	}
+	return n
+}
+
+// snapshot returns a copy of the per-class counts for a progress event.
+func (f *readFailures) snapshot() map[string]int {
+	out := make(map[string]int, len(f.byClass))
+	for k, v := range f.byClass {
+		out[k] = v
+	}
+	return out
+}
+
+// maxFailedPasses bounds how many consecutive passes with no bar answering
+// sampleADCs tolerates before giving up.
+const maxFailedPasses = 40
+
 func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTarget int, onUpdate func(map[string]interface{})) ([]int64, error) {
 	if bars == nil || len(bars.Bars) == 0 {
 		return nil, fmt.Errorf("bars not connected")
@@ -73,21 +112,36 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 	}
 
 	nBars := len(bars.Bars)
-	nLCs := bars.NLCs
+	nLCs := bars.NLCs // cells per bar
 
-	readOnce := func() [][]int64 {
+	failures := newReadFailures()
+	failedPasses := 0
+	readOnce := func() ([][]int64, error) {
 		cur := make([][]int64, nBars)
+		answered := false
+		reads, errs := bars.GetAllADs(0)
 		for i := 0; i < nBars; i++ {
-			bruts, err := bars.GetADs(i)
-			row := make([]int64, nLCs)
-			if err == nil && len(bruts) > 0 {
-				for lc := 0; lc < nLCs && lc < len(bruts); lc++ {
+			bruts, err := reads[i], errs[i]
+			row := make([]int64, nLCs[i])
+			if err != nil {
+				failures.add(err)
+			} else {
+				answered = true
+				for lc := 0; lc < nLCs[i] && lc < len(bruts); lc++ {
 					row[lc] = int64(bruts[lc])
 				}
 			}
 			cur[i] = row
 		}
-		return cur
+		if answered {
+			failedPasses = 0
+			return cur, nil
+		}
+		failedPasses++
+		if failedPasses >= maxFailedPasses {
+			return cur, fmt.Errorf("no bar answered in %d consecutive reads: %w", failedPasses, failures.last)
+		}
+		return cur, nil
 	}
 
 	emit := func(m map[string]interface{}) {
@@ -104,7 +158,10 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 			return nil, ctx.Err()
 		default:
 		}
-		cur := readOnce()
+		cur, err := readOnce()
+		if err != nil {
+			return nil, err
+		}
 		ignoreDone++
 		emit(map[string]interface{}{
 			"phase":        phase,
@@ -113,6 +170,7 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 			"avgDone":      0,
 			"avgTarget":    avgTarget,
 			"current":      cur,
+			"failures":     failures.snapshot(),
 		})
 		time.Sleep(250 * time.Millisecond)
 	}
@@ -121,19 +179,19 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 	sums := make([][]int64, nBars)
 	counts := make([][]int64, nBars)
 	for i := 0; i < nBars; i++ {
-		sums[i] = make([]int64, nLCs)
-		counts[i] = make([]int64, nLCs)
+		sums[i] = make([]int64, nLCs[i])
+		counts[i] = make([]int64, nLCs[i])
 	}
 
 	// Averaging is per-LC: each LC must collect avgTarget non-zero samples.
 	// Progress (avgDone) is the minimum count across all LCs.
 	minCount := func() int {
-		if nBars == 0 || nLCs == 0 {
+		if nBars == 0 {
 			return 0
 		}
 		m := int(^uint(0) >> 1) // max int
 		for i := 0; i < nBars; i++ {
-			for lc := 0; lc < nLCs; lc++ {
+			for lc := 0; lc < nLCs[i]; lc++ {
 				c := int(counts[i][lc])
 				if c < m {
 					m = c
@@ -152,13 +210,16 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 			return nil, ctx.Err()
 		default:
 		}
-		cur := readOnce()
+		cur, err := readOnce()
+		if err != nil {
+			return nil, err
+		}
 
 		// Add only non-zero ADC values to the averaging (per LC).
 		// If a value is 0, do not add it and do not increment that LC's count.
 		hasAnyNonZero := false
 		for i := 0; i < nBars; i++ {
-			for lc := 0; lc < nLCs; lc++ {
+			for lc := 0; lc < nLCs[i]; lc++ {
 				v := cur[i][lc]
 				if v != 0 {
 					hasAnyNonZero = true
@@ -171,8 +232,8 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 		// Calculate current averages for display
 		currentAvg := make([][]int64, nBars)
 		for i := 0; i < nBars; i++ {
-			currentAvg[i] = make([]int64, nLCs)
-			for lc := 0; lc < nLCs; lc++ {
+			currentAvg[i] = make([]int64, nLCs[i])
+			for lc := 0; lc < nLCs[i]; lc++ {
 				if counts[i][lc] > 0 {
 					currentAvg[i][lc] = sums[i][lc] / counts[i][lc]
 				}
@@ -187,6 +248,7 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 			"avgTarget":    avgTarget,
 			"current":      cur,
 			"averaged":     currentAvg,
+			"failures":     failures.snapshot(),
 		})
 		_ = hasAnyNonZero // kept for easy future debugging
 		time.Sleep(250 * time.Millisecond)
@@ -194,8 +256,8 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 
 	final := make([][]int64, nBars)
 	for i := 0; i < nBars; i++ {
-		final[i] = make([]int64, nLCs)
-		for lc := 0; lc < nLCs; lc++ {
+		final[i] = make([]int64, nLCs[i])
+		for lc := 0; lc < nLCs[i]; lc++ {
 			if counts[i][lc] > 0 {
 				final[i][lc] = sums[i][lc] / counts[i][lc]
 			}
@@ -203,39 +265,36 @@ func sampleADCs(ctx context.Context, bars *serialpkg.Leo485, ignoreTarget, avgTa
 	}
 
 	emit(map[string]interface{}{
-		"phase": "finished",
-		"final": final,
+		"phase":    "finished",
+		"final":    final,
+		"failures": failures.snapshot(),
 	})
 
-	flat := make([]int64, nBars*nLCs)
+	flat := make([]int64, 0, bars.TotalLCs())
 	for i := 0; i < nBars; i++ {
-		for lc := 0; lc < nLCs; lc++ {
-			flat[i*nLCs+lc] = final[i][lc]
-		}
+		flat = append(flat, final[i]...)
 	}
 	return flat, nil
 }
 
-func updateMatrixZero(flat []int64, calibs int, nlcs int) *matrix.Matrix {
+// updateMatrixZero repeats the flat zero reading (one column per cell, bars
+// in order) on each of the nloads rows.
+func updateMatrixZero(flat []int64, nloads int) *matrix.Matrix {
 	ad := matrix.NewVector(len(flat))
 	for i, v := range flat {
 		ad.Values[i] = float64(v)
 	}
-	nbars := len(flat) / nlcs
-	ad0 := matrix.NewMatrix(calibs*nlcs, nbars*nlcs)
-	for i := 0; i < calibs*nlcs; i++ {
+	ad0 := matrix.NewMatrix(nloads, len(flat))
+	for i := 0; i < nloads; i++ {
 		ad0.SetRow(i, ad)
 	}
 	return ad0
 }
 
-func updateMatrixWeight(adc *matrix.Matrix, flat []int64, index int, nlcs int) *matrix.Matrix {
-	nbars := len(flat) / nlcs
-	for j := 0; j < nbars; j++ {
-		for i := 0; i < nlcs; i++ {
-			curr := j*nlcs + i
-			adc.Values[index][curr] = float64(flat[curr])
-		}
+// updateMatrixWeight stores the flat reading of load index as its row of adc.
+func updateMatrixWeight(adc *matrix.Matrix, flat []int64, index int) *matrix.Matrix {
+	for k := 0; k < adc.Cols && k < len(flat); k++ {
+		adc.Values[index][k] = float64(flat[k])
 	}
 	return adc
 }
@@ -255,14 +314,17 @@ func computeZerosAndFactors(adv, ad0 *matrix.Matrix, p *models.PARAMETERS) error
 		return fmt.Errorf("pseudoinverse multiplication failed")
 	}
 	zeros := ad0.GetRow(0)
-	nbars := len(p.BARS)
-	nlcs := zeros.Length / nbars
-	for i := 0; i < nbars; i++ {
-		p.BARS[i].LC = make([]*models.LC, nlcs)
+	offs, total := models.CellOffsets(p.BARS)
+	if zeros.Length != total || factors.Length != total {
+		return fmt.Errorf("matrices have %d columns, bars have %d load cells", zeros.Length, total)
+	}
+	for i, bar := range p.BARS {
+		nlcs := bar.NLCs()
+		bar.LC = make([]*models.LC, nlcs)
 		for j := 0; j < nlcs; j++ {
-			idx := i*nlcs + j
+			idx := offs[i] + j
 			f := float32(factors.Values[idx])
-			p.BARS[i].LC[j] = &models.LC{
+			bar.LC[j] = &models.LC{
 				ZERO:   uint64(zeros.Values[idx]),
 				FACTOR: f,
 				IEEE:   fmt.Sprintf("%08X", matrix.ToIEEE754(f)),
@@ -272,29 +334,28 @@ func computeZerosAndFactors(adv, ad0 *matrix.Matrix, p *models.PARAMETERS) error
 	return nil
 }
 
-// readFactorsFromDevice reads factors from the device using ReadFactors and populates p.BARS[].LC
-func readFactorsFromDevice(bars *serialpkg.Leo485, p *models.PARAMETERS) error {
+// readFactorsFromDevice reads the stored calibration of every bar using
+// ReadCalibration and populates p.BARS[].LC. ZERO is filled in when the
+// firmware reports zeros; the returned flag says whether every bar did.
+func readFactorsFromDevice(bars *serialpkg.Leo485, p *models.PARAMETERS) (bool, error) {
 	if bars == nil || p == nil {
-		return fmt.Errorf("not connected")
+		return false, fmt.Errorf("not connected")
 	}
-	// Always read factors from device using ReadFactors
+	allZeros := true
 	for i := 0; i < len(bars.Bars); i++ {
-		factors, err := bars.ReadFactors(i)
-		if err != nil || len(factors) == 0 {
-			return fmt.Errorf("bar %d: could not read factors: %v", i+1, err)
-		}
-		nlcs := len(factors)
-		p.BARS[i].LC = make([]*models.LC, nlcs)
-		for j := 0; j < nlcs; j++ {
-			f := float32(factors[j])
-			p.BARS[i].LC[j] = &models.LC{
-				ZERO:   0,
-				FACTOR: f,
-				IEEE:   fmt.Sprintf("%08X", matrix.ToIEEE754(f)),
+		cal, err := bars.ReadCalibration(i)
+		if err != nil || len(cal.Factors) == 0 {
+			if err == nil {
+				err = fmt.Errorf("no factors")
 			}
+			return false, fmt.Errorf("bar %d: could not read factors: %w", i+1, err)
 		}
+		if cal.Zeros == nil {
+			allZeros = false
+		}
+		p.BARS[i].LC = cal.LCs()
 	}
-	return nil
+	return allZeros, nil
 }
 
 func ensureFactorsFromDevice(bars *serialpkg.Leo485, p *models.PARAMETERS, configFilename string) error {
@@ -315,7 +376,8 @@ func ensureFactorsFromDevice(bars *serialpkg.Leo485, p *models.PARAMETERS, confi
 		return nil
 	}
 	// Read factors from device
-	return readFactorsFromDevice(bars, p)
+	_, err := readFactorsFromDevice(bars, p)
+	return err
 }
 
 func collectAveragedZeros(ctx context.Context, bars *serialpkg.Leo485, p *models.PARAMETERS, samples int, onProgress func(map[string]int)) ([]int64, error) {
@@ -326,15 +388,20 @@ func collectAveragedZeros(ctx context.Context, bars *serialpkg.Leo485, p *models
 		return nil, fmt.Errorf("samples must be > 0")
 	}
 	nb := len(bars.Bars)
-	nlcs := bars.NLCs
-	sums := make([]int64, nb*nlcs)
+	sums := make([]int64, bars.TotalLCs())
 	count := 0
 	warmup := 5
 	if p != nil && p.IGNORE > 0 {
 		warmup = p.IGNORE
 	}
+	failures := newReadFailures()
 	emit := func(m map[string]int) {
 		if onProgress != nil {
+			// Failure counts ride along as "failed" plus "failed.<class>".
+			m["failed"] = failures.total()
+			for class, n := range failures.byClass {
+				m["failed."+class] = n
+			}
 			onProgress(m)
 		}
 	}
@@ -344,8 +411,11 @@ func collectAveragedZeros(ctx context.Context, bars *serialpkg.Leo485, p *models
 			return nil, ctx.Err()
 		default:
 		}
-		for i := 0; i < nb; i++ {
-			_, _ = bars.GetADs(i)
+		_, errs := bars.GetAllADs(0)
+		for _, err := range errs {
+			if err != nil {
+				failures.add(err)
+			}
 		}
 		emit(map[string]int{"warmupDone": w + 1, "warmupTarget": warmup, "sampleDone": 0, "sampleTarget": samples})
 		time.Sleep(5 * time.Millisecond)
@@ -357,18 +427,21 @@ func collectAveragedZeros(ctx context.Context, bars *serialpkg.Leo485, p *models
 		default:
 		}
 		gotAny := false
+		reads, errs := bars.GetAllADs(0)
 		for i := 0; i < nb; i++ {
-			ad, err := bars.GetADs(i)
-			if err != nil || len(ad) == 0 {
+			ad, err := reads[i], errs[i]
+			if err != nil {
+				failures.add(err)
 				continue
 			}
 			gotAny = true
-			for lc := 0; lc < nlcs; lc++ {
+			off := bars.Offset(i)
+			for lc := 0; lc < bars.NLCs[i]; lc++ {
 				val := int64(0)
 				if lc < len(ad) {
 					val = int64(ad[lc])
 				}
-				idx := i*nlcs + lc
+				idx := off + lc
 				sums[idx] += val
 			}
 		}
@@ -378,8 +451,11 @@ func collectAveragedZeros(ctx context.Context, bars *serialpkg.Leo485, p *models
 		emit(map[string]int{"warmupDone": warmup, "warmupTarget": warmup, "sampleDone": s + 1, "sampleTarget": samples})
 		time.Sleep(5 * time.Millisecond)
 	}
-	avg := make([]int64, nb*nlcs)
+	avg := make([]int64, len(sums))
 	if count == 0 {
+		if failures.last != nil {
+			return nil, fmt.Errorf("no zero samples collected (%d failed reads): %w", failures.total(), failures.last)
+		}
 		return avg, nil
 	}
 	for i := range sums {
@@ -388,44 +464,56 @@ func collectAveragedZeros(ctx context.Context, bars *serialpkg.Leo485, p *models
 	return avg, nil
 }
 
+// splitPerBar cuts a flat per-cell vector into one slice per bar, using the
+// cell count of each bar. Missing trailing values are left at zero.
+func splitPerBar(flat []int64, nlcs []int) [][]int64 {
+	out := make([][]int64, len(nlcs))
+	off := 0
+	for i, n := range nlcs {
+		out[i] = make([]int64, n)
+		for j := 0; j < n; j++ {
+			if off+j < len(flat) {
+				out[i][j] = flat[off+j]
+			}
+		}
+		off += n
+	}
+	return out
+}
+
 func computeTestSnapshot(bars *serialpkg.Leo485, p *models.PARAMETERS, zerosFlat []int64, includeDebug bool, adTimeoutMS int) (map[string]interface{}, error) {
 	if bars == nil || p == nil {
 		return nil, fmt.Errorf("not connected")
 	}
 	nb := len(p.BARS)
-	nlcs := bars.NLCs
-	zerosPerBar := make([][]int64, nb)
-	for i := 0; i < nb; i++ {
-		zerosPerBar[i] = make([]int64, nlcs)
-		for j := 0; j < nlcs; j++ {
-			idx := i*nlcs + j
-			if idx < len(zerosFlat) {
-				zerosPerBar[i][j] = zerosFlat[idx]
-			}
-		}
-	}
+	zerosPerBar := splitPerBar(zerosFlat, bars.NLCs)
 	perBarLCWeight := make([][]float64, nb)
 	perBarTotal := make([]float64, nb)
 	perBarADC := make([][]int64, nb)
 	grand := 0.0
 
-	for i := 0; i < nb; i++ {
-		// Test mode ADC timeout: caller-controlled (clamped).
-		to := adTimeoutMS
-		if to <= 0 {
-			to = 100
+	// Test mode ADC timeout: caller-controlled (clamped), else
+	// SERIAL.TIMEOUTS.MEASURE when configured, else 100ms.
+	to := adTimeoutMS
+	if to <= 0 {
+		to = 100
+		if p.SERIAL != nil && p.SERIAL.TIMEOUTS != nil && p.SERIAL.TIMEOUTS.MEASURE > 0 {
+			to = p.SERIAL.TIMEOUTS.MEASURE
 		}
-		if to < 25 {
-			to = 25
-		}
-		if to > 500 {
-			to = 500
-		}
-		// Use strict reads in test mode so partial/invalid serial frames don't produce "wrong" snapshots.
-		ad, err := bars.GetADsStrictWithTimeout(i, to)
+	} else if to < 25 {
+		to = 25
+	} else if to > 500 {
+		to = 500
+	}
+	// Every bus is read in parallel; reads reject partial or invalid frames,
+	// so a bad frame never produces a "wrong" snapshot.
+	reads, errs := bars.GetAllADs(to)
+	for i := 0; i < nb; i++ {
+		ad, err := reads[i], errs[i]
 		if err != nil {
 			return nil, fmt.Errorf("bar %d read error: %w", i+1, err)
 		}
+		nlcs := bars.NLCs[i]
 		perBarLCWeight[i] = make([]float64, nlcs)
 		perBarADC[i] = make([]int64, nlcs)
 		total := 0.0
@@ -454,6 +542,7 @@ func computeTestSnapshot(bars *serialpkg.Leo485, p *models.PARAMETERS, zerosFlat
 		debugInfo = make([]map[string]interface{}, nb)
 		for i := 0; i < nb; i++ {
 			barDebug := make(map[string]interface{})
+			nlcs := bars.NLCs[i]
 			factors := make([]float64, nlcs)
 			zeros := make([]int64, nlcs)
 			for lc := 0; lc < nlcs; lc++ {
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// rebootReadyTimeout is how long a bar may take to answer 'V' after
+// /api/device/reboot unless the request sets timeoutMs; maxRebootTimeout caps
+// what a request may ask for.
+const (
+	rebootReadyTimeout = 10 * time.Second
+	maxRebootTimeout   = time.Minute
+)
+
+// handleDeviceReboot reboots the selected bars (all by default), waits for
+// each to answer 'V' again and reports every bar's boot time.
+//
+// Request shape: DeviceRebootRequest (body optional).
+// Response shape: DeviceRebootResponse.
+func (s *Server) handleDeviceReboot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var req DeviceRebootRequest
+	if r.ContentLength != 0 {
+		if err := s.readJSON(r, &req); err != nil {
+			s.writeJSON(w, 400, APIError{Error: err.Error()})
+			return
+		}
+	}
+	s.dev.mu.Lock()
+	if s.dev.bars == nil || s.dev.params == nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 400, APIError{Error: "not connected"})
+		return
+	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
+	// Live test polling just sees a few timeouts; anything else would be
+	// wrecked by a bar restarting underneath it.
+	if s.dev.opKind != "" && s.dev.opKind != "test" {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 400, APIError{Error: "busy"})
+		return
+	}
+	// Claim the session so no flash, firmware update or second reboot starts
+	// meanwhile; a running test keeps polling and gets its opKind back.
+	prev := s.dev.opKind
+	s.dev.opKind = "reboot"
+	bars := s.dev.bars
+	p := s.dev.params
+	s.dev.mu.Unlock()
+	defer func() {
+		s.dev.mu.Lock()
+		if s.dev.opKind == "reboot" {
+			s.dev.opKind = prev
+		}
+		s.dev.mu.Unlock()
+	}()
+
+	sel, err := serialpkg.SelectBars(p.BARS, req.Bars)
+	if err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	timeout := rebootReadyTimeout
+	if req.TimeoutMS > 0 {
+		timeout = time.Duration(req.TimeoutMS) * time.Millisecond
+		if timeout > maxRebootTimeout {
+			timeout = maxRebootTimeout
+		}
+	}
+	res := bars.WithPriority(serialpkg.PriorityAdmin).RebootAndWait(sel, timeout)
+	ok := true
+	for _, b := range res {
+		ok = ok && b.OK
+	}
+	s.writeJSON(w, 200, DeviceRebootResponse{OK: ok, Results: res})
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+func TestDeviceRebootClaimsSession(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3}, &models.BAR{ID: 1, LCS: 3})
+	bars, sim := simShelf(t, p)
+	sim.BootTime = 300 * time.Millisecond
+	s := New(t.TempDir())
+	s.dev.bars, s.dev.params = bars, p
+	s.dev.opKind = "test" // a reboot may run under live test polling
+
+	post := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("")))
+		return w
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("/api/device/reboot") }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.dev.mu.Lock()
+		kind := s.dev.opKind
+		s.dev.mu.Unlock()
+		if kind == "reboot" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("opKind %q during reboot, want reboot", kind)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, path := range []string{"/api/device/reboot", "/api/device/backup"} {
+		if w := post(path); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "busy") {
+			t.Errorf("%s during reboot: %d %s", path, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatalf("reboot: %d %s", w.Code, w.Body.String())
+	}
+	if s.dev.opKind != "test" {
+		t.Errorf("opKind %q after reboot, want test restored", s.dev.opKind)
+	}
+}
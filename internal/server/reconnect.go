@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// Link states of a DeviceSession whose port was lost.
+const (
+	linkDisconnected = "disconnected"
+	linkReconnecting = "reconnecting"
+)
+
+// reconnectInterval is the pause between attempts to reopen a lost port.
+const reconnectInterval = time.Second
+
+// linkErrLocked reports why the session's bus cannot be used while its port is
+// lost, or nil when the link is up.
+func (d *DeviceSession) linkErrLocked() error {
+	switch d.linkState {
+	case linkReconnecting:
+		return fmt.Errorf("device link lost; reconnecting")
+	case linkDisconnected:
+		return fmt.Errorf("device link lost; connect again")
+	}
+	return nil
+}
+
+// linkLost reports whether err from polling bars means the port is gone
+// rather than a bar failing. A USB adapter that vanishes may only make reads
+// time out, so a missing device node counts as well.
+func linkLost(err error, p *models.PARAMETERS) bool {
+	if err == nil {
+		return false
+	}
+	return serialpkg.IsLinkLost(err) || portGone(p)
+}
+
+// portGone reports whether the device node of a local bus of p has vanished.
+func portGone(p *models.PARAMETERS) bool {
+	buses, err := serialpkg.ShelfBuses(p)
+	if err != nil {
+		return false
+	}
+	for _, b := range buses {
+		if isDeviceNode(b.Serial.PORT) {
+			if _, err := os.Stat(b.Serial.PORT); err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDeviceNode reports whether port names a file under /dev, the only kind of
+// port that can be checked for and rediscovered under a new name.
+func isDeviceNode(port string) bool {
+	return strings.HasPrefix(port, "/dev/")
+}
+
+// recoverLink replaces the session's lost bus handle old. It closes old,
+// then reopens the configured ports every reconnectInterval until they answer
+// again or ctx ends; when the device node of SERIAL has gone, port detection
+// is re-run so an adapter that came back under a new name is found. Progress
+// is broadcast on the test WebSocket as disconnected, reconnecting and
+// reconnected. The new handle is returned after it has been stored in the
+// session; nil means the session was cancelled or changed meanwhile.
+func (s *Server) recoverLink(ctx context.Context, old *serialpkg.Leo485, cause error) *serialpkg.Leo485 {
+	s.dev.mu.Lock()
+	if s.dev.bars != old || ctx.Err() != nil {
+		s.dev.mu.Unlock()
+		return nil
+	}
+	id, p := s.dev.configID, s.dev.params
+	port := p.SERIAL.PORT
+	s.dev.linkState = linkDisconnected
+	s.dev.linkLostAt = time.Now()
+	lostAt := s.dev.linkLostAt
+	s.dev.mu.Unlock()
+
+	_ = old.Close()
+	s.wsTest.Broadcast(WSMessage{Type: "disconnected", Data: map[string]interface{}{"port": port, "error": cause.Error()}})
+
+	timer := time.NewTimer(reconnectInterval)
+	defer timer.Stop()
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			// Stopped while down: leave the session marked lost until the
+			// next connect.
+			s.dev.mu.Lock()
+			if s.dev.bars == old {
+				s.dev.linkState = linkDisconnected
+			}
+			s.dev.mu.Unlock()
+			return nil
+		case <-timer.C:
+		}
+		s.dev.mu.Lock()
+		if s.dev.bars != old {
+			s.dev.mu.Unlock()
+			return nil
+		}
+		s.dev.linkState = linkReconnecting
+		s.dev.mu.Unlock()
+		s.wsTest.Broadcast(WSMessage{Type: "reconnecting", Data: map[string]interface{}{"port": port, "attempt": attempt}})
+
+		bars, err := connectBars(p)
+		if err != nil && isDeviceNode(port) {
+			if _, serr := os.Stat(port); serr != nil {
+				// The adapter may have come back as another node (ttyUSB0 -> ttyUSB1).
+				// The session's params are shared, so try the new port on a copy
+				// and adopt it only once it answers.
+				if c := serialpkg.DetectPorts(p); len(c) == 1 {
+					moved := withPort(p, c[0].Port, 0)
+					if bars, err = connectBars(moved); err == nil {
+						p, port = moved, c[0].Port
+					}
+				}
+			}
+		}
+		if err != nil {
+			timer.Reset(reconnectInterval)
+			continue
+		}
+
+		s.dev.mu.Lock()
+		if s.dev.bars != old || ctx.Err() != nil {
+			if s.dev.bars == old {
+				s.dev.linkState = linkDisconnected
+			}
+			s.dev.mu.Unlock()
+			_ = bars.Close()
+			return nil
+		}
+		moved := s.dev.params != p
+		s.dev.bars = bars
+		s.dev.params = p
+		s.dev.linkState = ""
+		s.dev.reconnects++
+		n := s.dev.reconnects
+		s.dev.mu.Unlock()
+		if moved {
+			s.persistSerial(id, port, p.SERIAL.BAUDRATE)
+		}
+		s.wsTest.Broadcast(WSMessage{Type: "reconnected", Data: map[string]interface{}{
+			"port":       port,
+			"attempts":   attempt,
+			"downMs":     time.Since(lostAt).Milliseconds(),
+			"reconnects": n,
+		}})
+		return bars
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+func TestBusHandlersRefuseLostLink(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3}, &models.BAR{ID: 1, LCS: 3})
+	bars, _ := simShelf(t, p)
+	s := New(t.TempDir())
+	rec, err := s.store.Put(kindCalibrated, []byte("{}"), p, "shelf_calibrated.json")
+	if err != nil {
+		t.Fatalf("store.Put: %v", err)
+	}
+	s.dev.bars, s.dev.params, s.dev.configID = bars, p, rec.ID
+	s.dev.linkState = linkReconnecting
+
+	for _, tt := range []struct{ method, path, body string }{
+		{"GET", "/api/bus", ""},
+		{"POST", "/api/test/start", "{}"},
+		{"POST", "/api/test/zero", ""},
+		{"POST", "/api/flash/start", `{"calibratedId": "` + rec.ID + `"}`},
+		{"POST", "/api/calibration/startStep", `{"stepIndex": 0}`},
+		{"POST", "/api/calibration/flash", ""},
+		{"GET", "/api/calibration/adc", ""},
+		{"POST", "/api/device/backup", ""},
+		{"POST", "/api/device/reboot", ""},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s while reconnecting: %d %s", tt.method, tt.path, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	if s.dev.opKind != "" {
+		t.Errorf("a refused request left opKind %q", s.dev.opKind)
+	}
+}
+
+func TestPersistSerialLeavesSessionParams(t *testing.T) {
+	p := calibrated(&models.BAR{ID: 0, LCS: 3})
+	p.SERIAL.PORT, p.SERIAL.BAUDRATE = "/dev/ttyUSB0", 115200
+	s := New(t.TempDir())
+	raw := []byte(`{"SERIAL": {"PORT": "/dev/ttyUSB0", "BAUDRATE": 115200, "COMMAND": "M"}}`)
+	rec, err := s.store.Put(kindConfig, raw, p, "shelf.json")
+	if err != nil {
+		t.Fatalf("store.Put: %v", err)
+	}
+	s.dev.params, s.dev.configID = rec.P, rec.ID
+
+	// Runs the way recoverLink does, while the test loop may be reading the
+	// session's params.
+	s.persistSerial(rec.ID, "/dev/ttyUSB1", 0)
+
+	if p.SERIAL.PORT != "/dev/ttyUSB0" || rec.P.SERIAL.PORT != "/dev/ttyUSB0" {
+		t.Errorf("session params changed in place to %s", p.SERIAL.PORT)
+	}
+	got, _ := s.store.Get(rec.ID)
+	if got.P == p || got.P.SERIAL.PORT != "/dev/ttyUSB1" || got.P.SERIAL.BAUDRATE != 115200 {
+		t.Errorf("stored SERIAL %+v, want a copy on /dev/ttyUSB1 at 115200", got.P.SERIAL)
+	}
+	if !strings.Contains(string(got.Raw), "/dev/ttyUSB1") || strings.Contains(string(rec.Raw), "/dev/ttyUSB1") {
+		t.Errorf("raw JSON: stored %s, earlier record %s", got.Raw, rec.Raw)
+	}
+	if len(got.P.BARS) != 1 || got.P.BARS[0] != p.BARS[0] {
+		t.Error("stored params lost the bars")
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -46,6 +47,11 @@ type DeviceSession struct {
 	calLastUpdatedAt    time.Time
 	calCalibratedID     string
 
+	// calibration read from the bars before the most recent flash
+	lastBackupID string
+	// lastBackupBars are the bar IDs that flash was limited to (nil for all).
+	lastBackupBars []int
+
 	// test mode zeros
 	testZerosMu sync.RWMutex
 	testZeros   []int64
@@ -56,6 +62,12 @@ type DeviceSession struct {
 	testTickMS      int64 // milliseconds
 	testADTimeoutMS int64 // milliseconds
 	testDebug       int32 // 0/1
+
+	// link health: "" while the port works, linkDisconnected or
+	// linkReconnecting after it was lost (see recoverLink)
+	linkState  string
+	linkLostAt time.Time
+	reconnects int // automatic reconnects since the last connect
 }
 
 type Server struct {
@@ -65,19 +77,23 @@ type Server struct {
 	dev   *DeviceSession
 
 	// WebSocket hubs
-	wsTest  *WSHub
-	wsCal   *WSHub
-	wsFlash *WSHub
+	wsTest     *WSHub
+	wsCal      *WSHub
+	wsFlash    *WSHub
+	wsFirmware *WSHub
+	wsDiag     *WSHub
 }
 
 func New(webDir string) *Server {
 	s := &Server{
-		mux:     http.NewServeMux(),
-		store:   NewConfigStore(),
-		dev:     &DeviceSession{testZeroCh: make(chan []int64, 1)},
-		wsTest:  NewWSHub(),
-		wsCal:   NewWSHub(),
-		wsFlash: NewWSHub(),
+		mux:        http.NewServeMux(),
+		store:      NewConfigStore(),
+		dev:        &DeviceSession{testZeroCh: make(chan []int64, 1)},
+		wsTest:     NewWSHub(),
+		wsCal:      NewWSHub(),
+		wsFlash:    NewWSHub(),
+		wsFirmware: NewWSHub(),
+		wsDiag:     NewWSHub(),
 	}
 
 	// API
@@ -87,9 +103,16 @@ func New(webDir string) *Server {
 	s.mux.HandleFunc("/api/debug/store/raw", s.handleDebugStoreRaw)
 	s.mux.HandleFunc("/api/upload/config", s.handleUploadConfig)
 	s.mux.HandleFunc("/api/upload/calibrated", s.handleUploadCalibrated)
+	s.mux.HandleFunc("/api/ports", s.handlePorts)
+	s.mux.HandleFunc("/api/discover", s.handleDiscover)
 	s.mux.HandleFunc("/api/connect", s.handleConnect)
 	s.mux.HandleFunc("/api/disconnect", s.handleDisconnect)
+	s.mux.HandleFunc("/api/bus", s.handleBus)
+	s.mux.HandleFunc("/api/diagnostics", s.handleDiagnostics)
+	s.mux.HandleFunc("/api/diagnostics/reset", s.handleDiagnosticsReset)
 	s.mux.HandleFunc("/api/download", s.handleDownload)
+	s.mux.HandleFunc("/api/device/backup", s.handleDeviceBackup)
+	s.mux.HandleFunc("/api/device/reboot", s.handleDeviceReboot)
 
 	s.mux.HandleFunc("/api/calibration/plan", s.handleCalPlan)
 	s.mux.HandleFunc("/api/calibration/startStep", s.handleCalStartStep)
@@ -106,11 +129,17 @@ func New(webDir string) *Server {
 
 	s.mux.HandleFunc("/api/flash/start", s.handleFlashStart)
 	s.mux.HandleFunc("/api/flash/stop", s.handleStopOp)
+	s.mux.HandleFunc("/api/flash/rollback", s.handleFlashRollback)
+
+	s.mux.HandleFunc("/api/firmware/upload", s.handleFirmwareUpload)
+	s.mux.HandleFunc("/api/firmware/stop", s.handleStopOp)
 
 	// WS
 	s.mux.HandleFunc("/ws/test", s.handleWSTest)
 	s.mux.HandleFunc("/ws/calibration", s.handleWSCal)
 	s.mux.HandleFunc("/ws/flash", s.handleWSFlash)
+	s.mux.HandleFunc("/ws/firmware", s.handleWSFirmware)
+	s.mux.HandleFunc("/ws/diagnostics", s.handleWSDiagnostics)
 
 	// Static frontend
 	fs := http.FileServer(http.Dir(webDir))
@@ -140,6 +169,16 @@ func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// apiError wraps err in an APIError, filling Code for bus protocol errors.
+func apiError(err error) APIError {
+	e := APIError{Error: err.Error()}
+	var pe *serialpkg.ProtocolError
+	if errors.As(err, &pe) {
+		e.Code = serialpkg.ErrorClass(err)
+	}
+	return e
+}
+
 func (s *Server) readJSON(r *http.Request, v interface{}) error {
 	defer r.Body.Close()
 	b, err := io.ReadAll(io.LimitReader(r.Body, 2<<20))
@@ -220,13 +259,18 @@ func decodeParameters(raw []byte) (*models.PARAMETERS, error) {
 	if len(p.BARS) == 0 {
 		return nil, fmt.Errorf("no BARS in JSON")
 	}
+	if err := serialpkg.ValidateBars(p.BARS); err != nil {
+		return nil, fmt.Errorf("invalid BARS: %w", err)
+	}
 	if p.IGNORE <= 0 {
 		p.IGNORE = p.AVG
 	}
 	return &p, nil
 }
 
-func updateRawSerialPort(raw []byte, newPort string) ([]byte, error) {
+// updateRawSerial sets SERIAL.PORT (and SERIAL.BAUDRATE when baud > 0) in the
+// raw config JSON, keeping every other field as uploaded.
+func updateRawSerial(raw []byte, newPort string, baud int) ([]byte, error) {
 	if len(raw) == 0 {
 		return nil, fmt.Errorf("empty json")
 	}
@@ -235,18 +279,16 @@ func updateRawSerialPort(raw []byte, newPort string) ([]byte, error) {
 		return nil, err
 	}
 	serialAny, ok := m["SERIAL"]
-	if !ok || serialAny == nil {
-		m["SERIAL"] = map[string]interface{}{"PORT": newPort}
-	} else {
-		sm, ok := serialAny.(map[string]interface{})
-		if !ok {
-			// if SERIAL isn't an object, overwrite it
-			m["SERIAL"] = map[string]interface{}{"PORT": newPort}
-		} else {
-			sm["PORT"] = newPort
-			m["SERIAL"] = sm
-		}
+	sm, isMap := serialAny.(map[string]interface{})
+	if !ok || serialAny == nil || !isMap {
+		// if SERIAL is missing or isn't an object, overwrite it
+		sm = map[string]interface{}{}
 	}
+	sm["PORT"] = newPort
+	if baud > 0 {
+		sm["BAUDRATE"] = baud
+	}
+	m["SERIAL"] = sm
 	return json.MarshalIndent(m, "", "  ")
 }
 
@@ -273,84 +315,213 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 	_ = s.dev.disconnectLocked()
 
 	// Connect flow:
-	// - Try configured port (if provided)
-	// - If it fails version probe, scan COM ports using Version command (AutoDetectPort)
-	// - When found, update SERIAL.PORT in stored config json and in-memory params
-	origPort := strings.TrimSpace(rec.P.SERIAL.PORT)
-	if origPort == "" {
-		origPort = ""
-	}
-
-	tryConnect := func() (*serialpkg.Leo485, error) {
-		bars, err := openBars(rec.P.SERIAL, rec.P.BARS)
-		if err != nil {
-			return nil, err
-		}
-		if _, _, _, err := bars.GetVersion(0); err != nil {
-			_ = bars.Close()
-			return nil, fmt.Errorf("device version probe failed: %w", err)
-		}
-		return bars, nil
-	}
-
-	bars, err := tryConnect()
+	// - Use the port chosen by the user (req.Port), else the configured port
+	// - If it fails version probe, probe candidate ports and baud rates using Version command
+	// - When exactly one answers, connect there; when several answer, return them so the UI can offer a picker
+	// - A port other than the configured one is written to the stored config json and in-memory params
+	//   only once the connect has succeeded
+	p := rec.P
+	persist := false
+	if port := strings.TrimSpace(req.Port); port != "" {
+		p = withPort(rec.P, port, 0)
+		persist = true
+	}
+	bars, err := connectBars(p)
 	if err != nil {
 		// If port missing or wrong, scan for the correct port using Version probing.
-		found := serialpkg.AutoDetectPort(rec.P)
-		if strings.TrimSpace(found) == "" {
-			s.writeJSON(w, 400, APIError{Error: err.Error()})
+		candidates := serialpkg.DetectPortsWithOptions(p, serialpkg.DetectOptions{Sweep: true})
+		if len(candidates) == 0 {
+			s.writeJSON(w, 400, apiError(err))
+			return
+		}
+		if len(candidates) > 1 {
+			s.writeJSON(w, 409, PortsResponse{
+				Error:      fmt.Sprintf("%d devices answered; choose a port", len(candidates)),
+				Candidates: candidates,
+			})
 			return
 		}
-		// Update and retry
-		rec.P.SERIAL.PORT = found
-		bars, err = tryConnect()
+		p = withPort(rec.P, candidates[0].Port, candidates[0].Baud)
+		bars, err = connectBars(p)
 		if err != nil {
-			s.writeJSON(w, 400, APIError{Error: err.Error()})
+			s.writeJSON(w, 400, apiError(err))
 			return
 		}
+		persist = true
+	}
+	if persist {
 		// Persist updated port back into stored config JSON so future operations use it.
-		_ = s.store.Update(rec.ID, func(r *ConfigRecord) error {
-			raw2, uerr := updateRawSerialPort(r.Raw, found)
-			if uerr == nil {
-				r.Raw = raw2
-			}
-			// Explicitly update r.P.SERIAL.PORT to ensure consistency
-			if r.P != nil && r.P.SERIAL != nil {
-				r.P.SERIAL.PORT = found
-			}
-			return nil
-		})
+		s.persistSerial(rec.ID, p.SERIAL.PORT, p.SERIAL.BAUDRATE)
 	}
 
 	s.dev.configID = rec.ID
 	s.dev.params = rec.P
 	s.dev.bars = bars
 
-	// Non-blocking version mismatch warning (connect continues as normal).
-	warn := ""
-	if rec.P != nil && rec.P.VERSION != nil {
-		did, dmaj, dmin, verr := bars.GetVersion(0)
-		if verr == nil {
-			ev := rec.P.VERSION
-			if did != ev.ID || dmaj != ev.MAJOR || dmin != ev.MINOR {
-				warn = fmt.Sprintf(
-					"Version mismatch: device %d.%d.%d, config %d.%d.%d",
-					did, dmaj, dmin,
-					ev.ID, ev.MAJOR, ev.MINOR,
-				)
-			}
-		}
-	}
+	// Every bar's firmware, judged against VERSIONS (or the family of
+	// VERSION). Connect continues either way; problems come back as a warning.
+	versions := bars.CheckVersions(serialpkg.AllowedVersions(rec.P))
+	warn := versionWarning(versions, rec.P.VERSION)
 
 	s.writeJSON(w, 200, ConnectResponse{
 		Connected: true,
 		Port:      rec.P.SERIAL.PORT,
 		Bars:      len(rec.P.BARS),
 		LCs:       bars.NLCs,
+		Versions:  versions,
+		Mixed:     serialpkg.MixedVersions(versions),
 		Warning:   warn,
 	})
 }
 
+// versionWarning sums up what is wrong with the bars' firmware: bars that did
+// not answer or run a version outside the allowed range, a shelf mixing
+// versions, and a version other than the config's VERSION. It returns "" when
+// there is nothing to report.
+func versionWarning(checks []serialpkg.VersionCheck, want *models.VERSION) string {
+	var parts []string
+	for _, c := range checks {
+		switch {
+		case c.Error != "":
+			parts = append(parts, fmt.Sprintf("bar %d (ID %d): no version reply", c.Bar, c.ID))
+		case !c.Compatible:
+			parts = append(parts, fmt.Sprintf("bar %d (ID %d): firmware %s not allowed: %s", c.Bar, c.ID, c.Version, c.Reason))
+		}
+	}
+	if serialpkg.MixedVersions(checks) {
+		parts = append(parts, "bars run different firmware versions")
+	}
+	if want != nil {
+		for _, c := range checks {
+			if c.Firmware != nil && *c.Firmware != *want {
+				parts = append(parts, fmt.Sprintf(
+					"Version mismatch: device %s, config %d.%d.%d",
+					c.Version, want.ID, want.MAJOR, want.MINOR,
+				))
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// persistSerial writes port and baud into the stored config JSON so future operations use them.
+func (s *Server) persistSerial(id, port string, baud int) {
+	_ = s.store.Update(id, func(r *ConfigRecord) error {
+		raw2, uerr := updateRawSerial(r.Raw, port, baud)
+		if uerr == nil {
+			r.Raw = raw2
+		}
+		// Explicitly update r.P.SERIAL to ensure consistency. r.P may be the
+		// params of a running session, so it is replaced, not changed.
+		if r.P != nil {
+			r.P = withPort(r.P, port, baud)
+		}
+		return nil
+	})
+}
+
+// handlePorts probes candidate serial ports with the Version command for the
+// bars of the given config and lists every port/baud that answered.
+// Query: configId (required), sweep=1 to also try StandardBauds.
+func (s *Server) handlePorts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	rec, ok := s.store.Get(r.URL.Query().Get("configId"))
+	if !ok || rec.Kind != kindConfig {
+		s.writeJSON(w, 404, APIError{Error: "configId not found (upload config.json first)"})
+		return
+	}
+	s.dev.mu.Lock()
+	busy := s.dev.bars != nil
+	s.dev.mu.Unlock()
+	if busy {
+		s.writeJSON(w, 400, APIError{Error: "disconnect before scanning ports"})
+		return
+	}
+	opts := serialpkg.DetectOptions{Sweep: r.URL.Query().Get("sweep") == "1"}
+	s.writeJSON(w, 200, PortsResponse{Candidates: serialpkg.DetectPortsWithOptions(rec.P, opts)})
+}
+
+// handleDiscover scans the bus for bars (V + measure command), infers each
+// bar's LCS mask and version, and stores the result as a new config record.
+func (s *Server) handleDiscover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var req DiscoverRequest
+	if err := s.readJSON(r, &req); err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	s.dev.mu.Lock()
+	busy := s.dev.bars != nil
+	s.dev.mu.Unlock()
+	if busy {
+		s.writeJSON(w, 400, APIError{Error: "disconnect before discovering"})
+		return
+	}
+	ser := &models.SERIAL{PORT: strings.TrimSpace(req.Port), BAUDRATE: req.Baudrate, COMMAND: req.Command}
+	if ser.BAUDRATE <= 0 {
+		ser.BAUDRATE = 115200
+	}
+	if ser.COMMAND == "" {
+		ser.COMMAND = "M"
+	}
+	ids := serialpkg.DefaultDiscoverIDs()
+	if req.MaxID > 0 {
+		var err error
+		if ids, err = serialpkg.DiscoverIDs(req.MaxID); err != nil {
+			s.writeJSON(w, 400, APIError{Error: err.Error()})
+			return
+		}
+	}
+	if ser.PORT == "" {
+		probe := &models.PARAMETERS{SERIAL: ser}
+		for _, id := range ids {
+			probe.BARS = append(probe.BARS, &models.BAR{ID: id})
+		}
+		found := serialpkg.DetectPortsWithOptions(probe, serialpkg.DetectOptions{Sweep: true})
+		if len(found) == 0 {
+			s.writeJSON(w, 400, APIError{Error: "no device answered on any port"})
+			return
+		}
+		ser.PORT = found[0].Port
+		ser.BAUDRATE = found[0].Baud
+	}
+	p, found, err := serialpkg.DiscoverParameters(ser, ids)
+	if err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
+	p.WEIGHT = req.Weight
+	if p.WEIGHT <= 0 {
+		p.WEIGHT = 500
+	}
+	p.AVG = req.Avg
+	if p.AVG <= 0 {
+		p.AVG = 10
+	}
+	p.IGNORE = req.Ignore
+	if p.IGNORE <= 0 {
+		p.IGNORE = p.AVG
+	}
+	raw, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		s.writeJSON(w, 500, APIError{Error: err.Error()})
+		return
+	}
+	rec, err := s.store.Put(kindConfig, raw, p, "config.json")
+	if err != nil {
+		s.writeJSON(w, 500, APIError{Error: err.Error()})
+		return
+	}
+	s.writeJSON(w, 200, DiscoverResponse{ConfigID: rec.ID, Parameters: p, Bars: found})
+}
+
 func (s *Server) handleDisconnect(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.NotFound(w, r)
@@ -374,6 +545,39 @@ func (s *Server) handleStopOp(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, 200, map[string]bool{"ok": true})
 }
 
+// handleBus reports the bus scheduler's queue depth and wait times.
+func (s *Server) handleBus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	s.dev.mu.Lock()
+	bars := s.dev.bars
+	linkErr := s.dev.linkErrLocked()
+	s.dev.mu.Unlock()
+	if bars == nil {
+		s.writeJSON(w, 400, APIError{Error: "not connected"})
+		return
+	}
+	if linkErr != nil {
+		s.writeJSON(w, 503, apiError(linkErr))
+		return
+	}
+	s.writeJSON(w, 200, bars.BusStats())
+}
+
+// writingLocked reports whether an operation that writes to the bars or
+// reboots them is running. Such an operation cannot be cancelled midway
+// without leaving bars in update mode, so a new one must wait for it instead
+// of taking over the session.
+func (d *DeviceSession) writingLocked() bool {
+	switch d.opKind {
+	case "flash", "calibrationFlash", "firmware", "reboot":
+		return true
+	}
+	return false
+}
+
 func (d *DeviceSession) cancelLocked() {
 	if d.opCancel != nil {
 		d.opCancel()
@@ -389,6 +593,8 @@ func (d *DeviceSession) disconnectLocked() error {
 	d.bars = nil
 	d.params = nil
 	d.configID = ""
+	d.linkState = ""
+	d.reconnects = 0
 	return nil
 }
 
@@ -405,7 +611,7 @@ func (s *Server) handleCalPlan(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
-	steps, _, err := buildCalibrationPlan(p, bars.NLCs)
+	steps, _, err := buildCalibrationPlan(p)
 	if err != nil {
 		s.writeJSON(w, 500, APIError{Error: err.Error()})
 		return
@@ -439,6 +645,16 @@ func (s *Server) handleCalStartStep(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
+	if s.dev.writingLocked() {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 409, APIError{Error: "busy"})
+		return
+	}
 	s.dev.cancelLocked()
 	ctx, cancel := context.WithCancel(context.Background())
 	s.dev.opCancel = cancel
@@ -447,7 +663,7 @@ func (s *Server) handleCalStartStep(w http.ResponseWriter, r *http.Request) {
 	p := s.dev.params
 	s.dev.mu.Unlock()
 
-	steps, nloads, err := buildCalibrationPlan(p, bars.NLCs)
+	steps, nloads, err := buildCalibrationPlan(p)
 	if err != nil {
 		s.writeJSON(w, 500, APIError{Error: err.Error()})
 		return
@@ -503,22 +719,18 @@ func (s *Server) handleCalStartStep(w http.ResponseWriter, r *http.Request) {
 			})
 		})
 		if err != nil {
-			s.wsCal.Broadcast(WSMessage{Type: "error", Data: map[string]string{"error": err.Error()}})
+			s.wsCal.Broadcast(WSMessage{Type: "error", Data: apiError(err)})
 			return
 		}
 
-		nbars := len(p.BARS)
-		nlcs := bars.NLCs
-		calibs := 3 * (nbars - 1)
-
 		s.dev.calMu.Lock()
 		defer s.dev.calMu.Unlock()
 
 		if step.Kind == CalStepZero {
-			s.dev.calAd0 = updateMatrixZero(flat, calibs, nlcs)
-			s.dev.calAdv = matrix.NewMatrix(nloads, nbars*nlcs)
+			s.dev.calAd0 = updateMatrixZero(flat, nloads)
+			s.dev.calAdv = matrix.NewMatrix(nloads, len(flat))
 		} else if s.dev.calAdv != nil {
-			s.dev.calAdv = updateMatrixWeight(s.dev.calAdv, flat, step.Index, nlcs)
+			s.dev.calAdv = updateMatrixWeight(s.dev.calAdv, flat, step.Index)
 		}
 		s.dev.calReceived++
 
@@ -621,6 +833,11 @@ func (s *Server) handleCalFlash(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
 	if s.dev.opKind != "" {
 		s.dev.mu.Unlock()
 		s.writeJSON(w, 400, APIError{Error: "busy"})
@@ -641,9 +858,10 @@ func (s *Server) handleCalFlash(w http.ResponseWriter, r *http.Request) {
 			s.dev.opCancel = nil
 			s.dev.mu.Unlock()
 		}()
-		err := flashParameters(ctx, bars, p, func(progress map[string]interface{}) {
+		progress := func(progress map[string]interface{}) {
 			s.wsCal.Broadcast(WSMessage{Type: "flashProgress", Data: progress})
-		})
+		}
+		err := flashParameters(ctx, bars, p, flashOptions{backup: s.backupHook(progress, nil)}, progress)
 		if err != nil {
 			// Include calibratedId so the UI can still download the file even if flashing fails.
 			s.wsCal.Broadcast(WSMessage{Type: "error", Data: map[string]interface{}{"error": err.Error(), "calibratedId": calID}})
@@ -939,7 +1157,12 @@ func (s *Server) handleCalADC(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
-	bars := s.dev.bars
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
+	bars := s.dev.bars.WithPriority(serialpkg.PriorityBackground)
 	opKind := s.dev.opKind
 	s.dev.mu.Unlock()
 
@@ -962,9 +1185,9 @@ func (s *Server) handleCalADC(w http.ResponseWriter, r *http.Request) {
 	}
 
 	nBars := len(bars.Bars)
-	nLCs := bars.NLCs
 	current := make([][]int64, nBars)
 	for i := 0; i < nBars; i++ {
+		nLCs := bars.NLCs[i]
 		bruts, err := bars.GetADs(i)
 		row := make([]int64, nLCs)
 		if err == nil && len(bruts) > 0 {
@@ -994,13 +1217,15 @@ func (s *Server) handleCalADC(w http.ResponseWriter, r *http.Request) {
 
 func encodeCalibratedJSON(p *models.PARAMETERS) ([]byte, error) {
 	payload := struct {
-		SERIAL *models.SERIAL `json:"SERIAL"`
-		BARS   []*models.BAR  `json:"BARS"`
-		AVG    int            `json:"AVG"`
-		IGNORE int            `json:"IGNORE"`
-		DEBUG  bool           `json:"DEBUG"`
+		SERIAL *models.SERIAL   `json:"SERIAL"`
+		BUSES  []*models.SERIAL `json:"BUSES,omitempty"`
+		BARS   []*models.BAR    `json:"BARS"`
+		AVG    int              `json:"AVG"`
+		IGNORE int              `json:"IGNORE"`
+		DEBUG  bool             `json:"DEBUG"`
 	}{
 		SERIAL: p.SERIAL,
+		BUSES:  p.BUSES,
 		BARS:   p.BARS,
 		AVG:    p.AVG,
 		IGNORE: p.IGNORE,
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/CK6170/Calrunrilla-go/models"
 )
@@ -31,6 +32,7 @@ type ConfigRecord struct {
 	P    *models.PARAMETERS
 	// Original filename from upload (best-effort, may be empty)
 	Filename string
+	Created  time.Time
 }
 
 // ConfigStore is a thread-safe in-memory map keyed by ConfigRecord.ID.
@@ -42,10 +44,11 @@ type ConfigStore struct {
 // ConfigRecordInfo is a safe, JSON-friendly view of a stored record for debugging.
 // It intentionally excludes the raw bytes to keep list responses small.
 type ConfigRecordInfo struct {
-	ID       string `json:"id"`
-	Kind     string `json:"kind"`
-	Filename string `json:"filename,omitempty"`
-	Bytes    int    `json:"bytes"`
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Filename string    `json:"filename,omitempty"`
+	Bytes    int       `json:"bytes"`
+	Created  time.Time `json:"created"`
 }
 
 // NewConfigStore constructs an empty in-memory store.
@@ -60,7 +63,7 @@ func (s *ConfigStore) Put(kind configKind, raw []byte, p *models.PARAMETERS, fil
 	if err != nil {
 		return nil, err
 	}
-	rec := &ConfigRecord{ID: id, Kind: kind, Raw: raw, P: p, Filename: filename}
+	rec := &ConfigRecord{ID: id, Kind: kind, Raw: raw, P: p, Filename: filename, Created: time.Now()}
 	s.mu.Lock()
 	s.m[id] = rec
 	s.mu.Unlock()
@@ -90,12 +93,15 @@ func (s *ConfigStore) List() []ConfigRecordInfo {
 			Kind:     string(r.Kind),
 			Filename: r.Filename,
 			Bytes:    len(r.Raw),
+			Created:  r.Created,
 		})
 	}
 	return out
 }
 
-// Update safely mutates an existing record under a write lock.
+// Update safely mutates an existing record under a write lock. fn works on a
+// copy that replaces the record only when fn succeeds, so a record returned
+// by Get earlier never changes under its holder.
 func (s *ConfigStore) Update(id string, fn func(r *ConfigRecord) error) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -103,7 +109,12 @@ func (s *ConfigStore) Update(id string, fn func(r *ConfigRecord) error) error {
 	if !ok || r == nil {
 		return fmt.Errorf("not found")
 	}
-	return fn(r)
+	c := *r
+	if err := fn(&c); err != nil {
+		return err
+	}
+	s.m[id] = &c
+	return nil
 }
 
 // newID returns a short random hex identifier suitable for URLs.
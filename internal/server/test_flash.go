@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"sync/atomic"
 	"time"
 
+	"github.com/CK6170/Calrunrilla-go/models"
 	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
 )
 
@@ -59,6 +61,11 @@ func (s *Server) handleTestStart(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
 	s.dev.cancelLocked()
 	ctx, cancel := context.WithCancel(context.Background())
 	s.dev.opCancel = cancel
@@ -84,7 +91,7 @@ func (s *Server) handleTestStart(w http.ResponseWriter, r *http.Request) {
 		// Also drain any leftover bytes sitting in the serial buffer (common right after flash/reboot).
 		// We ignore errors/timeouts here; this is best-effort.
 		for i := 0; i < 3; i++ {
-			_, _ = serialpkg.ReadUntil(bars.Serial, 25)
+			bars.Drain(25)
 		}
 
 		var lastErr error
@@ -95,7 +102,7 @@ func (s *Server) handleTestStart(w http.ResponseWriter, r *http.Request) {
 				return
 			default:
 			}
-			if err := readFactorsFromDevice(bars, p); err != nil {
+			if _, err := readFactorsFromDevice(bars, p); err != nil {
 				lastErr = err
 			} else {
 				lastErr = nil
@@ -141,24 +148,17 @@ func (s *Server) handleTestStart(w http.ResponseWriter, r *http.Request) {
 			})
 		})
 		if err != nil {
-			s.wsTest.Broadcast(WSMessage{Type: "error", Data: map[string]string{"error": err.Error()}})
+			s.wsTest.Broadcast(WSMessage{Type: "error", Data: apiError(err)})
 			return
 		}
 		s.wsTest.Broadcast(WSMessage{Type: "zerosDone"})
 		// Log zeros that were collected
-		nlcs := bars.NLCs
+		zerosPerBar := splitPerBar(zeros, bars.NLCs)
 		zerosSummary := make([]map[string]interface{}, len(p.BARS))
 		for i := 0; i < len(p.BARS); i++ {
-			barZeros := make([]int64, nlcs)
-			for j := 0; j < nlcs; j++ {
-				idx := i*nlcs + j
-				if idx < len(zeros) {
-					barZeros[j] = zeros[idx]
-				}
-			}
 			zerosSummary[i] = map[string]interface{}{
 				"bar":   i + 1,
-				"zeros": barZeros,
+				"zeros": zerosPerBar[i],
 			}
 		}
 		s.wsTest.Broadcast(WSMessage{Type: "zerosSummary", Data: map[string]interface{}{"zeros": zerosSummary}})
@@ -168,6 +168,9 @@ func (s *Server) handleTestStart(w http.ResponseWriter, r *http.Request) {
 		s.dev.testZeros = zeros
 		s.dev.testZerosMu.Unlock()
 
+		// Live polling yields the bus to zeroing, flashing and calibration.
+		poll := bars.WithPriority(serialpkg.PriorityBackground)
+
 		// Test snapshot cadence. Lower values increase serial load significantly.
 		timer := time.NewTimer(50 * time.Millisecond)
 		defer timer.Stop()
@@ -208,10 +211,27 @@ func (s *Server) handleTestStart(w http.ResponseWriter, r *http.Request) {
 
 				includeDebug := atomic.LoadInt32(&s.dev.testDebug) != 0
 				adTimeout := int(atomic.LoadInt64(&s.dev.testADTimeoutMS))
-				snap, err := computeTestSnapshot(bars, p, currentZeros, includeDebug, adTimeout)
+				snap, err := computeTestSnapshot(poll, p, currentZeros, includeDebug, adTimeout)
+				if err != nil && linkLost(err, p) {
+					// The port is gone: reopen it and carry on with the same
+					// zeros instead of reporting the dead handle every tick.
+					bars = s.recoverLink(ctx, bars, err)
+					if bars == nil {
+						s.wsTest.Broadcast(WSMessage{Type: "stopped"})
+						return
+					}
+					// The bars may have come back on another port, with
+					// the session's params replaced by a copy naming it.
+					s.dev.mu.Lock()
+					p = s.dev.params
+					s.dev.mu.Unlock()
+					poll = bars.WithPriority(serialpkg.PriorityBackground)
+					timer.Reset(50 * time.Millisecond)
+					continue
+				}
 				if err != nil {
 					// Log error but don't stop polling - might be transient
-					s.wsTest.Broadcast(WSMessage{Type: "error", Data: map[string]string{"error": err.Error()}})
+					s.wsTest.Broadcast(WSMessage{Type: "error", Data: apiError(err)})
 					// Continue polling instead of returning
 					// reschedule using latest tick
 					tickMS := atomic.LoadInt64(&s.dev.testTickMS)
@@ -261,6 +281,11 @@ func (s *Server) handleTestZero(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
 	if s.dev.opKind != "test" {
 		s.dev.mu.Unlock()
 		s.writeJSON(w, 400, APIError{Error: "test mode not active"})
@@ -278,32 +303,25 @@ func (s *Server) handleTestZero(w http.ResponseWriter, r *http.Request) {
 		// Use background context so zero collection doesn't interfere with test loop
 		ctx := context.Background()
 
-		zeros, err := collectAveragedZeros(ctx, bars, p, p.AVG, func(z map[string]int) {
+		zeros, err := collectAveragedZeros(ctx, bars.WithPriority(serialpkg.PriorityAdmin), p, p.AVG, func(z map[string]int) {
 			s.wsTest.Broadcast(WSMessage{
 				Type: "zerosProgress",
 				Data: z,
 			})
 		})
 		if err != nil {
-			s.wsTest.Broadcast(WSMessage{Type: "error", Data: map[string]string{"error": "zero collection failed: " + err.Error()}})
+			s.wsTest.Broadcast(WSMessage{Type: "error", Data: apiError(fmt.Errorf("zero collection failed: %w", err))})
 			return
 		}
 		s.wsTest.Broadcast(WSMessage{Type: "zerosDone"})
 
 		// Log zeros that were collected
-		nlcs := bars.NLCs
+		zerosPerBar := splitPerBar(zeros, bars.NLCs)
 		zerosSummary := make([]map[string]interface{}, len(p.BARS))
 		for i := 0; i < len(p.BARS); i++ {
-			barZeros := make([]int64, nlcs)
-			for j := 0; j < nlcs; j++ {
-				idx := i*nlcs + j
-				if idx < len(zeros) {
-					barZeros[j] = zeros[idx]
-				}
-			}
 			zerosSummary[i] = map[string]interface{}{
 				"bar":   i + 1,
-				"zeros": barZeros,
+				"zeros": zerosPerBar[i],
 			}
 		}
 		s.wsTest.Broadcast(WSMessage{Type: "zerosSummary", Data: map[string]interface{}{"zeros": zerosSummary}})
@@ -339,13 +357,43 @@ func (s *Server) handleFlashStart(w http.ResponseWriter, r *http.Request) {
 		s.writeJSON(w, 404, APIError{Error: "calibratedId not found (upload _calibrated.json first)"})
 		return
 	}
+	if req.DryRun {
+		frames, err := planFlash(rec.P, req.Bars)
+		if err != nil {
+			s.writeJSON(w, 400, APIError{Error: err.Error()})
+			return
+		}
+		s.writeJSON(w, 200, FlashDryRunResponse{Frames: frames})
+		return
+	}
 
+	s.startFlash(w, rec.P, flashOptions{barIDs: req.Bars, force: req.Force}, true)
+}
+
+// startFlash runs flashParameters in the background with progress on the
+// flash WebSocket. With backup set, the calibration the bars held before is
+// saved first and becomes the target of /api/flash/rollback.
+func (s *Server) startFlash(w http.ResponseWriter, p *models.PARAMETERS, opts flashOptions, backup bool) {
+	if _, err := serialpkg.SelectBars(p.BARS, opts.barIDs); err != nil {
+		s.writeJSON(w, 400, APIError{Error: err.Error()})
+		return
+	}
 	s.dev.mu.Lock()
 	if s.dev.bars == nil {
 		s.dev.mu.Unlock()
 		s.writeJSON(w, 400, APIError{Error: "not connected"})
 		return
 	}
+	if err := s.dev.linkErrLocked(); err != nil {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 503, apiError(err))
+		return
+	}
+	if s.dev.writingLocked() {
+		s.dev.mu.Unlock()
+		s.writeJSON(w, 409, APIError{Error: "busy"})
+		return
+	}
 	s.dev.cancelLocked()
 	ctx, cancel := context.WithCancel(context.Background())
 	s.dev.opCancel = cancel
@@ -354,11 +402,23 @@ func (s *Server) handleFlashStart(w http.ResponseWriter, r *http.Request) {
 	s.dev.mu.Unlock()
 
 	go func() {
-		err := flashParameters(ctx, bars, rec.P, func(progress map[string]interface{}) {
+		defer func() {
+			s.dev.mu.Lock()
+			if s.dev.opKind == "flash" {
+				s.dev.opKind = ""
+				s.dev.opCancel = nil
+			}
+			s.dev.mu.Unlock()
+		}()
+		progress := func(progress map[string]interface{}) {
 			s.wsFlash.Broadcast(WSMessage{Type: "progress", Data: progress})
-		})
+		}
+		if backup {
+			opts.backup = s.backupHook(progress, opts.barIDs)
+		}
+		err := flashParameters(ctx, bars, p, opts, progress)
 		if err != nil {
-			s.wsFlash.Broadcast(WSMessage{Type: "error", Data: map[string]string{"error": err.Error()}})
+			s.wsFlash.Broadcast(WSMessage{Type: "error", Data: apiError(err)})
 			return
 		}
 		s.wsFlash.Broadcast(WSMessage{Type: "done"})
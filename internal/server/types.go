@@ -1,11 +1,19 @@
 package server
 
-import "time"
+import (
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
 
 // APIError is the canonical error envelope returned by JSON endpoints.
 // The frontend expects the `error` field and will surface it to the user.
+// Code is the serial failure class ("timeout", "crc", "address", "malformed",
+// "nak") when the error came from the bus.
 type APIError struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
 // HealthResponse is returned by /api/health to confirm the server is running.
@@ -22,20 +30,55 @@ type UploadResponse struct {
 }
 
 // ConnectRequest selects which previously uploaded config (configId) to connect with.
+// Port optionally overrides SERIAL.PORT, e.g. after the user picked one of the
+// candidates returned by /api/ports.
 type ConnectRequest struct {
 	ConfigID string `json:"configId"`
+	Port     string `json:"port,omitempty"`
+}
+
+// PortsResponse lists the serial ports that answered the version probe.
+//
+// It is returned by /api/ports, and by /api/connect (status 409) when the
+// configured port does not answer and several candidates were found.
+type PortsResponse struct {
+	Error      string                    `json:"error,omitempty"`
+	Candidates []serialpkg.PortCandidate `json:"candidates"`
 }
 
 // ConnectResponse is returned by /api/connect.
 //
-// Warning is best-effort (e.g. version mismatch) and does not necessarily mean
-// the connection failed.
+// Versions holds every bar's firmware and whether VERSIONS allows it; Mixed is
+// set when the bars do not all run the same version. Warning is best-effort
+// (e.g. version mismatch) and does not necessarily mean the connection failed.
 type ConnectResponse struct {
-	Connected bool   `json:"connected"`
-	Port      string `json:"port"`
-	Bars      int    `json:"bars"`
-	LCs       int    `json:"lcs"`
-	Warning   string `json:"warning,omitempty"`
+	Connected bool                     `json:"connected"`
+	Port      string                   `json:"port"`
+	Bars      int                      `json:"bars"`
+	LCs       []int                    `json:"lcs"` // active load cells of each bar
+	Versions  []serialpkg.VersionCheck `json:"versions"`
+	Mixed     bool                     `json:"mixedVersions,omitempty"`
+	Warning   string                   `json:"warning,omitempty"`
+}
+
+// DiscoverRequest scans a port for bars and builds a config from what answered.
+// Empty Port auto-detects; zero numeric fields fall back to defaults.
+type DiscoverRequest struct {
+	Port     string `json:"port,omitempty"`
+	Baudrate int    `json:"baudrate,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	Avg      int    `json:"avg,omitempty"`
+	Ignore   int    `json:"ignore,omitempty"`
+	MaxID    int    `json:"maxId,omitempty"` // highest address to scan (default 9)
+}
+
+// DiscoverResponse returns the generated config, already stored under ConfigID
+// so the UI can connect with it or download it.
+type DiscoverResponse struct {
+	ConfigID   string                    `json:"configId"`
+	Parameters *models.PARAMETERS        `json:"parameters"`
+	Bars       []serialpkg.DiscoveredBar `json:"bars"`
 }
 
 // CalPlanResponse returns a linear list of steps the UI should walk through.
@@ -64,9 +107,63 @@ type CalComputeResponse struct {
 	CalibratedID string `json:"calibratedId"`
 }
 
+// BackupResponse is returned by /api/device/backup. CalibratedID can be
+// downloaded via /api/download or flashed like any calibrated upload. Zeros is
+// false when some bar's firmware could not report its stored zeros, in which
+// case those bars carry ZERO 0.
+type BackupResponse struct {
+	CalibratedID string `json:"calibratedId"`
+	Filename     string `json:"filename"`
+	Zeros        bool   `json:"zeros"`
+}
+
 // FlashStartRequest identifies which calibrated json should be flashed.
+// Bars limits the flash to those bar IDs; the other bars are not put into
+// update mode or rebooted. Bars that already hold the values are skipped
+// unless Force is set. DryRun returns the frames the flash would send instead
+// of sending them.
 type FlashStartRequest struct {
 	CalibratedID string `json:"calibratedId"`
+	Bars         []int  `json:"bars,omitempty"`
+	Force        bool   `json:"force,omitempty"`
+	DryRun       bool   `json:"dryRun,omitempty"`
+}
+
+// FlashDryRunResponse lists, in send order, every frame a flash would write.
+type FlashDryRunResponse struct {
+	Frames []serialpkg.PlannedFrame `json:"frames"`
+}
+
+// FirmwareUploadResponse describes the image a firmware upload is sending.
+// Version is the banner found in the image, empty if it has none.
+type FirmwareUploadResponse struct {
+	Size    int    `json:"size"`
+	Blocks  int    `json:"blocks"`
+	CRC     string `json:"crc"`
+	Version string `json:"version,omitempty"`
+}
+
+// DeviceRebootRequest selects the bars /api/device/reboot restarts (empty for
+// all) and how long each may take to answer V again (0 for the default).
+type DeviceRebootRequest struct {
+	Bars      []int `json:"bars,omitempty"`
+	TimeoutMS int   `json:"timeoutMs,omitempty"`
+}
+
+// DeviceRebootResponse reports each rebooted bar and its boot time; OK is set
+// when every bar came back.
+type DeviceRebootResponse struct {
+	OK      bool                     `json:"ok"`
+	Results []serialpkg.RebootResult `json:"results"`
+}
+
+// FlashRollbackRequest selects the backup /api/flash/rollback re-flashes.
+// An empty BackupID means the backup taken before the most recent flash, and
+// then an empty Bars restores the bars that flash touched.
+type FlashRollbackRequest struct {
+	BackupID string `json:"backupId,omitempty"`
+	Bars     []int  `json:"bars,omitempty"`
+	Force    bool   `json:"force,omitempty"`
 }
 
 // TestStartRequest configures the live test loop on startup.
@@ -83,3 +180,12 @@ type TestConfigRequest struct {
 	TickMS      int  `json:"tickMs,omitempty"`
 	ADTimeoutMS int  `json:"adTimeoutMs,omitempty"`
 }
+
+// DiagnosticsResponse is returned by /api/diagnostics and pushed as
+// "linkStats" on /ws/diagnostics.
+type DiagnosticsResponse struct {
+	Link       serialpkg.LinkReport `json:"link"`
+	Bus        serialpkg.BusStats   `json:"bus"`
+	LinkState  string               `json:"linkState,omitempty"` // "disconnected" or "reconnecting" while the port is lost
+	Reconnects int                  `json:"reconnects"`
+}
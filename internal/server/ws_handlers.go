@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -35,6 +36,46 @@ func (s *Server) handleWSFlash(w http.ResponseWriter, r *http.Request) {
 	s.handleWSHub(w, r, s.wsFlash)
 }
 
+// handleWSFirmware streams progress events of a firmware upload.
+func (s *Server) handleWSFirmware(w http.ResponseWriter, r *http.Request) {
+	s.handleWSHub(w, r, s.wsFirmware)
+}
+
+// handleWSDiagnostics streams "linkStats" snapshots: one per
+// diagnosticsInterval to each client while a device is connected, plus one
+// after every reset.
+func (s *Server) handleWSDiagnostics(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := s.wsDiag.Add(conn)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(diagnosticsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if d, ok := s.diagnostics(); ok {
+					_ = client.Send(WSMessage{Type: "linkStats", Data: d})
+				}
+			}
+		}
+	}()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			close(done)
+			s.wsDiag.Remove(client)
+			return
+		}
+	}
+}
+
 // handleWSHub is the shared "upgrade + register + read-loop" for all hubs.
 //
 // This endpoint does not currently handle incoming messages; the read-loop
@@ -70,7 +70,26 @@ var (
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("Usage: calrunrilla <config.json>")
+		log.Fatal("Usage: calrunrilla <config.json> | calrunrilla discover [flags] | calrunrilla decode <capture.jsonl> | calrunrilla backup [flags] <config.json> | calrunrilla firmware [flags] <image.bin> <config.json> | calrunrilla reboot [flags] <config.json>")
+	}
+
+	// Subcommands take their own flags and never load a config first.
+	switch os.Args[1] {
+	case "discover":
+		calibration.DiscoverConfig(os.Args[2:])
+		return
+	case "decode":
+		calibration.DecodeCapture(os.Args[2:])
+		return
+	case "backup":
+		calibration.BackupConfig(os.Args[2:], AppVersion, AppBuild)
+		return
+	case "firmware":
+		calibration.FirmwareConfig(os.Args[2:])
+		return
+	case "reboot":
+		calibration.RebootConfig(os.Args[2:])
+		return
 	}
 
 	// Support a simple version flag for CI and quick checks. If any argument is
@@ -90,6 +109,19 @@ func main() {
 		if a == "--flash" || a == "-f" {
 			os.Setenv("CALRUNRILLA_RUN_FLASH", "1")
 		}
+		if a == "--rollback" {
+			os.Setenv("CALRUNRILLA_RUN_ROLLBACK", "1")
+		}
+		if a == "--dry-run" {
+			os.Setenv("CALRUNRILLA_DRY_RUN", "1")
+		}
+		if a == "--force" {
+			os.Setenv("CALRUNRILLA_FORCE", "1")
+		}
+		// --bars=1,3 limits --flash/--rollback to those bar IDs
+		if strings.HasPrefix(a, "--bars=") {
+			os.Setenv("CALRUNRILLA_BARS", strings.TrimPrefix(a, "--bars="))
+		}
 	}
 
 	// Find the first non-flag argument and treat it as the config path. This
@@ -112,7 +144,11 @@ func main() {
 		return
 	}
 	if os.Getenv("CALRUNRILLA_RUN_FLASH") == "1" {
-		calibration.FlashOnly(configPath)
+		calibration.FlashOnly(configPath, flashOptions())
+		return
+	}
+	if os.Getenv("CALRUNRILLA_RUN_ROLLBACK") == "1" {
+		calibration.RollbackFlash(configPath, flashOptions())
 		return
 	}
 	// Route the standard logger output through our package-scope redWriter
@@ -168,7 +204,7 @@ func main() {
 				params.SERIAL.PORT = p
 			}
 			ui.DrainKeys()
-			bars := serialpkg.NewLeo485(params.SERIAL, params.BARS)
+			bars := serialpkg.NewShelf(&params)
 			func() {
 				defer func() { _ = bars.Close() }()
 				if !calibration.ProbeVersion(bars, &params) {
@@ -249,3 +285,22 @@ func probeVersion(bars *serialpkg.Leo485, parameters *PARAMETERS) bool {
 	return err == nil
 }
 */
+
+// flashOptions builds the headless flash options from the --dry-run, --force
+// and --bars flags.
+func flashOptions() calibration.FlashOptions {
+	opts := calibration.FlashOptions{
+		DryRun: os.Getenv("CALRUNRILLA_DRY_RUN") == "1",
+		Force:  os.Getenv("CALRUNRILLA_FORCE") == "1",
+	}
+	if list := os.Getenv("CALRUNRILLA_BARS"); list != "" {
+		for _, f := range strings.Split(list, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				log.Fatalf("Invalid --bars entry %q", f)
+			}
+			opts.Bars = append(opts.Bars, id)
+		}
+	}
+	return opts
+}
@@ -4,7 +4,8 @@ import "fmt"
 
 // Constants related to layout
 const (
-	MAXLCS   = 4
+	MAXLCS   = 8 // most load-cell slots a bar's frames can carry
+	DEFSLOTS = 4 // slots of bars that do not set SLOTS and use only bits 0-3
 	WIDTH    = 21
 	SHIFT    = 14
 	SHIFTIDX = 6
@@ -86,20 +87,92 @@ func (b BAY) String() string {
 	}
 }
 
+// LoadStep is where a calibration load is placed: a bay, a side of that bay
+// and a depth row. Depths is the larger cell count of the bay's two bars, so
+// every bay gets 3*Depths loads.
+type LoadStep struct {
+	Bay    BAY
+	Side   LMR
+	Depth  int // 0 is the front row
+	Depths int
+}
+
+// CalibrationLoad returns the placement of load j for bars with nlcs cells.
+// With two cells this is the classic BAY(j/6), LMR((j/2)%3), FB(j%2) order.
+func CalibrationLoad(j, nlcs int) LoadStep {
+	if nlcs < 1 {
+		nlcs = 1
+	}
+	return LoadStep{
+		Bay:    BAY(j / (3 * nlcs)),
+		Side:   LMR((j / nlcs) % 3),
+		Depth:  j % nlcs,
+		Depths: nlcs,
+	}
+}
+
+// CalibrationPlan lists every weight load for a shelf in order. Bay n spans
+// bars n and n+1 and gets 3*max(cells) loads, so shelves whose bars carry
+// different numbers of cells still get at least one load per unknown factor.
+func CalibrationPlan(bars []*BAR) []LoadStep {
+	plan := make([]LoadStep, 0)
+	for b := 0; b+1 < len(bars); b++ {
+		d := bars[b].NLCs()
+		if n := bars[b+1].NLCs(); n > d {
+			d = n
+		}
+		for k := 0; k < 3*d; k++ {
+			ld := CalibrationLoad(k, d)
+			ld.Bay = BAY(b)
+			plan = append(plan, ld)
+		}
+	}
+	return plan
+}
+
+// CellOffsets returns where each bar's cells start in a flat per-cell vector
+// (bar order, active cells in bit order) and the total number of cells.
+func CellOffsets(bars []*BAR) ([]int, int) {
+	offs := make([]int, len(bars))
+	total := 0
+	for i, b := range bars {
+		offs[i] = total
+		total += b.NLCs()
+	}
+	return offs, total
+}
+
+// DepthString names the depth row: FRONT/BACK for two rows, MIDDLE for one
+// and "ROW n OF m" (counted from the front) otherwise.
+func (s LoadStep) DepthString() string {
+	switch {
+	case s.Depths == 1:
+		return "MIDDLE"
+	case s.Depths == 2:
+		return FB(s.Depth).String()
+	default:
+		return fmt.Sprintf("ROW %d OF %d", s.Depth+1, s.Depths)
+	}
+}
+
 // Data models
 type PARAMETERS struct {
-	SERIAL  *SERIAL  `json:"SERIAL"`
-	VERSION *VERSION `json:"VERSION,omitempty"`
-	WEIGHT  int      `json:"WEIGHT"`
-	AVG     int      `json:"AVG"`
-	IGNORE  int      `json:"IGNORE,omitempty"`
-	DEBUG   bool     `json:"DEBUG"`
-	BARS    []*BAR   `json:"BARS"`
+	SERIAL   *SERIAL       `json:"SERIAL"`
+	BUSES    []*SERIAL     `json:"BUSES,omitempty"` // further buses, each with a NAME that BAR.BUS refers to
+	VERSION  *VERSION      `json:"VERSION,omitempty"`
+	VERSIONS *VERSIONRANGE `json:"VERSIONS,omitempty"` // firmware every bar may run; defaults to VERSION.ID
+	ZEROREAD *VERSIONRANGE `json:"ZEROREAD,omitempty"` // firmware known to answer the 'O' zeros read; unset means none
+	WEIGHT   int           `json:"WEIGHT"`
+	AVG      int           `json:"AVG"`
+	IGNORE   int           `json:"IGNORE,omitempty"`
+	DEBUG    bool          `json:"DEBUG"`
+	BARS     []*BAR        `json:"BARS"`
 }
 
 type SENTINEL struct {
-	SERIAL *SERIAL `json:"SERIAL"`
-	BARS   []*BAR  `json:"BARS"`
+	SERIAL *SERIAL   `json:"SERIAL"`
+	BUSES  []*SERIAL `json:"BUSES,omitempty"`
+	BARS   []*BAR    `json:"BARS"`
 }
 
 type VERSION struct {
@@ -108,16 +181,68 @@ type VERSION struct {
 	MINOR int `json:"MINOR"`
 }
 
+// VERSIONRANGE bounds the firmware the bars of a shelf may run. ID, when
+// set, must match; MIN and MAX compare MAJOR then MINOR (their ID is ignored)
+// and are inclusive. An unset bound leaves that side open.
+type VERSIONRANGE struct {
+	ID  int      `json:"ID,omitempty"`
+	MIN *VERSION `json:"MIN,omitempty"`
+	MAX *VERSION `json:"MAX,omitempty"`
+}
+
 type SERIAL struct {
-	PORT     string `json:"PORT"`
-	BAUDRATE int    `json:"BAUDRATE"`
-	COMMAND  string `json:"COMMAND"`
+	NAME     string    `json:"NAME,omitempty"` // bus name for BAR.BUS; only needed with BUSES
+	PORT     string    `json:"PORT"`
+	BAUDRATE int       `json:"BAUDRATE"`
+	COMMAND  string    `json:"COMMAND"`
+	CAPTURE  string    `json:"CAPTURE,omitempty"`  // directory for frame capture files; empty disables capture
+	DATABITS int       `json:"DATABITS,omitempty"` // 5-8; 0 means 8
+	PARITY   string    `json:"PARITY,omitempty"`   // N, E or O (M, S on Windows); empty means N
+	STOPBITS int       `json:"STOPBITS,omitempty"` // 1 or 2; 0 means 1
+	TIMEOUTS *TIMEOUTS `json:"TIMEOUTS,omitempty"`
+}
+
+// TIMEOUTS overrides reply timeouts in milliseconds per command; zero keeps
+// the built-in default.
+type TIMEOUTS struct {
+	VERSION int `json:"VERSION,omitempty"` // V
+	MEASURE int `json:"MEASURE,omitempty"` // SERIAL.COMMAND
+	READ    int `json:"READ,omitempty"`    // X/O read-back
+	WRITE   int `json:"WRITE,omitempty"`   // O/X writes and firmware blocks
+	REBOOT  int `json:"REBOOT,omitempty"`  // R
+	ENTER   int `json:"ENTER,omitempty"`   // Euler update-mode handshake, broadcast and addressed
 }
 
 type BAR struct {
-	ID  int   `json:"ID"`
-	LCS byte  `json:"LCS"`
-	LC  []*LC `json:"LC,omitempty"`
+	ID    int    `json:"ID"`
+	LCS   byte   `json:"LCS"`
+	SLOTS int    `json:"SLOTS,omitempty"` // load-cell fields per frame; 0 derives it from LCS
+	BUS   string `json:"BUS,omitempty"`   // NAME of the bus the bar is wired to; empty is SERIAL
+	LC    []*LC  `json:"LC,omitempty"`
+}
+
+// Slots returns how many load-cell fields the bar's firmware carries in
+// measure, zero and factor frames. Older bars have four; next-generation bars
+// have eight, which is assumed whenever LCS uses a bit above 3.
+func (b *BAR) Slots() int {
+	if b.SLOTS > 0 {
+		return b.SLOTS
+	}
+	if b.LCS&0xF0 != 0 {
+		return MAXLCS
+	}
+	return DEFSLOTS
+}
+
+// NLCs returns the number of active load cells in the LCS mask.
+func (b *BAR) NLCs() int {
+	n := 0
+	for i := 0; i < MAXLCS; i++ {
+		if b.LCS&(1<<i) != 0 {
+			n++
+		}
+	}
+	return n
 }
 
 type LC struct {
@@ -0,0 +1,89 @@
+package serial_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// BenchmarkPollRate polls every bar of a simulated bus in turn, the way the
+// test loop does; one op is one GetADs exchange. The pipe cases time the
+// frame path (sendCommand returning on the first CRC-complete frame); the
+// pty cases go through a real serial port, so its read poll window
+// (ReadTimeout) shows up as well. Bars answer without added latency.
+func BenchmarkPollRate(b *testing.B) {
+	for _, n := range []int{1, 6, 10} {
+		b.Run(fmt.Sprintf("pipe/bars=%d", n), func(b *testing.B) {
+			sim := simulator.New(simulator.Config{Bars: n, Seed: 1})
+			sim.Latency = 0
+			host, dev := serialpkg.NewPipe()
+			go func() { _ = sim.Serve(dev) }()
+			defer dev.Close()
+			benchPoll(b, sim, host)
+		})
+	}
+	b.Run("pty/bars=6", func(b *testing.B) {
+		sim := simulator.New(simulator.Config{Bars: 6, Seed: 1})
+		sim.Latency = 0
+		benchPoll(b, sim, ptyPort(b, sim))
+	})
+	// A bar that never answers costs its timeout plus whatever the port's
+	// poll window adds on top.
+	b.Run("pty/silent", func(b *testing.B) {
+		sim := simulator.New(simulator.Config{Bars: 1, Seed: 1})
+		l, err := serialpkg.NewLeo485WithTransport(ptyPort(b, sim), &models.SERIAL{COMMAND: "M"}, []*models.BAR{{ID: 9, LCS: 3}})
+		if err != nil {
+			b.Fatalf("NewLeo485WithTransport: %v", err)
+		}
+		defer l.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := l.GetADsWithTimeout(0, 20); err == nil {
+				b.Fatal("absent bar answered")
+			}
+		}
+	})
+}
+
+// ptyPort serves sim on a pseudo-terminal and opens it as a serial port, or
+// skips the benchmark where that is not possible.
+func ptyPort(b *testing.B, sim *simulator.Bus) serialpkg.Transport {
+	pty, err := simulator.OpenPTY()
+	if err != nil {
+		b.Skipf("no pseudo-terminal: %v", err)
+	}
+	b.Cleanup(func() { _ = pty.Close() })
+	go func() { _ = pty.Serve(sim) }()
+	port, err := serialpkg.OpenSerial(&models.SERIAL{PORT: pty.Path, BAUDRATE: 115200})
+	if err != nil {
+		b.Skipf("open %s: %v", pty.Path, err)
+	}
+	return port
+}
+
+func benchPoll(b *testing.B, sim *simulator.Bus, t serialpkg.Transport) {
+	var bars []*models.BAR
+	for _, bar := range sim.Bars() {
+		bars = append(bars, &models.BAR{ID: bar.ID, LCS: bar.LCS})
+	}
+	l, err := serialpkg.NewLeo485WithTransport(t, &models.SERIAL{COMMAND: "M"}, bars)
+	if err != nil {
+		_ = t.Close()
+		b.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	defer l.Close()
+	failures := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := l.GetADs(i % len(bars)); err != nil {
+			failures++
+		}
+	}
+	b.StopTimer()
+	if failures > 0 {
+		b.Errorf("%d of %d reads failed", failures, b.N)
+	}
+}
@@ -0,0 +1,324 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayPrefix selects the replay transport when used as SERIAL.PORT,
+// e.g. "replay://captures/leo485_20250101_120000.123_SERIAL_4711.jsonl".
+const ReplayPrefix = "replay://"
+
+// CaptureRecord is one frame of a capture file. Capture files hold one JSON
+// record per line in the order frames crossed the wire.
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Dir       string    `json:"dir"`   // "tx" (host to bar) or "rx" (bar to host)
+	BarID     int       `json:"barId"` // -1 when the frame carries no address
+	Command   string    `json:"command,omitempty"`
+	Payload   string    `json:"payload"`
+	CRCValid  bool      `json:"crcValid"`
+	LatencyMS float64   `json:"latencyMs,omitempty"` // rx only: time since the last tx
+	Hex       string    `json:"hex"`                 // the complete frame, for replay
+}
+
+// DecodeFrame splits a raw frame into address, command, payload and CRC
+// validity. dir is "tx" or "rx".
+func DecodeFrame(dir string, frame []byte) CaptureRecord {
+	rec := CaptureRecord{Dir: dir, BarID: -1, Hex: strings.ToUpper(hex.EncodeToString(frame))}
+	body := frame
+	if pos := terminatorIndex(frame); pos != -1 {
+		body = frame[:pos]
+	} else {
+		body = bytes.TrimRight(frame, "\r")
+	}
+	if dir == "tx" && string(body) == strings.TrimSuffix(Euler, "\r") {
+		rec.Command = "Euler"
+		rec.CRCValid = true
+		return rec
+	}
+	id, ok := ParseAddress(body)
+	if len(body) < 4 || !ok {
+		rec.Payload = printable(body)
+		return rec
+	}
+	data, crc := body[:len(body)-2], body[len(body)-2:]
+	rec.CRCValid = bytes.Equal(crc16(data), crc)
+	rec.BarID = id
+	rest := data[2:]
+	if dir == "tx" {
+		if strings.HasPrefix(string(rest), strings.TrimSuffix(Euler, "\r")) {
+			rec.Command = "Euler"
+			return rec
+		}
+		if len(rest) > 0 {
+			rec.Command = string(rest[:1])
+			rec.Payload = printable(rest[1:])
+		}
+		return rec
+	}
+	if len(rest) > 0 && rest[0] == '|' {
+		rec.Payload = printable(rest[1:])
+	} else {
+		rec.Payload = printable(rest)
+	}
+	return rec
+}
+
+// printable returns s unchanged when it is plain text and a hex dump otherwise.
+func printable(b []byte) string {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7E {
+			return "hex:" + strings.ToUpper(hex.EncodeToString(b))
+		}
+	}
+	return string(b)
+}
+
+// captureTransport records every frame written to and read from inner.
+type captureTransport struct {
+	inner  Transport
+	mu     sync.Mutex
+	out    io.WriteCloser
+	enc    *json.Encoder
+	rx     []byte
+	lastTx time.Time
+}
+
+// NewCaptureTransport wraps inner so each complete frame is appended to out as
+// a CaptureRecord. Received bytes are grouped into CRC-valid frames; anything
+// else (partial or unchecked replies) is flushed when the next command is
+// written.
+func NewCaptureTransport(inner Transport, out io.WriteCloser) Transport {
+	return &captureTransport{inner: inner, out: out, enc: json.NewEncoder(out)}
+}
+
+// CreateCaptureFile creates a new capture file in dir for the bus named bus.
+// The name carries the time to the millisecond, the bus and the process ID,
+// e.g. leo485_20250101_120000.123_SERIAL_4711.jsonl, and an existing file is
+// never opened or truncated: on a clash a counter is appended.
+func CreateCaptureFile(dir, bus string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("leo485_%s_%s_%d", time.Now().Format("20060102_150405.000"), fileSafe(bus), os.Getpid())
+	name := base + ".jsonl"
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, fs.ErrExist) || n > 100 {
+			return f, err
+		}
+		name = fmt.Sprintf("%s_%d.jsonl", base, n)
+	}
+}
+
+// fileSafe replaces the characters of s that do not belong in a file name.
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func (c *captureTransport) record(dir string, frame []byte) {
+	rec := DecodeFrame(dir, frame)
+	rec.Time = time.Now()
+	if dir == "rx" && !c.lastTx.IsZero() {
+		rec.LatencyMS = float64(rec.Time.Sub(c.lastTx).Microseconds()) / 1000
+	}
+	_ = c.enc.Encode(rec)
+}
+
+func (c *captureTransport) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if len(c.rx) > 0 {
+		c.record("rx", c.rx)
+		c.rx = nil
+	}
+	c.record("tx", p)
+	c.lastTx = time.Now()
+	c.mu.Unlock()
+	return c.inner.Write(p)
+}
+
+func (c *captureTransport) Read(p []byte) (int, error) {
+	n, err := c.inner.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.rx = append(c.rx, p[:n]...)
+		// Cut at line ends that close a CRC-valid frame; the CRC may itself
+		// contain LF. Anything else is flushed by the next Write or Close.
+		for i := 0; i < len(c.rx); i++ {
+			if c.rx[i] == '\n' && frameComplete(c.rx[:i+1]) {
+				c.record("rx", c.rx[:i+1])
+				c.rx = c.rx[i+1:]
+				i = -1
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *captureTransport) SetReadDeadline(t time.Time) error { return c.inner.SetReadDeadline(t) }
+
+func (c *captureTransport) Close() error {
+	c.mu.Lock()
+	if len(c.rx) > 0 {
+		c.record("rx", c.rx)
+		c.rx = nil
+	}
+	c.mu.Unlock()
+	_ = c.out.Close()
+	return c.inner.Close()
+}
+
+// ReadCapture loads all records of a capture file.
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	out := make([]CaptureRecord, 0)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec CaptureRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return out, fmt.Errorf("line %d: %w", len(out)+1, err)
+		}
+		out = append(out, rec)
+	}
+	return out, sc.Err()
+}
+
+// FormatRecord renders one record as a human-readable line. start is the
+// time of the first record, used for relative timestamps. Binary payloads of
+// whole 4-byte words are also shown as big-endian float32 values.
+func FormatRecord(rec CaptureRecord, start time.Time) string {
+	arrow := "->"
+	if rec.Dir == "rx" {
+		arrow = "<-"
+	}
+	bar := "--"
+	if rec.BarID >= 0 {
+		bar = fmt.Sprintf("%02d", rec.BarID)
+	}
+	crc := "ok "
+	if !rec.CRCValid {
+		crc = "BAD"
+	}
+	line := fmt.Sprintf("%10.3f %s bar=%s crc=%s", rec.Time.Sub(start).Seconds(), arrow, bar, crc)
+	if rec.Dir == "rx" {
+		line += fmt.Sprintf(" %7.1fms", rec.LatencyMS)
+	}
+	if rec.Command != "" {
+		line += " cmd=" + rec.Command
+	}
+	if rec.Payload != "" {
+		line += " " + rec.Payload
+	}
+	if strings.HasPrefix(rec.Payload, "hex:") {
+		raw, err := hex.DecodeString(strings.TrimPrefix(rec.Payload, "hex:"))
+		if err == nil && len(raw) > 0 && len(raw)%4 == 0 {
+			vals := make([]string, 0, len(raw)/4)
+			for i := 0; i < len(raw); i += 4 {
+				f := math.Float32frombits(binary.BigEndian.Uint32(raw[i : i+4]))
+				vals = append(vals, fmt.Sprintf("%g", f))
+			}
+			line += " float32=[" + strings.Join(vals, " ") + "]"
+		}
+	}
+	return line
+}
+
+// replayTransport answers written commands with the replies recorded after
+// the matching command in a capture file.
+type replayTransport struct {
+	mu      sync.Mutex
+	records []CaptureRecord
+	cursor  int
+	pending []byte
+	ddl     time.Time
+}
+
+// OpenReplay loads a capture file as a Transport. Each write is matched
+// against the next recorded tx frame with identical bytes (skipping unmatched
+// records); the rx frames that followed it become readable. A write that
+// matches no later tx frame fails.
+func OpenReplay(path string) (Transport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	recs, err := ReadCapture(f)
+	if err != nil {
+		return nil, err
+	}
+	return &replayTransport{records: recs}, nil
+}
+
+func (r *replayTransport) Write(p []byte) (int, error) {
+	want := strings.ToUpper(hex.EncodeToString(p))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := r.cursor; i < len(r.records); i++ {
+		rec := r.records[i]
+		if rec.Dir != "tx" || rec.Hex != want {
+			continue
+		}
+		r.cursor = i + 1
+		for r.cursor < len(r.records) && r.records[r.cursor].Dir == "rx" {
+			if b, err := hex.DecodeString(r.records[r.cursor].Hex); err == nil {
+				r.pending = append(r.pending, b...)
+			}
+			r.cursor++
+		}
+		return len(p), nil
+	}
+	return 0, fmt.Errorf("replay: frame %q not in capture after record %d", strings.TrimRight(string(p), "\r"), r.cursor)
+}
+
+func (r *replayTransport) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	ddl := r.ddl
+	r.mu.Unlock()
+	if n == 0 {
+		wait := 10 * time.Millisecond
+		if !ddl.IsZero() && time.Until(ddl) < wait {
+			wait = time.Until(ddl)
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, nil
+}
+
+func (r *replayTransport) SetReadDeadline(t time.Time) error {
+	r.mu.Lock()
+	r.ddl = t
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *replayTransport) Close() error { return nil }
@@ -0,0 +1,110 @@
+package serial_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+func TestCreateCaptureFileUnique(t *testing.T) {
+	dir := t.TempDir()
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		f, err := serialpkg.CreateCaptureFile(dir, "left bus")
+		if err != nil {
+			t.Fatalf("CreateCaptureFile: %v", err)
+		}
+		_ = f.Close()
+		name := filepath.Base(f.Name())
+		if seen[name] {
+			t.Fatalf("capture file %s created twice", name)
+		}
+		seen[name] = true
+		if !strings.Contains(name, "_left_bus_") {
+			t.Errorf("capture file %s does not name the bus", name)
+		}
+	}
+}
+
+func TestCaptureReplayRoundTrip(t *testing.T) {
+	bars := []*models.BAR{{ID: 1, LCS: 3}, {ID: 2, LCS: 15}}
+	ser := &models.SERIAL{COMMAND: "M"}
+	sim := simulator.NewFromParameters(&models.PARAMETERS{BARS: bars}, 1)
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	defer dev.Close()
+
+	f, err := serialpkg.CreateCaptureFile(t.TempDir(), "SERIAL")
+	if err != nil {
+		t.Fatalf("CreateCaptureFile: %v", err)
+	}
+	live, err := serialpkg.NewLeo485WithTransport(serialpkg.NewCaptureTransport(host, f), ser, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	type reading struct {
+		version string
+		ads     []uint64
+		factors []float64
+	}
+	session := func(l *serialpkg.Leo485) []reading {
+		var out []reading
+		for i := range bars {
+			id, major, minor, err := l.GetVersion(i)
+			if err != nil {
+				t.Fatalf("bar %d: GetVersion: %v", i+1, err)
+			}
+			ads, err := l.GetADs(i)
+			if err != nil {
+				t.Fatalf("bar %d: GetADs: %v", i+1, err)
+			}
+			factors, err := l.ReadFactors(i)
+			if err != nil {
+				t.Fatalf("bar %d: ReadFactors: %v", i+1, err)
+			}
+			v := serialpkg.FormatVersion(models.VERSION{ID: id, MAJOR: major, MINOR: minor})
+			out = append(out, reading{v, ads, factors})
+		}
+		return out
+	}
+	want := session(live)
+	_ = live.Close()
+
+	replay, err := serialpkg.OpenTransport(&models.SERIAL{PORT: serialpkg.ReplayPrefix + f.Name(), COMMAND: "M"})
+	if err != nil {
+		t.Fatalf("OpenTransport replay: %v", err)
+	}
+	l, err := serialpkg.NewLeo485WithTransport(replay, ser, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	defer l.Close()
+	got := session(l)
+	for i := range want {
+		if got[i].version != want[i].version {
+			t.Errorf("bar %d: replayed version %s, captured %s", i+1, got[i].version, want[i].version)
+		}
+		for k := range want[i].ads {
+			if got[i].ads[k] != want[i].ads[k] {
+				t.Errorf("bar %d LC %d: replayed ADC %d, captured %d", i+1, k+1, got[i].ads[k], want[i].ads[k])
+			}
+		}
+		for k := range want[i].factors {
+			if got[i].factors[k] != want[i].factors[k] {
+				t.Errorf("bar %d LC %d: replayed factor %v, captured %v", i+1, k+1, got[i].factors[k], want[i].factors[k])
+			}
+		}
+	}
+
+	// The capture holds no reboot, so replaying one must fail.
+	if l.Reboot(0) {
+		t.Error("Reboot acknowledged by a capture that never recorded it")
+	}
+	if _, err := replay.Write(serialpkg.GetCommand(1, []byte("R"))); err == nil {
+		t.Error("replay accepted a frame it cannot match")
+	}
+}
@@ -1,17 +1,77 @@
 package serial
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
-	goserial "github.com/tarm/serial"
+	"github.com/CK6170/Calrunrilla-go/models"
 )
 
+// MaxBarID is the highest bar address the bus can carry. Every frame starts
+// with a two-digit ASCII address ("00".."99"); IDs 0-9 keep the original
+// "0<digit>" encoding. IDs 10 and up need firmware that matches both digits.
+// Replies are checked against both digits too, so a bar that only looks at
+// the second one shows up as a wrong-address error, never as another bar.
+const MaxBarID = 99
+
+// EulerBarID is the address the broadcast Euler sequence starts with
+// ("27182818..."). A frame to bar 27 opens with the same two bytes, and a
+// bar cannot tell the two apart until the rest of the frame has arrived, so
+// the ID is never used.
+const EulerBarID = 27
+
+// ValidateBarID reports whether id fits the two-digit address field and is
+// not EulerBarID.
+func ValidateBarID(id int) error {
+	if id < 0 || id > MaxBarID {
+		return fmt.Errorf("bar ID %d out of range 0..%d", id, MaxBarID)
+	}
+	if id == EulerBarID {
+		return fmt.Errorf("bar ID %d is reserved: its frames start like the Euler broadcast", id)
+	}
+	return nil
+}
+
+// ValidateBars checks every bar ID is encodable and used only once, and that
+// each LCS mask fits the bar's load-cell slots.
+func ValidateBars(bars []*models.BAR) error {
+	seen := make(map[int]bool, len(bars))
+	for i, b := range bars {
+		if b == nil {
+			return fmt.Errorf("bar %d: missing", i+1)
+		}
+		if err := ValidateBarID(b.ID); err != nil {
+			return fmt.Errorf("bar %d: %w", i+1, err)
+		}
+		if seen[b.ID] {
+			return fmt.Errorf("bar %d: duplicate bar ID %d", i+1, b.ID)
+		}
+		if b.SLOTS < 0 || b.SLOTS > models.MAXLCS {
+			return fmt.Errorf("bar %d: SLOTS %d out of range 0..%d (0 derives it from LCS)", i+1, b.SLOTS, models.MAXLCS)
+		}
+		if int(b.LCS)>>b.Slots() != 0 {
+			return fmt.Errorf("bar %d: LCS %d uses slots beyond %d", i+1, b.LCS, b.Slots())
+		}
+		seen[b.ID] = true
+	}
+	return nil
+}
+
+// ParseAddress decodes the two-digit address at the start of a frame.
+func ParseAddress(frame []byte) (int, bool) {
+	if len(frame) < 2 || frame[0] < '0' || frame[0] > '9' || frame[1] < '0' || frame[1] > '9' {
+		return 0, false
+	}
+	return int(frame[0]-'0')*10 + int(frame[1]-'0'), true
+}
+
 func GetCommand(id int, command []byte) []byte {
-	cmd := []byte{'0', byte(id + '0')}
+	cmd := []byte(fmt.Sprintf("%02d", id))
 	cmd = append(cmd, command...)
 	cs := crc16(cmd)
 	cmd = append(cmd, cs...)
@@ -36,43 +96,100 @@ func crc16(data []byte) []byte {
 	return buf
 }
 
-func sendCommand(sp *goserial.Port, cmd []byte, timeout int) ([]byte, error) {
-	if _, err := sp.Write(cmd); err != nil {
+// sendCommand writes cmd and returns the reply. Addressed commands return as
+// soon as a complete frame with a valid CRC has arrived; broadcasts (the bare
+// Euler handshake) return at the first line end. timeout (ms) only bounds how
+// long a missing or broken reply is waited for.
+func sendCommand(t Transport, cmd []byte, timeout int) ([]byte, error) {
+	id := commandBarID(cmd)
+	start := time.Now()
+	if _, err := t.Write(cmd); err != nil {
+		if st := linkStatsOf(t); st != nil && id >= 0 {
+			st.record(id, time.Since(start), err)
+		}
 		return nil, err
 	}
-	time.Sleep(time.Millisecond * time.Duration(timeout/2))
-	return readUntil(sp, timeout)
+	var (
+		data []byte
+		err  error
+	)
+	if id >= 0 {
+		data, err = readFrame(t, timeout)
+	} else {
+		data, err = readUntil(t, timeout)
+	}
+	var pe *ProtocolError
+	if errors.As(err, &pe) && pe.BarID < 0 {
+		pe.BarID = id
+	}
+	if st := linkStatsOf(t); st != nil && id >= 0 {
+		st.record(id, time.Since(start), replyError(data, cmd, err))
+	}
+	return data, err
+}
+
+// readUntil reads until the first line end or until timeout (ms) expires.
+func readUntil(t Transport, timeout int) ([]byte, error) {
+	return readWhile(t, timeout, func(buf []byte) bool {
+		return bytes.IndexByte(buf, '\n') >= 0
+	})
+}
+
+// readFrame reads until buf holds a complete reply frame (see frameComplete).
+// At the deadline, a buffer that at least contains a line end is returned
+// without error so the caller's validation reports the precise failure (CRC,
+// address, format) instead of a timeout.
+func readFrame(t Transport, timeout int) ([]byte, error) {
+	buf, err := readWhile(t, timeout, frameComplete)
+	if errors.Is(err, ErrTimeout) && bytes.IndexByte(buf, '\n') >= 0 {
+		return buf, nil
+	}
+	return buf, err
 }
 
-func readUntil(sp *goserial.Port, timeout int) ([]byte, error) {
+// frameComplete reports whether buf ends with a line terminator preceded by a
+// valid CRC of everything before it. Binary payloads may contain LF bytes, so
+// a line end alone does not mark the end of a frame.
+func frameComplete(buf []byte) bool {
+	if len(buf) < 5 || buf[len(buf)-1] != '\n' {
+		return false
+	}
+	end := len(buf) - 1
+	if buf[end-1] == '\r' {
+		end--
+	}
+	if end < 4 {
+		return false
+	}
+	return bytes.Equal(crc16(buf[:end-2]), buf[end-2:end])
+}
+
+// readWhile accumulates bytes until done reports a complete reply or timeout
+// (ms) expires. Transports block in Read for at most a short poll window, so
+// the loop returns as soon as the reply is in.
+func readWhile(t Transport, timeout int, done func([]byte) bool) ([]byte, error) {
 	deadline := time.Now().Add(time.Millisecond * time.Duration(timeout))
-	buf := make([]byte, 0, 1024)
+	buf := make([]byte, 0, 256)
 	tmp := make([]byte, 256)
+	_ = t.SetReadDeadline(deadline)
 	for time.Now().Before(deadline) {
-		n, err := sp.Read(tmp)
+		n, err := t.Read(tmp)
 		if n > 0 {
 			buf = append(buf, tmp[:n]...)
-			s := string(buf)
-			if stringsContainsNewline(s) {
+			if done(buf) {
 				return buf, nil
 			}
 		}
 		if err != nil {
 			return buf, err
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
-	hexParts := make([]string, 0, len(buf))
-	for _, b := range buf {
-		hexParts = append(hexParts, fmt.Sprintf("%02X", b))
-	}
-	hexDump := stringsJoin(hexParts, " ")
-	return buf, fmt.Errorf("read timeout; got %d bytes; raw_hex=%s", len(buf), hexDump)
+	return buf, &ProtocolError{Kind: ErrTimeout, BarID: -1, Frame: buf}
 }
 
 // Small wrappers used by higher-level code
-func getData(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
-	data, err := sendCommand(sp, cmd, timeout)
+func getData(t Transport, cmd []byte, timeout int) (string, error) {
+	data, err := sendCommand(t, cmd, timeout)
 	if err != nil {
 		return "", err
 	}
@@ -80,16 +197,16 @@ func getData(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
 	return result, err
 }
 
-func updateValue(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
-	data, err := sendCommand(sp, cmd, timeout)
+func updateValue(t Transport, cmd []byte, timeout int) (string, error) {
+	data, err := sendCommand(t, cmd, timeout)
 	if err != nil {
 		return "", err
 	}
 	return string(data), nil
 }
 
-func changeState(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
-	data, err := sendCommand(sp, cmd, timeout)
+func changeState(t Transport, cmd []byte, timeout int) (string, error) {
+	data, err := sendCommand(t, cmd, timeout)
 	if err != nil {
 		return "", err
 	}
@@ -97,7 +214,7 @@ func changeState(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
 }
 
 // parseValues and checkData are helpers that inspect returned payloads
-func parseValues(input []byte, cmd []byte, lcs byte) ([]struct {
+func parseValues(input []byte, cmd []byte, bar *models.BAR) ([]struct {
 	lc   int
 	brut uint64
 }, error) {
@@ -110,14 +227,21 @@ func parseValues(input []byte, cmd []byte, lcs byte) ([]struct {
 		lc   int
 		brut uint64
 	}{}
-	for i, in := range inputs {
-		if (lcs & (1 << i)) != 0 {
-			brut, _ := strconvParseUint(in, 10, 64)
-			vals = append(vals, struct {
-				lc   int
-				brut uint64
-			}{i, brut})
+	for i := 0; i < bar.Slots(); i++ {
+		if (bar.LCS & (1 << i)) == 0 {
+			continue
+		}
+		if i >= len(inputs) {
+			return nil, protoErr(ErrMalformed, cmd, input, fmt.Sprintf("missing value for LC %d", i))
 		}
+		brut, err := strconvParseUint(strings.TrimSpace(inputs[i]), 10, 64)
+		if err != nil {
+			return nil, protoErr(ErrMalformed, cmd, input, fmt.Sprintf("LC %d value %q", i, inputs[i]))
+		}
+		vals = append(vals, struct {
+			lc   int
+			brut uint64
+		}{i, brut})
 	}
 	return vals, nil
 }
@@ -125,62 +249,77 @@ func parseValues(input []byte, cmd []byte, lcs byte) ([]struct {
 func checkData(input []byte, cmd []byte) (string, error) {
 	sinput := string(input)
 	if len(sinput) < 5 {
-		return "", fmt.Errorf("short response")
-	}
-	if len(sinput) <= 2 || sinput[:2] != string(cmd[:2]) || sinput[2] != '|' {
-		return "", fmt.Errorf("wrong ID or missing pipe")
+		return "", protoErr(ErrMalformed, cmd, input, "short response")
 	}
-	rnPos := stringsIndex(sinput, "\r\n")
-	if rnPos == -1 {
-		rnPos = stringsIndex(sinput, "\n")
+	if sinput[:2] != string(cmd[:2]) {
+		return "", protoErr(ErrWrongAddress, cmd, input, fmt.Sprintf("reply from %q", sinput[:2]))
 	}
-	if rnPos == -1 {
-		return "", fmt.Errorf("wrong format")
+	if sinput[2] != '|' {
+		return "", protoErr(ErrMalformed, cmd, input, "missing pipe")
 	}
-	if rnPos < 2 {
-		return "", fmt.Errorf("wrong format")
+	// The CRC itself may contain CR or LF bytes, so the terminator is the
+	// last line end, not the first.
+	rnPos := terminatorIndex(input)
+	if rnPos < 5 {
+		return "", protoErr(ErrMalformed, cmd, input, "wrong format")
 	}
 	receivedCRC := input[rnPos-2 : rnPos]
 	dataForCRC := input[:rnPos-2]
 	calculatedCRC := crc16(dataForCRC)
 	if receivedCRC[0] != calculatedCRC[0] || receivedCRC[1] != calculatedCRC[1] {
-		return "", fmt.Errorf("wrong checksum")
+		return "", protoErr(ErrCRC, cmd, input, fmt.Sprintf("expected=%02X%02X got=%02X%02X", calculatedCRC[0], calculatedCRC[1], receivedCRC[0], receivedCRC[1]))
 	}
 	result := sinput[3 : rnPos-2]
+	if isNAK(result) {
+		return "", protoErr(ErrNAK, cmd, input, result)
+	}
 	return result, nil
 }
 
-// Small wrappers to avoid importing strings/strconv repeatedly in this file
-func stringsContainsNewline(s string) bool {
-	return stringsIndex(s, "\r\n") != -1 || stringsIndex(s, "\n") != -1
+// terminatorIndex returns the position of the trailing CRLF (or bare LF) of
+// a frame, or -1 when there is none.
+func terminatorIndex(frame []byte) int {
+	if pos := bytes.LastIndex(frame, []byte("\r\n")); pos != -1 {
+		return pos
+	}
+	return bytes.LastIndexByte(frame, '\n')
 }
 
-func stringsJoin(a []string, sep string) string { return strings.Join(a, sep) }
-func stringsSplit(s, sep string) []string       { return strings.Split(s, sep) }
-func stringsIndex(s, sep string) int            { return strings.Index(s, sep) }
+// isNAK reports whether a validated payload is a refusal rather than data.
+func isNAK(payload string) bool {
+	p := strings.TrimSpace(payload)
+	return p == "ERR" || strings.HasPrefix(p, "ERR ") || p == "NAK"
+}
+
+// Small wrappers to avoid importing strings/strconv repeatedly in this file
+func stringsSplit(s, sep string) []string { return strings.Split(s, sep) }
 func strconvParseUint(s string, base int, bitSize int) (uint64, error) {
 	return strconv.ParseUint(s, base, bitSize)
 }
 
 // Exported wrappers so callers from other packages (main) can use these helpers.
-func ChangeState(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
-	return changeState(sp, cmd, timeout)
+func CRC16(data []byte) []byte {
+	return crc16(data)
+}
+
+func ChangeState(t Transport, cmd []byte, timeout int) (string, error) {
+	return changeState(t, cmd, timeout)
 }
 
-func UpdateValue(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
-	return updateValue(sp, cmd, timeout)
+func UpdateValue(t Transport, cmd []byte, timeout int) (string, error) {
+	return updateValue(t, cmd, timeout)
 }
 
-func GetData(sp *goserial.Port, cmd []byte, timeout int) (string, error) {
-	return getData(sp, cmd, timeout)
+func GetData(t Transport, cmd []byte, timeout int) (string, error) {
+	return getData(t, cmd, timeout)
 }
 
-func SendCommand(sp *goserial.Port, cmd []byte, timeout int) ([]byte, error) {
-	return sendCommand(sp, cmd, timeout)
+func SendCommand(t Transport, cmd []byte, timeout int) ([]byte, error) {
+	return sendCommand(t, cmd, timeout)
 }
 
 // ReadUntil exposes the internal readUntil helper for callers that need the
 // raw byte buffer instead of the parsed string.
-func ReadUntil(sp *goserial.Port, timeout int) ([]byte, error) {
-	return readUntil(sp, timeout)
+func ReadUntil(t Transport, timeout int) ([]byte, error) {
+	return readUntil(t, timeout)
 }
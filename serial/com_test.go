@@ -0,0 +1,102 @@
+package serial
+
+import (
+	"errors"
+	"testing"
+)
+
+// reply frames payload from addr the way a bar answers: "ID|payload" + CRC + CRLF.
+func reply(addr, payload string) []byte {
+	out := []byte(addr + "|" + payload)
+	out = append(out, crc16(out)...)
+	return append(out, '\r', '\n')
+}
+
+func TestGetDataErrors(t *testing.T) {
+	corrupt := reply("01", "Leo485 Version 12009.1.202")
+	corrupt[len(corrupt)-3] ^= 0xFF
+
+	tests := []struct {
+		name  string
+		reply []byte // queued on the bus before the command; nil for silence
+		kind  error
+		class string
+	}{
+		{"ok", reply("01", "OK"), nil, ""},
+		{"crc", corrupt, ErrCRC, "crc"},
+		{"wrong address", reply("02", "OK"), ErrWrongAddress, "address"},
+		{"nak", reply("01", "ERR"), ErrNAK, "nak"},
+		{"timeout", nil, ErrTimeout, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, dev := NewPipe()
+			defer host.Close()
+			if tt.reply != nil {
+				_, _ = dev.Write(tt.reply)
+			}
+			_, err := getData(host, GetCommand(1, []byte("V")), 50)
+			if tt.kind == nil {
+				if err != nil {
+					t.Fatalf("getData: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("getData error %v, want %v", err, tt.kind)
+			}
+			if got := ErrorClass(err); got != tt.class {
+				t.Errorf("ErrorClass = %q, want %q", got, tt.class)
+			}
+			var pe *ProtocolError
+			if !errors.As(err, &pe) || pe.BarID != 1 {
+				t.Errorf("error %v does not name bar 1", err)
+			}
+		})
+	}
+}
+
+func TestCheckDataMalformed(t *testing.T) {
+	cmd := GetCommand(1, []byte("V"))
+	for _, in := range [][]byte{[]byte("01|"), []byte("01OK\r\n\r\n")} {
+		if _, err := checkData(in, cmd); !errors.Is(err, ErrMalformed) {
+			t.Errorf("checkData(%q) = %v, want ErrMalformed", in, err)
+		}
+	}
+}
+
+func TestSendCommandReturnsOnCompleteFrame(t *testing.T) {
+	host, dev := NewPipe()
+	defer host.Close()
+	want := reply("07", "OK")
+	_, _ = dev.Write(want)
+	data, err := sendCommand(host, GetCommand(7, []byte("V")), 5000)
+	if err != nil {
+		t.Fatalf("sendCommand: %v", err)
+	}
+	if string(data) != string(want) {
+		t.Errorf("sendCommand = %q, want %q", data, want)
+	}
+}
+
+func TestValidateBarID(t *testing.T) {
+	for _, id := range []int{0, 9, 10, 26, 28, MaxBarID} {
+		if err := ValidateBarID(id); err != nil {
+			t.Errorf("ValidateBarID(%d) = %v", id, err)
+		}
+	}
+	for _, id := range []int{-1, EulerBarID, MaxBarID + 1} {
+		if err := ValidateBarID(id); err == nil {
+			t.Errorf("ValidateBarID(%d) accepted", id)
+		}
+	}
+	ids, err := DiscoverIDs(30)
+	if err != nil {
+		t.Fatalf("DiscoverIDs: %v", err)
+	}
+	for _, id := range ids {
+		if id == EulerBarID {
+			t.Errorf("DiscoverIDs scans reserved ID %d", id)
+		}
+	}
+}
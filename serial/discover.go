@@ -0,0 +1,117 @@
+package serial
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+// DiscoveredBar is a bar that answered the version query during Discover.
+type DiscoveredBar struct {
+	ID      int            `json:"id"`
+	LCS     byte           `json:"lcs"`
+	Slots   int            `json:"slots"` // measure fields the firmware reports
+	Version models.VERSION `json:"version"`
+	ADCs    []uint64       `json:"adcs"` // raw measure fields, one per slot
+}
+
+// DefaultDiscoverIDs returns the bar addresses Discover scans by default.
+func DefaultDiscoverIDs() []int {
+	ids, _ := DiscoverIDs(9)
+	return ids
+}
+
+// DiscoverIDs returns the usable addresses 0..maxID (all but EulerBarID) for
+// a Discover scan. Scanning all 100 addresses takes a while, so callers ask
+// for more only when needed.
+func DiscoverIDs(maxID int) ([]int, error) {
+	if maxID < 0 || maxID > MaxBarID {
+		return nil, fmt.Errorf("highest bar ID %d out of range 0..%d", maxID, MaxBarID)
+	}
+	ids := make([]int, 0, maxID+1)
+	for id := 0; id <= maxID; id++ {
+		if ValidateBarID(id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Discover queries every address in ids with V. Each responder is sent the
+// measure command; its slot count is the number of '|'-separated channels in
+// the reply and its active load-cell mask is inferred from which channels
+// report a non-zero ADC value. Replies are waited for as long as ser.TIMEOUTS
+// allows.
+func Discover(t Transport, ser *models.SERIAL, ids []int) ([]DiscoveredBar, error) {
+	command := ser.COMMAND
+	if command == "" {
+		return nil, fmt.Errorf("missing SERIAL.COMMAND")
+	}
+	to := TimeoutsFor(ser)
+	found := make([]DiscoveredBar, 0)
+	for _, id := range ids {
+		resp, err := getData(t, GetCommand(id, []byte("V")), to.Version)
+		if err != nil {
+			continue
+		}
+		vid, major, minor, err := parseVersion(resp)
+		if err != nil {
+			continue
+		}
+		bar := DiscoveredBar{ID: id, Version: models.VERSION{ID: vid, MAJOR: major, MINOR: minor}}
+		data, err := getData(t, GetCommand(id, []byte(command)), to.Measure)
+		if err != nil {
+			return found, fmt.Errorf("bar %d answered V but not %q: %v", id, command, err)
+		}
+		for i, field := range stringsSplit(data, "|") {
+			v, _ := strconv.ParseUint(field, 10, 64)
+			bar.ADCs = append(bar.ADCs, v)
+			if v != 0 && i < models.MAXLCS {
+				bar.LCS |= 1 << i
+			}
+		}
+		bar.Slots = len(bar.ADCs)
+		if bar.Slots > models.MAXLCS {
+			bar.Slots = models.MAXLCS
+		}
+		found = append(found, bar)
+	}
+	return found, nil
+}
+
+// DiscoverParameters opens ser, runs Discover over ids and returns a config
+// with SERIAL, VERSION (from the first bar) and BARS filled in. WEIGHT, AVG
+// and IGNORE are left for the caller.
+func DiscoverParameters(ser *models.SERIAL, ids []int) (*models.PARAMETERS, []DiscoveredBar, error) {
+	t, err := OpenTransport(ser)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = t.Close() }()
+	if len(ids) == 0 {
+		ids = DefaultDiscoverIDs()
+	}
+	found, err := Discover(t, ser, ids)
+	if err != nil {
+		return nil, found, err
+	}
+	if len(found) == 0 {
+		return nil, found, fmt.Errorf("no bars answered on %s", ser.PORT)
+	}
+	serCopy := *ser
+	p := &models.PARAMETERS{SERIAL: &serCopy}
+	ver := found[0].Version
+	p.VERSION = &ver
+	for _, b := range found {
+		if b.LCS == 0 {
+			return nil, found, fmt.Errorf("bar %d reports no active load cells", b.ID)
+		}
+		bar := &models.BAR{ID: b.ID, LCS: b.LCS}
+		if b.Slots != bar.Slots() {
+			bar.SLOTS = b.Slots
+		}
+		p.BARS = append(p.BARS, bar)
+	}
+	return p, found, nil
+}
@@ -0,0 +1,116 @@
+package serial_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// discoverShelf is a simulated bus with bars at IDs 1 (two cells), 3 (five
+// cells on an eight-slot frame) and 4 (three cells on an eight-slot frame
+// its LCS does not imply); 0 and 2 are silent.
+func discoverShelf() *simulator.Bus {
+	sim := simulator.New(simulator.Config{Seed: 1})
+	sim.AddBar(1, 0x03, 0, 20, 0.01, simulator.DefaultVersion)
+	sim.AddBar(3, 0x1F, 0, 20, 0.01, simulator.DefaultVersion)
+	sim.AddBar(4, 0x0B, 8, 20, 0.01, simulator.DefaultVersion)
+	return sim
+}
+
+func TestDiscover(t *testing.T) {
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = discoverShelf().Serve(dev) }()
+	defer host.Close()
+	defer dev.Close()
+
+	ser := &models.SERIAL{COMMAND: "M", TIMEOUTS: &models.TIMEOUTS{VERSION: 50}}
+	found, err := serialpkg.Discover(host, ser, []int{0, 1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	want := []struct {
+		id    int
+		lcs   byte
+		slots int
+	}{{1, 0x03, 4}, {3, 0x1F, 8}, {4, 0x0B, 8}}
+	if len(found) != len(want) {
+		t.Fatalf("found %+v, want IDs 1, 3 and 4", found)
+	}
+	for i, w := range want {
+		b := found[i]
+		if b.ID != w.id || b.LCS != w.lcs || b.Slots != w.slots || len(b.ADCs) != w.slots {
+			t.Errorf("bar %d: ID %d LCS %#x slots %d with %d ADCs, want ID %d LCS %#x slots %d",
+				i+1, b.ID, b.LCS, b.Slots, len(b.ADCs), w.id, w.lcs, w.slots)
+		}
+		if b.Version != simulator.DefaultVersion {
+			t.Errorf("bar ID %d version %+v", b.ID, b.Version)
+		}
+	}
+}
+
+func TestDiscoverParameters(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	sim := discoverShelf()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = sim.Serve(conn)
+	}()
+
+	ser := &models.SERIAL{PORT: serialpkg.TCPPrefix + ln.Addr().String(), COMMAND: "M", TIMEOUTS: &models.TIMEOUTS{VERSION: 50}}
+	p, found, err := serialpkg.DiscoverParameters(ser, []int{0, 1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("DiscoverParameters: %v", err)
+	}
+	if len(found) != 3 {
+		t.Errorf("found %d bars, want 3", len(found))
+	}
+	if p.SERIAL == ser || !reflect.DeepEqual(*p.SERIAL, *ser) {
+		t.Errorf("SERIAL %+v, want a copy of %+v", p.SERIAL, ser)
+	}
+	if p.VERSION == nil || *p.VERSION != simulator.DefaultVersion {
+		t.Errorf("VERSION %+v, want %+v", p.VERSION, simulator.DefaultVersion)
+	}
+	// SLOTS is only written where LCS alone would give the wrong count.
+	want := []models.BAR{{ID: 1, LCS: 0x03}, {ID: 3, LCS: 0x1F}, {ID: 4, LCS: 0x0B, SLOTS: 8}}
+	if len(p.BARS) != len(want) {
+		t.Fatalf("%d BARS, want %d", len(p.BARS), len(want))
+	}
+	for i, w := range want {
+		if b := p.BARS[i]; b.ID != w.ID || b.LCS != w.LCS || b.SLOTS != w.SLOTS {
+			t.Errorf("BARS[%d] = ID %d LCS %#x SLOTS %d, want ID %d LCS %#x SLOTS %d", i, b.ID, b.LCS, b.SLOTS, w.ID, w.LCS, w.SLOTS)
+		}
+	}
+}
+
+func TestDiscoverParametersNoBars(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = discoverShelf().Serve(conn)
+	}()
+
+	ser := &models.SERIAL{PORT: serialpkg.TCPPrefix + ln.Addr().String(), COMMAND: "M", TIMEOUTS: &models.TIMEOUTS{VERSION: 50}}
+	if p, found, err := serialpkg.DiscoverParameters(ser, []int{0, 2}); err == nil || p != nil || len(found) != 0 {
+		t.Errorf("silent addresses gave %+v %+v %v", p, found, err)
+	}
+}
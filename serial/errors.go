@@ -0,0 +1,100 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Failure classes reported by the Leo485 helpers. Match them with errors.Is;
+// the concrete error is a *ProtocolError carrying the raw frame.
+var (
+	ErrTimeout      = errors.New("read timeout")
+	ErrCRC          = errors.New("wrong checksum")
+	ErrWrongAddress = errors.New("wrong address")
+	ErrMalformed    = errors.New("malformed payload")
+	ErrNAK          = errors.New("command rejected")
+)
+
+// ProtocolError describes a failed exchange with one bar.
+type ProtocolError struct {
+	Kind   error  // one of the Err* sentinels above
+	BarID  int    // bar address the command was sent to, -1 if unknown
+	Frame  []byte // raw bytes received (possibly partial)
+	Detail string // extra context, may be empty
+}
+
+func (e *ProtocolError) Error() string {
+	var sb strings.Builder
+	if e.BarID >= 0 {
+		fmt.Fprintf(&sb, "bar %d: ", e.BarID)
+	}
+	sb.WriteString(e.Kind.Error())
+	if e.Detail != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Detail)
+	}
+	fmt.Fprintf(&sb, "; got %d bytes; raw_hex=%s", len(e.Frame), hexDump(e.Frame))
+	return sb.String()
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Kind }
+
+// ErrorClass returns a short stable name for err's failure class: "timeout",
+// "crc", "address", "malformed", "nak", or "io" for any other error. It
+// returns "" for nil.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrCRC):
+		return "crc"
+	case errors.Is(err, ErrWrongAddress):
+		return "address"
+	case errors.Is(err, ErrMalformed):
+		return "malformed"
+	case errors.Is(err, ErrNAK):
+		return "nak"
+	default:
+		return "io"
+	}
+}
+
+// IsLinkLost reports whether err means the link itself failed (USB adapter
+// unplugged, gateway connection dropped) rather than a bar answering badly or
+// not at all. Requests to a closed Leo485, and requests that panicked, are
+// not a lost link.
+func IsLinkLost(err error) bool {
+	if err == nil || errors.Is(err, ErrBusClosed) || errors.Is(err, ErrRequestPanicked) {
+		return false
+	}
+	var pe *ProtocolError
+	return !errors.As(err, &pe)
+}
+
+func protoErr(kind error, cmd, frame []byte, detail string) *ProtocolError {
+	return &ProtocolError{Kind: kind, BarID: commandBarID(cmd), Frame: frame, Detail: detail}
+}
+
+// commandBarID returns the address encoded in an addressed command frame, or
+// -1 for broadcasts and anything too short to carry one.
+func commandBarID(cmd []byte) int {
+	if string(cmd) == Euler {
+		return -1
+	}
+	id, ok := ParseAddress(cmd)
+	if !ok {
+		return -1
+	}
+	return id
+}
+
+func hexDump(b []byte) string {
+	parts := make([]string, 0, len(b))
+	for _, c := range b {
+		parts = append(parts, fmt.Sprintf("%02X", c))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,227 @@
+package serial
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+)
+
+// Firmware upload runs over the bootloader a bar enters after the addressed
+// Euler handshake. There is no bootloader specification yet: the framing
+// below is a placeholder, implemented identically by the simulator, and will
+// change once the real protocol is known. All frames are ordinary addressed
+// text frames with the usual crc16, so the image travels hex-encoded and
+// never contains a CR:
+//
+//	F<size>|<crc32>|      start: image length in bytes and its CRC-32 (8 hex digits); the bar erases and answers OK
+//	B<seq><data>          block seq (4 hex digits, from 0) with up to FirmwareBlockSize bytes as hex; answered OK<seq>
+//	E                     end: the bar checks length and CRC-32 of what it received and answers OK or ERR
+//	R                     reboot into the new image
+//
+// A block whose ack is lost is simply sent again: the bootloader acks a
+// repeat of the previous block without writing it twice.
+const FirmwareBlockSize = 64
+
+// firmwareRetries is how often a start, block or end frame is sent before the
+// upload is given up.
+const firmwareRetries = 3
+
+// firmwareEraseTimeout bounds the reply to 'F', which waits for the erase.
+const firmwareEraseTimeout = 2000
+
+// firmwareVersion matches the version banner the firmware answers 'V' with; it
+// is embedded in every image.
+var firmwareVersion = regexp.MustCompile(`Leo485 Version (\d+)\.(\d+)\.(\d+)`)
+
+// FirmwareImage is a firmware file ready to upload.
+type FirmwareImage struct {
+	Data    []byte
+	CRC     uint32
+	Version *models.VERSION // from the embedded banner; nil if none was found
+}
+
+// LoadFirmware reads a raw binary firmware image.
+func LoadFirmware(path string) (*FirmwareImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFirmware(data)
+}
+
+// ParseFirmware wraps a raw binary image and picks up its version banner.
+func ParseFirmware(data []byte) (*FirmwareImage, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty firmware image")
+	}
+	if Blocks(len(data)) > 0xFFFF {
+		return nil, fmt.Errorf("firmware image too large: %d bytes", len(data))
+	}
+	img := &FirmwareImage{Data: data, CRC: crc32.ChecksumIEEE(data)}
+	if m := firmwareVersion.FindSubmatch(data); m != nil {
+		id, _ := strconv.Atoi(string(m[1]))
+		major, _ := strconv.Atoi(string(m[2]))
+		minor, _ := strconv.Atoi(string(m[3]))
+		img.Version = &models.VERSION{ID: id, MAJOR: major, MINOR: minor}
+	}
+	return img, nil
+}
+
+// Blocks returns how many blocks an image of size bytes is sent in.
+func Blocks(size int) int {
+	return (size + FirmwareBlockSize - 1) / FirmwareBlockSize
+}
+
+// FirmwareProgress reports one step of an upload.
+type FirmwareProgress struct {
+	Bar     int    `json:"bar"` // 1-based, as shown to the operator
+	ID      int    `json:"id"`
+	Stage   string `json:"stage"` // enter, erase, block, end, reboot, verify
+	Block   int    `json:"block,omitempty"`
+	Blocks  int    `json:"blocks,omitempty"`
+	Retries int    `json:"retries,omitempty"` // resends so far in this upload
+}
+
+// FirmwareResult is the outcome of uploading an image to one bar.
+type FirmwareResult struct {
+	Bar      int             `json:"bar"`
+	ID       int             `json:"id"`
+	OK       bool            `json:"ok"`
+	Version  *models.VERSION `json:"version,omitempty"` // reported after the reboot
+	Retries  int             `json:"retries"`
+	Duration float64         `json:"durationMs"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// UploadFirmware puts a bar into update mode, sends img block by block,
+// reboots it and waits up to ready for it to answer 'V'. When the image
+// carries a version banner the bar must report that version afterwards. The
+// transfer holds the bus at admin priority; the readiness polls do not.
+func (l *Leo485) UploadFirmware(index int, img *FirmwareImage, ready time.Duration, onProgress func(FirmwareProgress)) FirmwareResult {
+	if bus, j, ok := l.onBus(index); ok {
+		res := bus.UploadFirmware(j, img, ready, func(p FirmwareProgress) {
+			p.Bar = index + 1
+			if onProgress != nil {
+				onProgress(p)
+			}
+		})
+		res.Bar = index + 1
+		return res
+	}
+	start := time.Now()
+	res := FirmwareResult{Bar: index + 1, ID: l.Bars[index].ID}
+	emit := func(p FirmwareProgress) {
+		p.Bar, p.ID, p.Retries = res.Bar, res.ID, res.Retries
+		if onProgress != nil {
+			onProgress(p)
+		}
+	}
+	fail := func(err error) FirmwareResult {
+		res.Error = err.Error()
+		res.Duration = ms(time.Since(start))
+		return res
+	}
+	var err error
+	if qerr := l.queue(PriorityAdmin, func() { err = l.uploadFirmware(index, img, &res.Retries, emit) }); qerr != nil {
+		return fail(qerr)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	emit(FirmwareProgress{Stage: "verify"})
+	if _, err := l.WaitReady(index, ready); err != nil {
+		return fail(err)
+	}
+	id, major, minor, err := l.GetVersion(index)
+	if err != nil {
+		return fail(err)
+	}
+	res.Version = &models.VERSION{ID: id, MAJOR: major, MINOR: minor}
+	res.Duration = ms(time.Since(start))
+	if img.Version != nil && *res.Version != *img.Version {
+		res.Error = fmt.Sprintf("bar reports version %s after update, image is %s", FormatVersion(*res.Version), FormatVersion(*img.Version))
+		return res
+	}
+	res.OK = true
+	return res
+}
+
+func (l *Leo485) uploadFirmware(index int, img *FirmwareImage, retries *int, emit func(FirmwareProgress)) error {
+	id := l.Bars[index].ID
+	to := l.Timeouts.WithDefaults()
+	blocks := Blocks(len(img.Data))
+
+	emit(FirmwareProgress{Stage: "enter"})
+	resp, err := changeState(l.Serial, GetCommand(id, []byte(Euler)), to.Handshake)
+	if err != nil {
+		return fmt.Errorf("enter update mode: %w", err)
+	}
+	if !strings.Contains(resp, "Enter") {
+		return fmt.Errorf("enter update mode: no Enter in %q", strings.TrimSpace(resp))
+	}
+
+	// send retries one frame until its reply passes want.
+	send := func(payload string, timeout int, want func(string) bool) error {
+		cmd := GetCommand(id, []byte(payload))
+		var last error
+		for attempt := 0; attempt < firmwareRetries; attempt++ {
+			if attempt > 0 {
+				*retries++
+			}
+			data, err := getData(l.Serial, cmd, timeout)
+			if err == nil && want(data) {
+				return nil
+			}
+			if err == nil {
+				err = protoErr(ErrMalformed, cmd, []byte(data), "unexpected reply")
+			}
+			last = err
+			if !errors.Is(err, ErrTimeout) {
+				// Let a late or garbled reply drain before the resend.
+				_, _ = readUntil(l.Serial, 20)
+			}
+		}
+		return last
+	}
+	isOK := func(s string) bool { return strings.TrimSpace(s) == "OK" }
+
+	emit(FirmwareProgress{Stage: "erase", Blocks: blocks})
+	if err := send(fmt.Sprintf("F%d|%08X|", len(img.Data), img.CRC), firmwareEraseTimeout, isOK); err != nil {
+		return fmt.Errorf("start upload: %w", err)
+	}
+	for seq := 0; seq < blocks; seq++ {
+		end := (seq + 1) * FirmwareBlockSize
+		if end > len(img.Data) {
+			end = len(img.Data)
+		}
+		chunk := img.Data[seq*FirmwareBlockSize : end]
+		ack := fmt.Sprintf("OK%04X", seq)
+		payload := fmt.Sprintf("B%04X%s", seq, strings.ToUpper(hex.EncodeToString(chunk)))
+		if err := send(payload, to.Write, func(s string) bool { return strings.TrimSpace(s) == ack }); err != nil {
+			return fmt.Errorf("block %d of %d: %w", seq+1, blocks, err)
+		}
+		emit(FirmwareProgress{Stage: "block", Block: seq + 1, Blocks: blocks})
+	}
+	emit(FirmwareProgress{Stage: "end", Block: blocks, Blocks: blocks})
+	if err := send("E", to.Write, isOK); err != nil {
+		return fmt.Errorf("finish upload: %w", err)
+	}
+	emit(FirmwareProgress{Stage: "reboot", Block: blocks, Blocks: blocks})
+	if !l.reboot(index) {
+		return fmt.Errorf("reboot after upload: no reply")
+	}
+	return nil
+}
+
+// FormatVersion renders a version the way the firmware banner does.
+func FormatVersion(v models.VERSION) string {
+	return fmt.Sprintf("%d.%d.%d", v.ID, v.MAJOR, v.MINOR)
+}
@@ -0,0 +1,89 @@
+package serial_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// firmwareImage builds a simulator image of 20 blocks carrying version v.
+func firmwareImage(t *testing.T, v models.VERSION) *serialpkg.FirmwareImage {
+	t.Helper()
+	img, err := serialpkg.ParseFirmware(simulator.FirmwareImage(v, 20*serialpkg.FirmwareBlockSize-7, 1))
+	if err != nil {
+		t.Fatalf("ParseFirmware: %v", err)
+	}
+	if img.Version == nil || *img.Version != v {
+		t.Fatalf("image banner %v, want %v", img.Version, v)
+	}
+	return img
+}
+
+func TestUploadFirmware(t *testing.T) {
+	l, sim := simBus(t, []*models.BAR{{ID: 1, LCS: 3}, {ID: 2, LCS: 15}})
+	sim.BootTime = 50 * time.Millisecond
+	sim.BlockErrorRate = 0.2
+	next := models.VERSION{ID: 12009, MAJOR: 1, MINOR: 203}
+	img := firmwareImage(t, next)
+
+	var blocks int
+	res := l.UploadFirmware(1, img, 2*time.Second, func(p serialpkg.FirmwareProgress) {
+		if p.Stage == "block" {
+			blocks++
+		}
+	})
+	if !res.OK {
+		t.Fatalf("upload failed: %s", res.Error)
+	}
+	if blocks != serialpkg.Blocks(len(img.Data)) {
+		t.Errorf("%d block acks, want %d", blocks, serialpkg.Blocks(len(img.Data)))
+	}
+	if res.Retries == 0 {
+		t.Error("no block was resent despite BlockErrorRate")
+	}
+	if res.Version == nil || *res.Version != next {
+		t.Errorf("bar reports %v after the upload, want %v", res.Version, next)
+	}
+	if v, _ := sim.Version(2); v != next {
+		t.Errorf("simulated bar runs %v, want %v", v, next)
+	}
+	if v, _ := sim.Version(1); v != simulator.DefaultVersion {
+		t.Errorf("bar ID 1 changed to %v", v)
+	}
+}
+
+func TestUploadFirmwareCRCMismatch(t *testing.T) {
+	l, sim := simBus(t, []*models.BAR{{ID: 1, LCS: 3}})
+	sim.BootTime = 50 * time.Millisecond
+	img := firmwareImage(t, models.VERSION{ID: 12009, MAJOR: 1, MINOR: 203})
+	img.CRC ^= 1 // announced CRC no longer matches the blocks
+
+	res := l.UploadFirmware(0, img, 2*time.Second, nil)
+	if res.OK || !strings.Contains(res.Error, "finish upload") {
+		t.Fatalf("upload with a bad CRC: ok=%v error=%q, want a finish failure", res.OK, res.Error)
+	}
+	if v, _ := sim.Version(1); v != simulator.DefaultVersion {
+		t.Errorf("bar runs %v after a rejected image", v)
+	}
+}
+
+func TestUploadFirmwareVersionCheck(t *testing.T) {
+	l, sim := simBus(t, []*models.BAR{{ID: 1, LCS: 3}})
+	sim.BootTime = 50 * time.Millisecond
+	actual := models.VERSION{ID: 12009, MAJOR: 1, MINOR: 203}
+	img := firmwareImage(t, actual)
+	// The host expects another version than the image makes the bar report.
+	img.Version = &models.VERSION{ID: 12009, MAJOR: 2, MINOR: 0}
+
+	res := l.UploadFirmware(0, img, 2*time.Second, nil)
+	if res.OK || !strings.Contains(res.Error, "reports version 12009.1.203") {
+		t.Fatalf("version mismatch: ok=%v error=%q", res.OK, res.Error)
+	}
+	if res.Version == nil || *res.Version != actual {
+		t.Errorf("result version %v, want %v", res.Version, actual)
+	}
+}
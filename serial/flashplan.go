@@ -0,0 +1,181 @@
+package serial
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+)
+
+// PlannedFrame is one frame a flash sends, decoded for review before anything
+// is written. BarID is -1 for frames that carry no address.
+type PlannedFrame struct {
+	Seq     int            `json:"seq"`
+	Step    string         `json:"step"`          // enter, handshake, prime, zeros, factors, reboot
+	Bar     int            `json:"bar,omitempty"` // 1-based position in BARS
+	BarID   int            `json:"barId"`
+	Command string         `json:"command"`
+	Text    string         `json:"text"` // frame without CRC and terminator
+	CRC     string         `json:"crc,omitempty"`
+	Hex     string         `json:"hex"`
+	Fields  []PlannedField `json:"fields,omitempty"`
+}
+
+// PlannedField is one decoded payload field of a PlannedFrame.
+type PlannedField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ZeroTotal is the average total written after the zeros of an 'O' frame:
+// the mean of ZERO*FACTOR over the bar's cells. A negative mean is written as
+// 0 and reported through negative.
+func ZeroTotal(lcs []*models.LC) (total uint64, negative bool) {
+	if len(lcs) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, lc := range lcs {
+		sum += float64(lc.ZERO) * float64(lc.FACTOR)
+	}
+	if sum < 0 {
+		return 0, true
+	}
+	return uint64(sum/float64(len(lcs)) + 0.5), false
+}
+
+// FlashPayloads returns the 'O' and 'X' payloads a flash writes to bar, built
+// from its LC entries. It fails unless bar has one LC entry per active cell.
+func FlashPayloads(bar *models.BAR) (zeros, factors string, err error) {
+	z := make([]float64, len(bar.LC))
+	f := make([]float64, len(bar.LC))
+	for j, lc := range bar.LC {
+		z[j] = float64(lc.ZERO)
+		f[j] = float64(lc.FACTOR)
+	}
+	total, _ := ZeroTotal(bar.LC)
+	if zeros, err = ZerosPayload(bar, z, total); err != nil {
+		return "", "", err
+	}
+	if factors, err = FactorsPayload(bar, f); err != nil {
+		return "", "", err
+	}
+	return zeros, factors, nil
+}
+
+// SelectBars returns the positions in bars of the given bar IDs, in BARS
+// order. An empty ids selects every bar and returns nil.
+func SelectBars(bars []*models.BAR, ids []int) ([]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	want := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	var sel []int
+	for i, bar := range bars {
+		if want[bar.ID] {
+			sel = append(sel, i)
+			delete(want, bar.ID)
+		}
+	}
+	for _, id := range ids {
+		if want[id] {
+			return nil, fmt.Errorf("bar ID %d is not configured", id)
+		}
+	}
+	return sel, nil
+}
+
+// PlanFlash lists, in order, every frame a flash of bars sends: the broadcast
+// Euler sequence that opens update mode, the per-bar Euler handshake, the CR
+// that primes the bootloaders, each bar's 'O' and 'X' frames, then an 'R' to
+// each flashed bar. sel restricts the flash to those positions in bars (see
+// SelectBars); a partial flash skips the broadcast, so the other bars see no
+// frame at all. It fails when a selected bar lacks one LC entry per active
+// cell.
+func PlanFlash(bars []*models.BAR, sel []int) ([]PlannedFrame, error) {
+	var frames []PlannedFrame
+	add := func(f PlannedFrame) {
+		f.Seq = len(frames) + 1
+		frames = append(frames, f)
+	}
+	if sel == nil {
+		for i := range bars {
+			sel = append(sel, i)
+		}
+	}
+	if len(sel) == len(bars) {
+		add(rawFrame("enter", "Euler", []byte(Euler)))
+	}
+	for _, i := range sel {
+		f := addressedFrame("handshake", i, bars[i], []byte(Euler))
+		f.Command = "Euler"
+		add(f)
+	}
+	add(rawFrame("prime", "CR", []byte{0x0D}))
+	for _, i := range sel {
+		bar := bars[i]
+		zeros, factors, err := FlashPayloads(bar)
+		if err != nil {
+			return nil, err
+		}
+		z := addressedFrame("zeros", i, bar, []byte(zeros))
+		z.Fields = payloadFields(bar, zeros, "zero")
+		add(z)
+		x := addressedFrame("factors", i, bar, []byte(factors))
+		x.Fields = payloadFields(bar, factors, "factor")
+		add(x)
+	}
+	for _, i := range sel {
+		add(addressedFrame("reboot", i, bars[i], []byte("R")))
+	}
+	return frames, nil
+}
+
+func rawFrame(step, command string, frame []byte) PlannedFrame {
+	return PlannedFrame{
+		Step:    step,
+		BarID:   -1,
+		Command: command,
+		Text:    strings.TrimSuffix(string(frame), "\r"),
+		Hex:     strings.ToUpper(hex.EncodeToString(frame)),
+	}
+}
+
+func addressedFrame(step string, index int, bar *models.BAR, command []byte) PlannedFrame {
+	frame := GetCommand(bar.ID, command)
+	body := strings.TrimSuffix(string(frame[:len(frame)-3]), "\r") // strip CRC and CR
+	return PlannedFrame{
+		Step:    step,
+		Bar:     index + 1,
+		BarID:   bar.ID,
+		Command: string(command[:1]),
+		Text:    body,
+		CRC:     strings.ToUpper(hex.EncodeToString(frame[len(frame)-3 : len(frame)-1])),
+		Hex:     strings.ToUpper(hex.EncodeToString(frame)),
+	}
+}
+
+// payloadFields names the fields of an 'O' or 'X' payload: one per slot,
+// marked inactive where LCS has no cell, plus the total of an 'O' frame.
+func payloadFields(bar *models.BAR, payload, kind string) []PlannedField {
+	values := strings.Split(strings.TrimSuffix(payload[1:], "|"), "|")
+	fields := make([]PlannedField, 0, len(values))
+	lc := 0
+	for i, v := range values {
+		name := "total"
+		switch {
+		case i >= bar.Slots():
+		case bar.LCS&(1<<i) != 0:
+			lc++
+			name = fmt.Sprintf("LC %d %s (slot %d)", lc, kind, i+1)
+		default:
+			name = fmt.Sprintf("slot %d inactive", i+1)
+		}
+		fields = append(fields, PlannedField{Name: name, Value: v})
+	}
+	return fields
+}
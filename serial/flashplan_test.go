@@ -0,0 +1,139 @@
+package serial_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+func planBars() []*models.BAR {
+	return []*models.BAR{
+		{ID: 3, LCS: 3, LC: []*models.LC{{ZERO: 145000000, FACTOR: 0.00125}, {ZERO: 150000000, FACTOR: 0.0025}}},
+		{ID: 7, LCS: 5, SLOTS: 4, LC: []*models.LC{{ZERO: 146000000, FACTOR: 0.001}, {ZERO: 147000000, FACTOR: -0.002}}},
+	}
+}
+
+// decodePlanned checks that f's hex is the frame its other fields describe
+// and returns it decoded as sent.
+func decodePlanned(t *testing.T, f serialpkg.PlannedFrame) serialpkg.CaptureRecord {
+	t.Helper()
+	raw, err := hex.DecodeString(f.Hex)
+	if err != nil {
+		t.Fatalf("frame %d: hex %q: %v", f.Seq, f.Hex, err)
+	}
+	if len(raw) == 0 || raw[len(raw)-1] != '\r' {
+		t.Errorf("frame %d %q does not end in CR", f.Seq, f.Hex)
+	}
+	rec := serialpkg.DecodeFrame("tx", raw)
+	if rec.BarID != f.BarID {
+		t.Errorf("frame %d: sent to bar ID %d, planned for %d", f.Seq, rec.BarID, f.BarID)
+	}
+	if f.BarID < 0 {
+		return rec
+	}
+	body := raw[:len(raw)-3]
+	if !rec.CRCValid || !bytes.Equal(serialpkg.CRC16(body), raw[len(raw)-3:len(raw)-1]) {
+		t.Errorf("frame %d %q: bad CRC", f.Seq, f.Hex)
+	}
+	// Text drops the CR the Euler handshake carries before its CRC.
+	if strings.TrimSuffix(string(body), "\r") != f.Text || strings.ToUpper(hex.EncodeToString(raw[len(raw)-3:len(raw)-1])) != f.CRC {
+		t.Errorf("frame %d: text %q CRC %s do not match %q", f.Seq, f.Text, f.CRC, f.Hex)
+	}
+	if rec.Command != f.Command {
+		t.Errorf("frame %d: command %q, planned %q", f.Seq, rec.Command, f.Command)
+	}
+	return rec
+}
+
+func TestPlanFlash(t *testing.T) {
+	bars := planBars()
+	frames, err := serialpkg.PlanFlash(bars, nil)
+	if err != nil {
+		t.Fatalf("PlanFlash: %v", err)
+	}
+	want := []struct {
+		step    string
+		barID   int
+		command string
+	}{
+		{"enter", -1, "Euler"},
+		{"handshake", 3, "Euler"},
+		{"handshake", 7, "Euler"},
+		{"prime", -1, "CR"},
+		{"zeros", 3, "O"},
+		{"factors", 3, "X"},
+		{"zeros", 7, "O"},
+		{"factors", 7, "X"},
+		{"reboot", 3, "R"},
+		{"reboot", 7, "R"},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("%d frames planned, want %d: %+v", len(frames), len(want), frames)
+	}
+	for k, w := range want {
+		f := frames[k]
+		if f.Seq != k+1 || f.Step != w.step || f.BarID != w.barID || f.Command != w.command {
+			t.Errorf("frame %d: seq %d %s to bar ID %d command %s, want %s to %d command %s",
+				k+1, f.Seq, f.Step, f.BarID, f.Command, w.step, w.barID, w.command)
+		}
+		decodePlanned(t, f)
+	}
+	if frames[0].Hex != strings.ToUpper(hex.EncodeToString([]byte(serialpkg.Euler))) {
+		t.Errorf("enter frame %s is not the Euler broadcast", frames[0].Hex)
+	}
+	if frames[3].Hex != "0D" {
+		t.Errorf("prime frame %s, want a lone CR", frames[3].Hex)
+	}
+
+	// Each bar's O and X carry exactly the payloads a flash writes, with
+	// every slot named.
+	for k, bar := range bars {
+		zeros, factors, err := serialpkg.FlashPayloads(bar)
+		if err != nil {
+			t.Fatalf("FlashPayloads: %v", err)
+		}
+		z, x := frames[4+2*k], frames[5+2*k]
+		if !strings.HasSuffix(z.Text, zeros) || !strings.HasSuffix(x.Text, factors) {
+			t.Errorf("bar ID %d: planned %q and %q, want payloads %q and %q", bar.ID, z.Text, x.Text, zeros, factors)
+		}
+		if len(z.Fields) != bar.Slots()+1 || len(x.Fields) != bar.Slots() {
+			t.Errorf("bar ID %d: %d zero and %d factor fields for %d slots", bar.ID, len(z.Fields), len(x.Fields), bar.Slots())
+		}
+	}
+	z7 := frames[6].Fields
+	if z7[0].Name != "LC 1 zero (slot 1)" || z7[0].Value != "146000000" || z7[1].Name != "slot 2 inactive" ||
+		z7[2].Name != "LC 2 zero (slot 3)" || z7[2].Value != "147000000" || z7[4].Name != "total" {
+		t.Errorf("bar ID 7 zero fields %+v", z7)
+	}
+}
+
+func TestPlanFlashPartial(t *testing.T) {
+	frames, err := serialpkg.PlanFlash(planBars(), []int{1})
+	if err != nil {
+		t.Fatalf("PlanFlash: %v", err)
+	}
+	if len(frames) != 5 {
+		t.Fatalf("%d frames planned for one bar, want 5: %+v", len(frames), frames)
+	}
+	for _, f := range frames {
+		rec := decodePlanned(t, f)
+		if rec.BarID != 7 && f.Step != "prime" {
+			t.Errorf("partial flash plans %s frame %s for bar ID %d", f.Step, f.Hex, rec.BarID)
+		}
+	}
+}
+
+func TestPlanFlashMissingLC(t *testing.T) {
+	bars := planBars()
+	bars[1].LC = bars[1].LC[:1]
+	if _, err := serialpkg.PlanFlash(bars, nil); err == nil {
+		t.Error("bar with one LC entry for two cells planned")
+	}
+	if _, err := serialpkg.PlanFlash(bars, []int{0}); err != nil {
+		t.Errorf("unselected bar failed the plan: %v", err)
+	}
+}
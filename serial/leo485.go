@@ -1,98 +1,278 @@
 package serial
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	models "github.com/CK6170/Calrunrilla-go/models"
-	goserial "github.com/tarm/serial"
 )
 
 const Euler = "27182818284590452353602874713527\r"
 
+// CommandTimeouts are reply timeouts in milliseconds per command class. Reads
+// return as soon as a valid frame arrives, so these only bound how long a
+// missing or broken reply is waited for. Zero fields use DefaultTimeouts.
+type CommandTimeouts struct {
+	Version   int // V
+	Measure   int // SERIAL.COMMAND (ADC read)
+	Read      int // X/O factor and zero read-back
+	Write     int // O/X zero and factor writes
+	Reboot    int // R
+	Enter     int // broadcast Euler handshake
+	Handshake int // addressed Euler handshake of a single bar
+}
+
+// DefaultTimeouts are the per-command timeouts used by a new Leo485.
+var DefaultTimeouts = CommandTimeouts{Version: 200, Measure: 200, Read: 300, Write: 200, Reboot: 200, Enter: 1000, Handshake: 400}
+
+// WithDefaults fills zero fields from DefaultTimeouts.
+func (c CommandTimeouts) WithDefaults() CommandTimeouts {
+	pick := func(v, def int) int {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+	d := DefaultTimeouts
+	return CommandTimeouts{
+		Version:   pick(c.Version, d.Version),
+		Measure:   pick(c.Measure, d.Measure),
+		Read:      pick(c.Read, d.Read),
+		Write:     pick(c.Write, d.Write),
+		Reboot:    pick(c.Reboot, d.Reboot),
+		Enter:     pick(c.Enter, d.Enter),
+		Handshake: pick(c.Handshake, d.Handshake),
+	}
+}
+
+// TimeoutsFor returns the command timeouts configured in ser.TIMEOUTS; fields
+// left zero keep their DefaultTimeouts value. ENTER bounds both the broadcast
+// and the addressed handshake.
+func TimeoutsFor(ser *models.SERIAL) CommandTimeouts {
+	if ser == nil || ser.TIMEOUTS == nil {
+		return DefaultTimeouts
+	}
+	t := ser.TIMEOUTS
+	return CommandTimeouts{
+		Version:   t.VERSION,
+		Measure:   t.MEASURE,
+		Read:      t.READ,
+		Write:     t.WRITE,
+		Reboot:    t.REBOOT,
+		Enter:     t.ENTER,
+		Handshake: t.ENTER,
+	}.WithDefaults()
+}
+
+// Leo485 talks to the bars on one bus, or on several joined into a shelf by
+// JoinBuses. Every method is queued on the bus scheduler, so concurrent
+// callers never interleave frames; use Exclusive for multi-frame sequences and
+// WithPriority to change a caller's queue priority.
 type Leo485 struct {
-	Serial       *goserial.Port
+	Serial       Transport // nil on a shelf of several buses; see Bus
 	Bars         []*models.BAR
-	NLCs         int
+	NLCs         []int // active load cells of each bar, in Bars order
 	SerialConfig *models.SERIAL
+	Timeouts     CommandTimeouts
+
+	// ZeroRead is the firmware known to answer the bare 'O' zeros read (see
+	// ReadCalibration); nil sends it to no bar.
+	ZeroRead *models.VERSIONRANGE
+
+	sched    *scheduler // nil inside Exclusive: the caller already owns the bus
+	prio     Priority
+	stats    *linkStats   // per-bar link counters of the bus, nil on a shelf
+	versions *barVersions // last version each bar reported, nil on a shelf
+
+	buses []*Leo485 // one per bus on a shelf of several buses, else nil
+	route []busBar  // bus of each bar, in Bars order, when buses is set
 }
 
 func NewLeo485(ser *models.SERIAL, bars []*models.BAR) *Leo485 {
-	config := &goserial.Config{
-		Name:        ser.PORT,
-		Baud:        ser.BAUDRATE,
-		Parity:      goserial.ParityNone,
-		Size:        8,
-		StopBits:    goserial.Stop1,
-		ReadTimeout: time.Millisecond * 300,
-	}
-	port, err := goserial.OpenPort(config)
+	t, err := OpenTransport(ser)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, err := NewLeo485WithTransport(t, ser, bars)
 	if err != nil {
+		_ = t.Close()
 		log.Fatal(err)
 	}
+	return l
+}
+
+// NewLeo485WithTransport builds a Leo485 on an already opened transport.
+// Unlike NewLeo485 it reports configuration problems as errors; the caller
+// keeps ownership of t when an error is returned.
+func NewLeo485WithTransport(t Transport, ser *models.SERIAL, bars []*models.BAR) (*Leo485, error) {
+	if t == nil {
+		return nil, fmt.Errorf("nil transport")
+	}
+	if len(bars) == 0 {
+		return nil, fmt.Errorf("no BARS configured")
+	}
+	if err := ValidateBars(bars); err != nil {
+		return nil, err
+	}
+	stats := newLinkStats()
 	l := &Leo485{
-		Serial:       port,
+		Serial:       &statsTransport{Transport: t, stats: stats},
 		Bars:         bars,
 		SerialConfig: ser,
-	}
-	l.NLCs = numOfActiveLCs(bars[0].LCS)
-	for _, bar := range bars {
-		if numOfActiveLCs(bar.LCS) != l.NLCs {
-			log.Fatal("Number of Load Cells per bar must match")
+		Timeouts:     TimeoutsFor(ser),
+		prio:         PriorityNormal,
+		stats:        stats,
+		versions:     &barVersions{byID: make(map[int]models.VERSION)},
+	}
+	l.NLCs = make([]int, len(bars))
+	for i, bar := range bars {
+		l.NLCs[i] = bar.NLCs()
+		if l.NLCs[i] <= 0 {
+			return nil, fmt.Errorf("bar %d: invalid LCS bitmask", i+1)
 		}
 	}
-	return l
+	l.sched = newScheduler()
+	return l, nil
 }
 
 func (l *Leo485) Open() error { return nil }
 
-func (l *Leo485) Close() error { return l.Serial.Close() }
+// Close waits for queued requests to finish, then closes the transport.
+func (l *Leo485) Close() error {
+	if l.buses != nil {
+		var first error
+		for _, b := range l.buses {
+			if err := b.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	if l.sched != nil {
+		l.sched.close()
+	}
+	return l.Serial.Close()
+}
+
+// TotalLCs returns the number of load cells across all bars, i.e. the length
+// of the flat per-cell vectors used by calibration.
+func (l *Leo485) TotalLCs() int {
+	n := 0
+	for _, c := range l.NLCs {
+		n += c
+	}
+	return n
+}
+
+// Offset returns the index of bar i's first cell in a flat per-cell vector.
+func (l *Leo485) Offset(i int) int {
+	n := 0
+	for _, c := range l.NLCs[:i] {
+		n += c
+	}
+	return n
+}
+
+// WithPriority returns a view of l whose requests queue at prio. The view
+// shares the bus and scheduler with l.
+func (l *Leo485) WithPriority(prio Priority) *Leo485 {
+	v := *l
+	v.prio = prio
+	if l.buses != nil {
+		v.buses = make([]*Leo485, len(l.buses))
+		for k, b := range l.buses {
+			v.buses[k] = b.WithPriority(prio)
+		}
+	}
+	return &v
+}
+
+// Exclusive runs fn as one scheduler request at prio, so no other traffic
+// reaches the bus until fn returns. fn receives an unscheduled view of l whose
+// methods (and Serial) talk to the bus directly; calling methods on l itself
+// from inside fn would deadlock. On a shelf every bus is held.
+func (l *Leo485) Exclusive(prio Priority, fn func(bus *Leo485) error) error {
+	if l.buses != nil {
+		return l.exclusiveAll(prio, fn)
+	}
+	v := *l
+	v.sched = nil
+	var err error
+	if qerr := l.queue(prio, func() { err = fn(&v) }); qerr != nil {
+		return qerr
+	}
+	return err
+}
+
+// BusStats reports scheduler queue depth and wait times.
+func (l *Leo485) BusStats() BusStats {
+	if l.buses != nil {
+		stats := make([]BusStats, len(l.buses))
+		for k, b := range l.buses {
+			stats[k] = b.BusStats()
+		}
+		return mergeStats(stats)
+	}
+	if l.sched == nil {
+		return BusStats{}
+	}
+	return l.sched.stats()
+}
+
+// Drain discards bytes already waiting on the bus, reading for up to timeoutMS.
+func (l *Leo485) Drain(timeoutMS int) {
+	if l.buses != nil {
+		for _, b := range l.buses {
+			b.Drain(timeoutMS)
+		}
+		return
+	}
+	_ = l.queue(l.prio, func() { _, _ = readUntil(l.Serial, timeoutMS) })
+}
+
+// queue runs fn on the bus scheduler, or directly inside Exclusive.
+func (l *Leo485) queue(prio Priority, fn func()) error {
+	if l.sched == nil {
+		fn()
+		return nil
+	}
+	return l.sched.do(prio, fn)
+}
 
 func (l *Leo485) GetADs(index int) ([]uint64, error) {
-	// Keep calibration/other flows lenient to avoid turning transient parse issues into hard failures.
-	return l.GetADsWithTimeout(index, 200)
+	if bus, j, ok := l.onBus(index); ok {
+		return bus.GetADs(j)
+	}
+	return l.GetADsWithTimeout(index, l.Timeouts.WithDefaults().Measure)
 }
 
 // GetADsWithTimeout reads ADCs using a custom timeout (in ms). Useful for higher-rate
 // polling in test mode while keeping calibration reads more conservative.
-func (l *Leo485) GetADsWithTimeout(index int, timeoutMS int) ([]uint64, error) {
-	cmd := GetCommand(l.Bars[index].ID, []byte(l.SerialConfig.COMMAND))
-	response, err := sendCommand(l.Serial, cmd, timeoutMS)
-	if err != nil {
-		return nil, err
-	}
-	if len(response) == 0 {
-		return []uint64{}, nil
-	}
-	vals, err := parseValues(response, cmd, l.Bars[index].LCS)
-	if err != nil {
-		return []uint64{}, nil
+// Failures are returned as *ProtocolError; use ErrorClass or errors.Is to tell
+// timeouts, CRC errors, wrong addresses and malformed payloads apart.
+func (l *Leo485) GetADsWithTimeout(index int, timeoutMS int) (bruts []uint64, err error) {
+	if bus, j, ok := l.onBus(index); ok {
+		return bus.GetADsWithTimeout(j, timeoutMS)
 	}
-	bruts := make([]uint64, len(vals))
-	for i, v := range vals {
-		bruts[i] = uint64(v.brut)
+	if qerr := l.queue(l.prio, func() { bruts, err = l.getADs(index, timeoutMS) }); qerr != nil {
+		return nil, qerr
 	}
-	return bruts, nil
+	return bruts, err
 }
 
-// GetADsStrictWithTimeout is a strict variant for high-rate polling (test mode):
-// if the response is empty or cannot be parsed/validated, it returns an error.
-func (l *Leo485) GetADsStrictWithTimeout(index int, timeoutMS int) ([]uint64, error) {
+func (l *Leo485) getADs(index int, timeoutMS int) ([]uint64, error) {
 	cmd := GetCommand(l.Bars[index].ID, []byte(l.SerialConfig.COMMAND))
 	response, err := sendCommand(l.Serial, cmd, timeoutMS)
 	if err != nil {
 		return nil, err
 	}
-	if len(response) == 0 {
-		return nil, fmt.Errorf("empty response")
-	}
-	vals, err := parseValues(response, cmd, l.Bars[index].LCS)
+	vals, err := parseValues(response, cmd, l.Bars[index])
 	if err != nil {
 		return nil, err
 	}
@@ -103,12 +283,66 @@ func (l *Leo485) GetADsStrictWithTimeout(index int, timeoutMS int) ([]uint64, er
 	return bruts, nil
 }
 
-func (l *Leo485) GetVersion(index int) (int, int, int, error) {
+// GetADsStrictWithTimeout is kept for existing callers; GetADsWithTimeout now
+// reports every invalid or empty response as an error as well.
+func (l *Leo485) GetADsStrictWithTimeout(index int, timeoutMS int) ([]uint64, error) {
+	return l.GetADsWithTimeout(index, timeoutMS)
+}
+
+func (l *Leo485) GetVersion(index int) (id, major, minor int, err error) {
+	if bus, j, ok := l.onBus(index); ok {
+		return bus.GetVersion(j)
+	}
+	if qerr := l.queue(l.prio, func() { id, major, minor, err = l.getVersion(index) }); qerr != nil {
+		return 0, 0, 0, qerr
+	}
+	return id, major, minor, err
+}
+
+func (l *Leo485) getVersion(index int) (int, int, int, error) {
 	cmd := GetCommand(l.Bars[index].ID, []byte("V"))
-	response, err := getData(l.Serial, cmd, 200)
+	response, err := getData(l.Serial, cmd, l.Timeouts.WithDefaults().Version)
 	if err != nil {
-		return 0, 0, 0, fmt.Errorf("GetVersion error: %v", err)
+		return 0, 0, 0, fmt.Errorf("GetVersion error: %w", err)
 	}
+	id, major, minor, err := parseVersion(response)
+	if err != nil {
+		return 0, 0, 0, protoErr(ErrMalformed, cmd, []byte(response), err.Error())
+	}
+	l.versions.set(l.Bars[index].ID, models.VERSION{ID: id, MAJOR: major, MINOR: minor})
+	return id, major, minor, nil
+}
+
+// barVersions remembers the firmware version each bar last reported, so
+// ReadCalibration knows which bars to send the zeros read without asking
+// again. A bar is forgotten when it reboots, since it may come back running
+// newly uploaded firmware.
+type barVersions struct {
+	mu   sync.Mutex
+	byID map[int]models.VERSION
+}
+
+func (v *barVersions) get(id int) (models.VERSION, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ver, ok := v.byID[id]
+	return ver, ok
+}
+
+func (v *barVersions) set(id int, ver models.VERSION) {
+	v.mu.Lock()
+	v.byID[id] = ver
+	v.mu.Unlock()
+}
+
+func (v *barVersions) forget(id int) {
+	v.mu.Lock()
+	delete(v.byID, id)
+	v.mu.Unlock()
+}
+
+// parseVersion extracts "<id>.<major>.<minor>" following "Version " in a V reply.
+func parseVersion(response string) (int, int, int, error) {
 	if !strings.Contains(response, "Version") {
 		return 0, 0, 0, fmt.Errorf("no version")
 	}
@@ -127,11 +361,38 @@ func (l *Leo485) GetVersion(index int) (int, int, int, error) {
 	return id, major, minor, nil
 }
 
-func (l *Leo485) WriteZeros(index int, zeros []float64, total uint64) bool {
+func (l *Leo485) WriteZeros(index int, zeros []float64, total uint64) (ok bool) {
+	if bus, j, on := l.onBus(index); on {
+		return bus.WriteZeros(j, zeros, total)
+	}
+	_ = l.queue(PriorityAdmin, func() { ok = l.writeZeros(index, zeros, total) })
+	return ok
+}
+
+func (l *Leo485) writeZeros(index int, zeros []float64, total uint64) bool {
+	payload, err := ZerosPayload(l.Bars[index], zeros, total)
+	if err != nil {
+		return false
+	}
+	cmd := GetCommand(l.Bars[index].ID, []byte(payload))
+	response, err := updateValue(l.Serial, cmd, l.Timeouts.WithDefaults().Write)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(response, "OK")
+}
+
+// ZerosPayload builds the 'O' write payload: one zero per slot of the bar
+// (inactive slots are written as 0) followed by the average total. zeros must
+// hold exactly one value per active load cell.
+func ZerosPayload(bar *models.BAR, zeros []float64, total uint64) (string, error) {
+	if len(zeros) != bar.NLCs() {
+		return "", fmt.Errorf("bar ID %d: %d zeros for %d load cells", bar.ID, len(zeros), bar.NLCs())
+	}
 	sb := "O"
 	k := 0
-	for i := 0; i < 4; i++ {
-		if (l.Bars[index].LCS & (1 << i)) != 0 {
+	for i := 0; i < bar.Slots(); i++ {
+		if (bar.LCS & (1 << i)) != 0 {
 			sb += fmt.Sprintf("%09.0f|", zeros[k])
 			k++
 		} else {
@@ -139,53 +400,103 @@ func (l *Leo485) WriteZeros(index int, zeros []float64, total uint64) bool {
 		}
 	}
 	sb += fmt.Sprintf("%09d|", total)
-	cmd := GetCommand(l.Bars[index].ID, []byte(sb))
-	response, err := updateValue(l.Serial, cmd, 200)
+	return sb, nil
+}
+
+func (l *Leo485) WriteFactors(index int, factors []float64) (ok bool) {
+	if bus, j, on := l.onBus(index); on {
+		return bus.WriteFactors(j, factors)
+	}
+	_ = l.queue(PriorityAdmin, func() { ok = l.writeFactors(index, factors) })
+	return ok
+}
+
+func (l *Leo485) writeFactors(index int, factors []float64) bool {
+	payload, err := FactorsPayload(l.Bars[index], factors)
+	if err != nil {
+		return false
+	}
+	cmd := GetCommand(l.Bars[index].ID, []byte(payload))
+	response, err := updateValue(l.Serial, cmd, l.Timeouts.WithDefaults().Write)
 	if err != nil {
 		return false
 	}
 	return strings.Contains(response, "OK")
 }
 
-func (l *Leo485) WriteFactors(index int, factors []float64) bool {
+// FactorsPayload builds the 'X' write payload: one factor per slot of the bar,
+// with inactive slots written as 1. factors must hold exactly one value per
+// active load cell.
+func FactorsPayload(bar *models.BAR, factors []float64) (string, error) {
+	if len(factors) != bar.NLCs() {
+		return "", fmt.Errorf("bar ID %d: %d factors for %d load cells", bar.ID, len(factors), bar.NLCs())
+	}
 	sb := "X"
 	k := 0
-	for i := 0; i < 4; i++ {
-		if (l.Bars[index].LCS & (1 << i)) != 0 {
-			sb += fmt.Sprintf("%.10f|", factors[k])
+	for i := 0; i < bar.Slots(); i++ {
+		if (bar.LCS & (1 << i)) != 0 {
+			sb += formatFactor(factors[k]) + "|"
 			k++
 		} else {
 			sb += "1.0000000000|"
 		}
 	}
-	cmd := GetCommand(l.Bars[index].ID, []byte(sb))
-	response, err := updateValue(l.Serial, cmd, 200)
-	if err != nil {
-		return false
+	return sb, nil
+}
+
+// formatFactor writes f in the fixed 10-decimal form the 'X' frame has always
+// carried. See WireFactor for what a bar makes of it.
+func formatFactor(f float64) string {
+	return fmt.Sprintf("%.10f", f)
+}
+
+// WireFactor is the float32 a bar stores when factor f is written: the value
+// of its 10-decimal text. Factors below about 0.01 lose low bits on the way,
+// so read-back factors are compared against WireFactor, not against f.
+func WireFactor(f float64) float32 {
+	v, _ := strconv.ParseFloat(formatFactor(f), 64)
+	return float32(v)
+}
+
+// OpenToUpdate broadcasts the Euler sequence; on a shelf, on every bus.
+func (l *Leo485) OpenToUpdate() (err error) {
+	if l.buses != nil {
+		for _, b := range l.buses {
+			if err := b.OpenToUpdate(); err != nil {
+				return fmt.Errorf("bus %s: %w", BusName(b.SerialConfig), err)
+			}
+		}
+		return nil
 	}
-	return strings.Contains(response, "OK")
+	if qerr := l.queue(PriorityAdmin, func() { err = l.openToUpdate() }); qerr != nil {
+		return qerr
+	}
+	return err
 }
 
-func (l *Leo485) OpenToUpdate() error {
-	data, err := changeState(l.Serial, []byte(Euler), 1000)
+func (l *Leo485) openToUpdate() error {
+	data, err := changeState(l.Serial, []byte(Euler), l.Timeouts.WithDefaults().Enter)
 	if err != nil {
 		return err
 	}
 	if !strings.Contains(data, "Enter") {
-		raw := []byte(data)
-		hexParts := make([]string, 0, len(raw))
-		for _, b := range raw {
-			hexParts = append(hexParts, fmt.Sprintf("%02X", b))
-		}
-		hexDump := strings.Join(hexParts, " ")
-		return fmt.Errorf("no enter: raw_len=%d raw_hex=%s raw_str=%q", len(raw), hexDump, strings.TrimSpace(data))
+		return &ProtocolError{Kind: ErrMalformed, BarID: -1, Frame: []byte(data), Detail: fmt.Sprintf("no enter: %q", strings.TrimSpace(data))}
 	}
 	return nil
 }
 
-func (l *Leo485) Reboot(index int) bool {
+func (l *Leo485) Reboot(index int) (ok bool) {
+	if bus, j, on := l.onBus(index); on {
+		return bus.Reboot(j)
+	}
+	_ = l.queue(PriorityAdmin, func() { ok = l.reboot(index) })
+	return ok
+}
+
+func (l *Leo485) reboot(index int) bool {
+	l.versions.forget(l.Bars[index].ID)
 	cmd := GetCommand(l.Bars[index].ID, []byte("R"))
-	response, err := changeState(l.Serial, cmd, 200)
+	response, err := changeState(l.Serial, cmd, l.Timeouts.WithDefaults().Reboot)
 	if err != nil {
 		return false
 	}
@@ -194,87 +505,167 @@ func (l *Leo485) Reboot(index int) bool {
 
 // ReadFactors queries a bar for its stored factors using the 'X' read command.
 // Response payload format: 4 bytes totalFactor (IEEE754) followed by 4-byte IEEE754 factors
-// for each active LC. Returns slice of factors (float64) or an error.
-func (l *Leo485) ReadFactors(index int) ([]float64, error) {
-	cmd := GetCommand(l.Bars[index].ID, []byte("X"))
-	// Send command and get raw bytes (no textual parsing)
-	raw, err := sendCommand(l.Serial, cmd, 300)
+// for each active LC of that bar (up to models.MAXLCS). Returns slice of factors
+// (float64) or an error.
+func (l *Leo485) ReadFactors(index int) (factors []float64, err error) {
+	if bus, j, ok := l.onBus(index); ok {
+		return bus.ReadFactors(j)
+	}
+	if qerr := l.queue(l.prio, func() { factors, _, err = l.readFactors(index) }); qerr != nil {
+		return nil, qerr
+	}
+	return factors, err
+}
+
+// Calibration is what a bar holds in flash, as read back by ReadCalibration.
+type Calibration struct {
+	Zeros       []uint64  // per active cell; nil when the firmware cannot report zeros
+	ZeroTotal   uint64    // average total written alongside the zeros
+	Factors     []float64 // per active cell
+	TotalFactor float64   // sum of the factors as computed by the firmware
+}
+
+// LCs converts the read-back values to calibrated-file entries. ZERO stays 0
+// when the firmware did not report zeros.
+func (c *Calibration) LCs() []*models.LC {
+	lcs := make([]*models.LC, len(c.Factors))
+	for i, f := range c.Factors {
+		lc := &models.LC{FACTOR: float32(f), IEEE: fmt.Sprintf("%08X", math.Float32bits(float32(f)))}
+		if i < len(c.Zeros) {
+			lc.ZERO = c.Zeros[i]
+		}
+		lcs[i] = lc
+	}
+	return lcs
+}
+
+// ReadCalibration reads the stored factors ('X') and zeros ('O') of a bar in
+// one bus turn. The 'O' read payload mirrors 'X': a 4-byte big-endian zero
+// total followed by a 4-byte big-endian zero per active LC.
+//
+// Only the 'X' read is part of the firmware protocol this package was written
+// against; 'O' followed by fields is the zeros write, and the bare 'O' read is
+// an extension modelled on 'X' (the simulator implements it). It is therefore
+// sent only to bars whose firmware falls in ZeroRead, asking a bar for its
+// version first when it has not reported one yet. Every other bar, and
+// firmware that refuses the read, yields a Calibration with nil Zeros and no
+// error: verify then compares factors only and Unchanged never skips the bar.
+func (l *Leo485) ReadCalibration(index int) (cal *Calibration, err error) {
+	if bus, j, ok := l.onBus(index); ok {
+		return bus.ReadCalibration(j)
+	}
+	if qerr := l.queue(l.prio, func() { cal, err = l.readCalibration(index) }); qerr != nil {
+		return nil, qerr
+	}
+	return cal, err
+}
+
+func (l *Leo485) readCalibration(index int) (*Calibration, error) {
+	factors, total, err := l.readFactors(index)
 	if err != nil {
-		return nil, fmt.Errorf("ReadFactors sendCommand error: %v", err)
+		return nil, err
 	}
-	if len(raw) < 6 {
-		return nil, fmt.Errorf("ReadFactors: response too short: %d bytes", len(raw))
+	cal := &Calibration{Factors: factors, TotalFactor: total}
+	if !l.readsZeros(index) {
+		return cal, nil
 	}
+	words, err := l.readWords(index, 'O')
+	if errors.Is(err, ErrNAK) {
+		return cal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ReadZeros: %w", err)
+	}
+	cal.ZeroTotal = uint64(words[0])
+	cal.Zeros = make([]uint64, len(words)-1)
+	for i, w := range words[1:] {
+		cal.Zeros[i] = uint64(w)
+	}
+	return cal, nil
+}
 
-	// find CRLF or LF
-	rnPos := bytes.Index(raw, []byte("\r\n"))
-	if rnPos == -1 {
-		rnPos = bytes.IndexByte(raw, '\n')
+// readsZeros reports whether bar index runs firmware in ZeroRead, asking it
+// for its version when it has not reported one since it last rebooted.
+func (l *Leo485) readsZeros(index int) bool {
+	if l.ZeroRead == nil {
+		return false
 	}
-	if rnPos == -1 {
-		return nil, fmt.Errorf("ReadFactors: no line terminator in response; len=%d", len(raw))
+	v, ok := l.versions.get(l.Bars[index].ID)
+	if !ok {
+		id, major, minor, err := l.getVersion(index)
+		if err != nil {
+			return false
+		}
+		v = models.VERSION{ID: id, MAJOR: major, MINOR: minor}
 	}
+	return CheckVersion(v, *l.ZeroRead) == ""
+}
 
-	// Validate ID bytes (first two bytes of response should match cmd[:2])
-	if len(raw) < 2 || raw[0] != cmd[0] || raw[1] != cmd[1] {
-		// provide a hex dump for diagnostics
-		hexParts := make([]string, 0, len(raw))
-		for _, b := range raw {
-			hexParts = append(hexParts, fmt.Sprintf("%02X", b))
+func (l *Leo485) readFactors(index int) ([]float64, float64, error) {
+	words, err := l.readWords(index, 'X')
+	if err != nil {
+		return nil, 0, fmt.Errorf("ReadFactors: %w", err)
+	}
+	factors := make([]float64, len(words)-1)
+	for i, w := range words[1:] {
+		factors[i] = float64(math.Float32frombits(w))
+	}
+	return factors, float64(math.Float32frombits(words[0])), nil
+}
+
+// readWords sends a bare read command and decodes its binary reply: a total
+// word followed by one word per active LC, all 4-byte big-endian.
+func (l *Leo485) readWords(index int, op byte) ([]uint32, error) {
+	cmd := GetCommand(l.Bars[index].ID, []byte{op})
+	// Send command and get raw bytes (no textual parsing)
+	raw, err := sendCommand(l.Serial, cmd, l.Timeouts.WithDefaults().Read)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 6 {
+		return nil, protoErr(ErrMalformed, cmd, raw, "response too short")
+	}
+	// A text reply ("ID|ERR") is a refusal; let checkData classify it.
+	if raw[2] == '|' {
+		if _, err := checkData(raw, cmd); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("ReadFactors GetData error: wrong ID or missing pipe; raw_len=%d raw_hex=%s", len(raw), strings.Join(hexParts, " "))
 	}
 
-	if rnPos < 2 {
-		return nil, fmt.Errorf("ReadFactors: response too short before CRC/terminator")
+	// find the trailing CRLF or LF; the binary payload and CRC may contain both
+	rnPos := terminatorIndex(raw)
+	if rnPos == -1 {
+		return nil, protoErr(ErrMalformed, cmd, raw, "no line terminator")
+	}
+
+	// Validate ID bytes (first two bytes of response should match cmd[:2])
+	if raw[0] != cmd[0] || raw[1] != cmd[1] {
+		return nil, protoErr(ErrWrongAddress, cmd, raw, fmt.Sprintf("reply from %q", raw[:2]))
 	}
 
 	// CRC is the two bytes immediately before CR/LF
-	if rnPos < 2 {
-		return nil, fmt.Errorf("ReadFactors: no CRC present")
+	if rnPos < 4 {
+		return nil, protoErr(ErrMalformed, cmd, raw, "no CRC present")
 	}
 	receivedCRC := raw[rnPos-2 : rnPos]
 	dataForCRC := raw[:rnPos-2]
 	calc := crc16(dataForCRC)
 	if receivedCRC[0] != calc[0] || receivedCRC[1] != calc[1] {
-		// hex dump for diagnostics
-		hexParts := make([]string, 0, len(raw))
-		for _, b := range raw {
-			hexParts = append(hexParts, fmt.Sprintf("%02X", b))
-		}
-		return nil, fmt.Errorf("ReadFactors CRC mismatch: expected=%02X%02X got=%02X%02X raw_hex=%s", calc[0], calc[1], receivedCRC[0], receivedCRC[1], strings.Join(hexParts, " "))
+		return nil, protoErr(ErrCRC, cmd, raw, fmt.Sprintf("expected=%02X%02X got=%02X%02X", calc[0], calc[1], receivedCRC[0], receivedCRC[1]))
 	}
 
 	// payload starts right after the 2-byte ID (no ASCII pipe expected for binary payloads)
 	payload := raw[2 : rnPos-2]
-	nlcs := l.NLCs
-	expected := 4 * (1 + nlcs) // total + each factor (4 bytes each)
+	nlcs := l.Bars[index].NLCs()
+	expected := 4 * (1 + nlcs) // total + one word per active LC
 	if len(payload) < expected {
-		return nil, fmt.Errorf("ReadFactors: payload too short: got %d, want %d", len(payload), expected)
-	}
-
-	ofs := 4 // skip totalFactor (first 4 bytes)
-	factors := make([]float64, nlcs)
-	for i := 0; i < nlcs; i++ {
-		if ofs+4 > len(payload) {
-			return nil, fmt.Errorf("ReadFactors: payload truncated for factor %d", i)
-		}
-		bits := binary.BigEndian.Uint32(payload[ofs : ofs+4])
-		f32 := math.Float32frombits(bits)
-		factors[i] = float64(f32)
-		ofs += 4
+		return nil, protoErr(ErrMalformed, cmd, raw, fmt.Sprintf("payload too short: got %d, want %d", len(payload), expected))
 	}
-	return factors, nil
-}
-
-func numOfActiveLCs(lcs byte) int {
-	count := 0
-	for i := 0; i < 8; i++ {
-		if (lcs & (1 << i)) != 0 {
-			count++
-		}
+	words := make([]uint32, 1+nlcs)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(payload[4*i:])
 	}
-	return count
+	return words, nil
 }
 
 // The lower-level serial helpers are implemented in com.go in this package.
@@ -0,0 +1,125 @@
+package serial_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// simBus connects a Leo485 to an in-memory simulated bus carrying bars, with
+// the zeros read enabled for the simulated firmware.
+func simBus(t testing.TB, bars []*models.BAR) (*serialpkg.Leo485, *simulator.Bus) {
+	t.Helper()
+	sim := simulator.NewFromParameters(&models.PARAMETERS{BARS: bars}, 1)
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	l, err := serialpkg.NewLeo485WithTransport(host, &models.SERIAL{COMMAND: "M"}, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	l.ZeroRead = &models.VERSIONRANGE{ID: 12009} // the simulator's firmware family
+	t.Cleanup(func() {
+		_ = l.Close()
+		_ = dev.Close()
+	})
+	return l, sim
+}
+
+func TestReadCalibrationRoundTrip(t *testing.T) {
+	bars := []*models.BAR{
+		{ID: 1, LCS: 3},
+		{ID: 2, LCS: 15},
+		{ID: 3, LCS: 5, SLOTS: 4},
+	}
+	l, _ := simBus(t, bars)
+	if err := l.OpenToUpdate(); err != nil {
+		t.Fatalf("OpenToUpdate: %v", err)
+	}
+	for i, bar := range bars {
+		n := bar.NLCs()
+		zeros := make([]float64, n)
+		factors := make([]float64, n)
+		for k := range zeros {
+			zeros[k] = float64(145000000 + 1000*i + k)
+			factors[k] = float64(float32(0.000123456789 * float64(k+1+i)))
+		}
+		total := uint64(17000 + i)
+		if !l.WriteZeros(i, zeros, total) {
+			t.Fatalf("bar %d: WriteZeros failed", i+1)
+		}
+		if !l.WriteFactors(i, factors) {
+			t.Fatalf("bar %d: WriteFactors failed", i+1)
+		}
+
+		cal, err := l.ReadCalibration(i)
+		if err != nil {
+			t.Fatalf("bar %d: ReadCalibration: %v", i+1, err)
+		}
+		if cal.ZeroTotal != total {
+			t.Errorf("bar %d: zero total %d, want %d", i+1, cal.ZeroTotal, total)
+		}
+		if len(cal.Zeros) != n || len(cal.Factors) != n {
+			t.Fatalf("bar %d: read %d zeros and %d factors, want %d", i+1, len(cal.Zeros), len(cal.Factors), n)
+		}
+		for k := 0; k < n; k++ {
+			if cal.Zeros[k] != uint64(zeros[k]) {
+				t.Errorf("bar %d LC %d: zero %d, want %.0f", i+1, k+1, cal.Zeros[k], zeros[k])
+			}
+			if got, want := math.Float32bits(float32(cal.Factors[k])), math.Float32bits(serialpkg.WireFactor(factors[k])); got != want {
+				t.Errorf("bar %d LC %d: factor %08X, want %08X", i+1, k+1, got, want)
+			}
+		}
+	}
+}
+
+func TestPayloadsNeedOneValuePerCell(t *testing.T) {
+	bar := &models.BAR{ID: 4, LCS: 5, SLOTS: 4}
+	z, err := serialpkg.ZerosPayload(bar, []float64{100, 200}, 7)
+	if err != nil {
+		t.Fatalf("ZerosPayload: %v", err)
+	}
+	if want := "O000000100|000000000|000000200|000000000|000000007|"; z != want {
+		t.Errorf("ZerosPayload = %q, want %q", z, want)
+	}
+	for _, n := range []int{1, 3} {
+		if _, err := serialpkg.ZerosPayload(bar, make([]float64, n), 0); err == nil {
+			t.Errorf("ZerosPayload accepted %d zeros for 2 cells", n)
+		}
+		if _, err := serialpkg.FactorsPayload(bar, make([]float64, n)); err == nil {
+			t.Errorf("FactorsPayload accepted %d factors for 2 cells", n)
+		}
+	}
+}
+
+func TestReadCalibrationZeroReadGate(t *testing.T) {
+	bars := []*models.BAR{{ID: 1, LCS: 3}}
+	tests := []struct {
+		name  string
+		gate  *models.VERSIONRANGE
+		zeros bool
+	}{
+		{"unset", nil, false},
+		{"other firmware", &models.VERSIONRANGE{ID: 12010}, false},
+		{"older firmware", &models.VERSIONRANGE{ID: 12009, MIN: &models.VERSION{MAJOR: 1, MINOR: 203}}, false},
+		{"listed firmware", &models.VERSIONRANGE{ID: 12009, MIN: &models.VERSION{MAJOR: 1, MINOR: 202}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := simBus(t, bars)
+			l.ZeroRead = tt.gate
+			cal, err := l.ReadCalibration(0)
+			if err != nil {
+				t.Fatalf("ReadCalibration: %v", err)
+			}
+			if got := cal.Zeros != nil; got != tt.zeros {
+				t.Errorf("zeros read = %v, want %v", got, tt.zeros)
+			}
+			if len(cal.Factors) != 2 {
+				t.Errorf("read %d factors, want 2", len(cal.Factors))
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LatencyBucketsMS are the upper bounds (ms) of the reply latency histogram;
+// BarLinkStats.Latency has one more bucket for slower replies.
+var LatencyBucketsMS = []int{5, 10, 20, 50, 100, 200, 500}
+
+// BarLinkStats counts the exchanges addressed to one bar. Every addressed
+// command is a request; each ends as exactly one of OK, Timeouts, CRCErrors,
+// WrongID, Malformed, NAKs or IOErrors.
+type BarLinkStats struct {
+	BarID        int     `json:"barId"`
+	Requests     int     `json:"requests"`
+	OK           int     `json:"ok"`
+	Timeouts     int     `json:"timeouts"`
+	CRCErrors    int     `json:"crcErrors"`
+	WrongID      int     `json:"wrongId"`
+	Malformed    int     `json:"malformed"`
+	NAKs         int     `json:"naks"`
+	IOErrors     int     `json:"ioErrors"`
+	Latency      []int   `json:"latency"` // replies per LatencyBucketsMS bucket
+	AvgLatencyMS float64 `json:"avgLatencyMs"`
+	MaxLatencyMS float64 `json:"maxLatencyMs"`
+
+	totalLatency time.Duration
+}
+
+// LinkReport is a snapshot of the link counters of every bar of a Leo485.
+type LinkReport struct {
+	Since            time.Time      `json:"since"`
+	LatencyBucketsMS []int          `json:"latencyBucketsMs"`
+	Bars             []BarLinkStats `json:"bars"`
+}
+
+// linkStats keeps the counters of one bus, keyed by bar ID.
+type linkStats struct {
+	mu    sync.Mutex
+	since time.Time
+	bars  map[int]*BarLinkStats
+}
+
+func newLinkStats() *linkStats {
+	return &linkStats{since: time.Now(), bars: make(map[int]*BarLinkStats)}
+}
+
+// record counts one exchange with bar id that took d and ended with err.
+// Only replies that arrived (err nil or a bad frame) enter the latency
+// histogram.
+func (s *linkStats) record(id int, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.bars[id]
+	if b == nil {
+		b = &BarLinkStats{BarID: id, Latency: make([]int, len(LatencyBucketsMS)+1)}
+		s.bars[id] = b
+	}
+	b.Requests++
+	switch ErrorClass(err) {
+	case "":
+		b.OK++
+	case "timeout":
+		b.Timeouts++
+		return
+	case "crc":
+		b.CRCErrors++
+	case "address":
+		b.WrongID++
+	case "malformed":
+		b.Malformed++
+	case "nak":
+		b.NAKs++
+	default:
+		b.IOErrors++
+		return
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	k := 0
+	for k < len(LatencyBucketsMS) && ms > float64(LatencyBucketsMS[k]) {
+		k++
+	}
+	b.Latency[k]++
+	b.totalLatency += d
+	if ms > b.MaxLatencyMS {
+		b.MaxLatencyMS = ms
+	}
+}
+
+// get returns a copy of the counters of bar id, zero if it was never addressed.
+func (s *linkStats) get(id int) BarLinkStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.bars[id]
+	if !ok {
+		return BarLinkStats{BarID: id, Latency: make([]int, len(LatencyBucketsMS)+1)}
+	}
+	out := *b
+	out.Latency = append([]int(nil), b.Latency...)
+	if n := replies(b); n > 0 {
+		out.AvgLatencyMS = float64(b.totalLatency) / float64(time.Millisecond) / float64(n)
+	}
+	return out
+}
+
+func replies(b *BarLinkStats) int {
+	n := 0
+	for _, c := range b.Latency {
+		n += c
+	}
+	return n
+}
+
+func (s *linkStats) reset() {
+	s.mu.Lock()
+	s.since = time.Now()
+	s.bars = make(map[int]*BarLinkStats)
+	s.mu.Unlock()
+}
+
+func (s *linkStats) started() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.since
+}
+
+// statsTransport counts every addressed exchange made through it, including
+// the raw frames flash and firmware code sends on Leo485.Serial.
+type statsTransport struct {
+	Transport
+	stats *linkStats
+}
+
+// linkStatsOf returns the counters attached to t, or nil.
+func linkStatsOf(t Transport) *linkStats {
+	if st, ok := t.(*statsTransport); ok {
+		return st.stats
+	}
+	return nil
+}
+
+// replyError is the outcome of an exchange for the link counters: err when
+// the reply did not arrive, else the verdict of checkData on a text frame.
+// Binary read-back replies carry no pipe, so only their address and CRC are
+// checked.
+func replyError(data, cmd []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(data) > 2 && data[2] == '|' {
+		_, err := checkData(data, cmd)
+		return err
+	}
+	end := terminatorIndex(data)
+	switch {
+	case end < 4:
+		return protoErr(ErrMalformed, cmd, data, "wrong format")
+	case data[0] != cmd[0] || data[1] != cmd[1]:
+		return protoErr(ErrWrongAddress, cmd, data, fmt.Sprintf("reply from %q", data[:2]))
+	case !bytes.Equal(crc16(data[:end-2]), data[end-2:end]):
+		return protoErr(ErrCRC, cmd, data, "")
+	}
+	return nil
+}
+
+// LinkReport returns the link counters of every bar, in Bars order.
+func (l *Leo485) LinkReport() LinkReport {
+	out := LinkReport{
+		LatencyBucketsMS: append([]int(nil), LatencyBucketsMS...),
+		Bars:             make([]BarLinkStats, len(l.Bars)),
+	}
+	for i, bar := range l.Bars {
+		bus, _ := l.Bus(i)
+		out.Bars[i] = bus.stats.get(bar.ID)
+		if since := bus.stats.started(); out.Since.IsZero() || since.Before(out.Since) {
+			out.Since = since
+		}
+	}
+	return out
+}
+
+// ResetLinkStats clears the link counters of every bus.
+func (l *Leo485) ResetLinkStats() {
+	for _, bus := range l.Buses() {
+		bus.stats.reset()
+	}
+}
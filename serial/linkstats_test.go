@@ -0,0 +1,97 @@
+package serial
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+// answerFlaky replies to Version frames read from dev: bar 1 answers
+// cleanly, bar 2 with a broken CRC, bar 3 not at all and bar 4 under the
+// address of bar 5.
+func answerFlaky(dev Transport) {
+	buf := make([]byte, 64)
+	var frame []byte
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, c := range buf[:n] {
+			frame = append(frame, c)
+			if c != '\r' {
+				continue
+			}
+			id, _ := ParseAddress(frame)
+			frame = frame[:0]
+			switch id {
+			case 1:
+				_, _ = dev.Write(reply("01", "Leo485 Version 12009.1.202"))
+			case 2:
+				corrupt := reply("02", "Leo485 Version 12009.1.202")
+				corrupt[len(corrupt)-3] ^= 0xFF
+				_, _ = dev.Write(corrupt)
+			case 4:
+				_, _ = dev.Write(reply("05", "Leo485 Version 12009.1.202"))
+			}
+		}
+	}
+}
+
+func TestLinkStatsCounters(t *testing.T) {
+	host, dev := NewPipe()
+	defer dev.Close()
+	go answerFlaky(dev)
+	bars := []*models.BAR{{ID: 1, LCS: 3}, {ID: 2, LCS: 3}, {ID: 3, LCS: 3}, {ID: 4, LCS: 3}}
+	l, err := NewLeo485WithTransport(host, &models.SERIAL{COMMAND: "M"}, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	defer l.Close()
+
+	for _, id := range []int{1, 1, 2, 3, 4} {
+		_, _ = SendCommand(l.Serial, GetCommand(id, []byte("V")), 50)
+	}
+
+	report := l.LinkReport()
+	if len(report.Bars) != len(bars) {
+		t.Fatalf("%d bars in report, want %d", len(report.Bars), len(bars))
+	}
+	want := []BarLinkStats{
+		{BarID: 1, Requests: 2, OK: 2},
+		{BarID: 2, Requests: 1, CRCErrors: 1},
+		{BarID: 3, Requests: 1, Timeouts: 1},
+		{BarID: 4, Requests: 1, WrongID: 1},
+	}
+	for i, w := range want {
+		got := report.Bars[i]
+		if got.BarID != w.BarID || got.Requests != w.Requests || got.OK != w.OK ||
+			got.CRCErrors != w.CRCErrors || got.Timeouts != w.Timeouts || got.WrongID != w.WrongID ||
+			got.Malformed != 0 || got.NAKs != 0 || got.IOErrors != 0 {
+			t.Errorf("bar %d: %+v, want %+v", w.BarID, got, w)
+		}
+		// Every reply that arrived lands in one latency bucket; a timeout
+		// does not.
+		if n := replies(&got); n != w.Requests-w.Timeouts {
+			t.Errorf("bar %d: %d replies in the latency histogram, want %d", w.BarID, n, w.Requests-w.Timeouts)
+		}
+	}
+	if b := report.Bars[0]; b.AvgLatencyMS <= 0 || b.MaxLatencyMS < b.AvgLatencyMS {
+		t.Errorf("bar 1 latency avg %.3fms max %.3fms", b.AvgLatencyMS, b.MaxLatencyMS)
+	}
+
+	before := report.Since
+	time.Sleep(time.Millisecond)
+	l.ResetLinkStats()
+	report = l.LinkReport()
+	if !report.Since.After(before) {
+		t.Errorf("reset kept since %v", report.Since)
+	}
+	for _, b := range report.Bars {
+		if b.Requests != 0 || b.OK != 0 || b.CRCErrors != 0 || b.Timeouts != 0 || b.WrongID != 0 ||
+			replies(&b) != 0 || b.MaxLatencyMS != 0 {
+			t.Errorf("bar %d after reset: %+v", b.BarID, b)
+		}
+	}
+}
@@ -1,41 +1,241 @@
 package serial
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/CK6170/Calrunrilla-go/models"
-	"github.com/tarm/serial"
 )
 
-// AutoDetectPort scans common COM ports to find one responding to a Version command.
+// PortCandidate is a port/baud combination that answered the version probe.
+type PortCandidate struct {
+	Port    string `json:"port"`
+	Baud    int    `json:"baud"`
+	Version string `json:"version"`
+	BarIDs  []int  `json:"barIds"` // bar addresses that answered
+}
+
+// StandardBauds are the rates tried by a baud sweep, most common first.
+var StandardBauds = []int{115200, 57600, 38400, 19200, 9600}
+
+// DetectOptions tunes DetectPortsWithOptions.
+type DetectOptions struct {
+	Workers int   // concurrent port probes (default 8)
+	Sweep   bool  // also try StandardBauds after the configured rate
+	Bauds   []int // explicit rates to try; overrides Sweep
+	BarIDs  []int // addresses to query; default is every configured bar
+}
+
+// AutoDetectPort returns the first port responding to a Version command, or "".
 func AutoDetectPort(parameters *models.PARAMETERS) string {
-	expectedFirstBarID := parameters.BARS[0].ID
-	baud := parameters.SERIAL.BAUDRATE
-	// Scan COM1..COM64
-	for i := 1; i <= 64; i++ {
-		portName := fmt.Sprintf("COM%d", i)
-		if TestPort(portName, expectedFirstBarID, baud) {
-			return portName
+	found := DetectPorts(parameters)
+	if len(found) == 0 {
+		return ""
+	}
+	return found[0].Port
+}
+
+// DetectPorts probes every candidate port at SERIAL.BAUDRATE with a Version
+// command to each bar configured on SERIAL and returns all that answered.
+func DetectPorts(parameters *models.PARAMETERS) []PortCandidate {
+	return DetectPortsWithOptions(parameters, DetectOptions{})
+}
+
+// DetectPortsWithOptions probes candidate ports concurrently with a bounded
+// worker pool. Each port tries its baud rates in order and stops at the first
+// rate where any bar answered; a rate where the first bar stays silent is left
+// without querying the rest. Results keep the CandidatePorts order.
+func DetectPortsWithOptions(parameters *models.PARAMETERS, opts DetectOptions) []PortCandidate {
+	bauds := opts.Bauds
+	if len(bauds) == 0 {
+		bauds = []int{parameters.SERIAL.BAUDRATE}
+		if opts.Sweep {
+			for _, b := range StandardBauds {
+				if b != parameters.SERIAL.BAUDRATE {
+					bauds = append(bauds, b)
+				}
+			}
+		}
+	}
+	ids := opts.BarIDs
+	if len(ids) == 0 {
+		// Only bars wired to SERIAL itself; bars on other BUSES never answer
+		// on its port.
+		for _, b := range parameters.BARS {
+			if b.BUS == "" || b.BUS == parameters.SERIAL.NAME {
+				ids = append(ids, b.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		for _, b := range parameters.BARS {
+			ids = append(ids, b.ID)
 		}
 	}
-	return ""
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 8
+	}
+
+	ports := CandidatePorts()
+	results := make([]*PortCandidate, len(ports))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(ports); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				for _, baud := range bauds {
+					probe := *parameters.SERIAL
+					probe.PORT, probe.BAUDRATE, probe.CAPTURE = ports[i], baud, ""
+					version, answered, err := probeBars(&probe, ids)
+					if err != nil {
+						// Port cannot be opened at all; other rates will not help.
+						break
+					}
+					if len(answered) > 0 {
+						results[i] = &PortCandidate{Port: ports[i], Baud: baud, Version: version, BarIDs: answered}
+						break
+					}
+				}
+			}
+		}()
+	}
+	for i := range ports {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	out := make([]PortCandidate, 0)
+	for _, r := range results {
+		if r != nil {
+			out = append(out, *r)
+		}
+	}
+	return out
 }
 
-// TestPort tries to open port and issue a version command to first bar ID.
-func TestPort(name string, barID int, baud int) bool {
-	config := &serial.Config{Name: name, Baud: baud, Parity: serial.ParityNone, Size: 8, StopBits: serial.Stop1, ReadTimeout: time.Millisecond * 300}
-	sp, err := serial.OpenPort(config)
+// probeBars opens the serial port of ser and sends a Version command to every
+// id (see probeVersions). err is set only when the port cannot be opened.
+func probeBars(ser *models.SERIAL, ids []int) (string, []int, error) {
+	sp, err := OpenSerial(ser)
 	if err != nil {
-		return false
+		return "", nil, err
+	}
+	defer func() { _ = sp.Close() }()
+	version, answered := probeVersions(sp, ids, TimeoutsFor(ser).Version)
+	return version, answered, nil
+}
+
+// probeSilentIDs is how many ids in a row may time out with not a single
+// byte received, before any bar has answered, until probeVersions decides
+// nothing is listening at this port and rate.
+const probeSilentIDs = 3
+
+// probeVersions sends a Version command to every id on t and returns the
+// first version text and the ids that answered. Until some bar has answered,
+// probeSilentIDs consecutive timeouts with not a single byte received end the
+// probe: nothing is listening at this port and rate, and waiting out every
+// other id would only slow the sweep down. A single missing bar, even the
+// first one, does not.
+func probeVersions(t Transport, ids []int, timeout int) (string, []int) {
+	version := ""
+	answered := make([]int, 0)
+	silent := 0
+	for _, id := range ids {
+		resp, err := GetData(t, GetCommand(id, []byte("V")), timeout)
+		if err != nil || !strings.Contains(resp, "Version") {
+			var pe *ProtocolError
+			if errors.As(err, &pe) && errors.Is(err, ErrTimeout) && len(pe.Frame) == 0 {
+				silent++
+			} else {
+				silent = 0
+			}
+			if len(answered) == 0 && silent >= probeSilentIDs {
+				break
+			}
+			continue
+		}
+		silent = 0
+		if version == "" {
+			version = strings.TrimSpace(resp)
+		}
+		answered = append(answered, id)
+	}
+	return version, answered
+}
+
+// CandidatePorts lists the serial device names worth probing on this OS:
+// COM1..COM64 on Windows, USB/ACM adapters (preferring stable /dev/serial/by-id
+// links) on Linux and /dev/cu.* on macOS.
+func CandidatePorts() []string {
+	switch runtime.GOOS {
+	case "windows":
+		ports := make([]string, 0, 64)
+		for i := 1; i <= 64; i++ {
+			ports = append(ports, fmt.Sprintf("COM%d", i))
+		}
+		return ports
+	case "darwin":
+		return globPorts("/dev/cu.*")
+	default:
+		return globPorts("/dev/serial/by-id/*", "/dev/ttyUSB*", "/dev/ttyACM*")
+	}
+}
+
+// globPorts expands patterns in order and drops entries that resolve to a
+// device node already listed, so a by-id link hides its /dev/ttyUSBn target.
+func globPorts(patterns ...string) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0)
+	for _, pat := range patterns {
+		matches, _ := filepath.Glob(pat)
+		sort.Strings(matches)
+		for _, m := range matches {
+			real, err := filepath.EvalSymlinks(m)
+			if err != nil {
+				real = m
+			}
+			if seen[real] {
+				continue
+			}
+			seen[real] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// TestPort tries to open the serial port of ser and issue a version command to
+// barID.
+func TestPort(ser *models.SERIAL, barID int) bool {
+	_, err := ProbePort(ser, barID)
+	return err == nil
+}
+
+// ProbePort opens the serial port of ser, sends a Version command to barID and
+// returns the version text the bar answered with.
+func ProbePort(ser *models.SERIAL, barID int) (string, error) {
+	sp, err := OpenSerial(ser)
+	if err != nil {
+		return "", err
 	}
 	defer func() { _ = sp.Close() }()
 
 	cmd := GetCommand(barID, []byte("V"))
-	resp, err := GetData(sp, cmd, 200)
+	resp, err := GetData(sp, cmd, TimeoutsFor(ser).Version)
 	if err != nil {
-		return false
+		return "", err
+	}
+	if !strings.Contains(resp, "Version") {
+		return "", fmt.Errorf("no version in response %q", resp)
 	}
-	return strings.Contains(resp, "Version")
+	return strings.TrimSpace(resp), nil
 }
@@ -0,0 +1,137 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// countingTransport counts the frames written through it.
+type countingTransport struct {
+	Transport
+	writes int
+}
+
+func (c *countingTransport) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Transport.Write(p)
+}
+
+func TestProbeVersionsSilentPort(t *testing.T) {
+	host, _ := NewPipe()
+	defer host.Close()
+	ct := &countingTransport{Transport: host}
+	start := time.Now()
+	version, answered := probeVersions(ct, []int{1, 2, 3, 4, 5}, 50)
+	if version != "" || len(answered) != 0 {
+		t.Fatalf("silent port answered %q %v", version, answered)
+	}
+	if ct.writes != probeSilentIDs {
+		t.Errorf("%d probes sent to a silent port, want %d", ct.writes, probeSilentIDs)
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Errorf("silent port took %v", d)
+	}
+}
+
+// answerVersions replies to every Version frame read from dev, except those
+// addressed to an id in missing.
+func answerVersions(dev Transport, missing ...int) {
+	buf := make([]byte, 64)
+	var frame []byte
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, c := range buf[:n] {
+			frame = append(frame, c)
+			if c != '\r' {
+				continue
+			}
+			if id, ok := ParseAddress(frame); ok && !slices.Contains(missing, id) {
+				_, _ = dev.Write(reply(string(frame[:2]), "Leo485 Version 12009.1.202"))
+			}
+			frame = frame[:0]
+		}
+	}
+}
+
+func TestProbeVersionsSkipsMissingBar(t *testing.T) {
+	host, dev := NewPipe()
+	defer host.Close()
+	// Bar 1 answers; bar 2 is absent; bar 3 answers.
+	go answerVersions(dev, 2)
+	version, answered := probeVersions(host, []int{1, 2, 3}, 50)
+	if version != "Leo485 Version 12009.1.202" {
+		t.Errorf("version %q", version)
+	}
+	if len(answered) != 2 || answered[0] != 1 || answered[1] != 3 {
+		t.Errorf("answered %v, want [1 3]", answered)
+	}
+}
+
+func TestProbeVersionsFirstBarMissing(t *testing.T) {
+	host, dev := NewPipe()
+	defer host.Close()
+	// Bar 0 is absent; the rest of the shelf answers.
+	go answerVersions(dev, 0)
+	version, answered := probeVersions(host, []int{0, 1, 2}, 50)
+	if version != "Leo485 Version 12009.1.202" {
+		t.Errorf("version %q", version)
+	}
+	if len(answered) != 2 || answered[0] != 1 || answered[1] != 2 {
+		t.Errorf("answered %v, want [1 2]", answered)
+	}
+}
+
+func TestGlobPorts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ttyUSB1", "ttyUSB0", "ttyACM0", "ttyS0"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	byID := filepath.Join(dir, "by-id")
+	if err := os.Mkdir(byID, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "ttyUSB1"), filepath.Join(byID, "usb-FTDI_FT232R-if00")); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+
+	// The by-id link hides ttyUSB1, each pattern's matches come sorted, and
+	// a repeated pattern adds nothing.
+	got := globPorts(filepath.Join(byID, "*"), filepath.Join(dir, "ttyUSB*"), filepath.Join(dir, "ttyACM*"), filepath.Join(dir, "ttyUSB*"))
+	want := []string{
+		filepath.Join(byID, "usb-FTDI_FT232R-if00"),
+		filepath.Join(dir, "ttyUSB0"),
+		filepath.Join(dir, "ttyACM0"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("globPorts = %v, want %v", got, want)
+	}
+	if got := globPorts(filepath.Join(dir, "nothing*")); got == nil || len(got) != 0 {
+		t.Errorf("no match gives %#v, want an empty list", got)
+	}
+}
+
+func TestCandidatePorts(t *testing.T) {
+	ports := CandidatePorts()
+	if runtime.GOOS == "windows" {
+		if len(ports) != 64 || ports[0] != "COM1" || ports[63] != "COM64" {
+			t.Errorf("CandidatePorts = %v, want COM1..COM64", ports)
+		}
+		return
+	}
+	seen := make(map[string]bool)
+	for _, p := range ports {
+		if seen[p] {
+			t.Errorf("%s listed twice", p)
+		}
+		seen[p] = true
+	}
+}
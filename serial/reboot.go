@@ -0,0 +1,55 @@
+package serial
+
+import (
+	"fmt"
+	"time"
+)
+
+// RebootResult is the outcome of rebooting one bar and waiting for it to come
+// back.
+type RebootResult struct {
+	Bar    int     `json:"bar"` // 1-based, as shown to the operator
+	ID     int     `json:"id"`
+	OK     bool    `json:"ok"`
+	BootMS float64 `json:"bootMs"` // from the R frame until the bar answered V
+	Error  string  `json:"error,omitempty"`
+}
+
+// RebootAndWait reboots the bars at positions sel (nil for all) and polls
+// each with 'V' until it answers or timeout has passed since its reboot. All
+// R frames go out first, so the bars boot together; a bar that does not
+// acknowledge R is not waited for. Results are in sel order.
+func (l *Leo485) RebootAndWait(sel []int, timeout time.Duration) []RebootResult {
+	if sel == nil {
+		for i := range l.Bars {
+			sel = append(sel, i)
+		}
+	}
+	out := make([]RebootResult, len(sel))
+	sent := make([]time.Time, len(sel))
+	var pending []int
+	for k, i := range sel {
+		out[k] = RebootResult{Bar: i + 1, ID: l.Bars[i].ID}
+		sent[k] = time.Now()
+		if !l.Reboot(i) {
+			out[k].Error = fmt.Sprintf("bar %d did not acknowledge reboot", i+1)
+			continue
+		}
+		pending = append(pending, k)
+	}
+	idx := make([]int, len(pending))
+	since := make([]time.Time, len(pending))
+	for n, k := range pending {
+		idx[n], since[n] = sel[k], sent[k]
+	}
+	took, errs := l.pollReady(idx, since, timeout)
+	for n, k := range pending {
+		out[k].BootMS = float64(took[n]) / float64(time.Millisecond)
+		if errs[n] != nil {
+			out[k].Error = errs[n].Error()
+		} else {
+			out[k].OK = true
+		}
+	}
+	return out
+}
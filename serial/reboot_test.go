@@ -0,0 +1,36 @@
+package serial_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+func TestRebootAndWait(t *testing.T) {
+	l, sim := simBus(t, []*models.BAR{{ID: 1, LCS: 3}, {ID: 2, LCS: 15}})
+
+	sim.BootTime = 200 * time.Millisecond
+	for _, r := range l.RebootAndWait(nil, 2*time.Second) {
+		if !r.OK {
+			t.Errorf("bar %d: %s", r.Bar, r.Error)
+		}
+		if r.BootMS < 200 {
+			t.Errorf("bar %d ready after %.0fms, before its %v boot", r.Bar, r.BootMS, sim.BootTime)
+		}
+		if updating, _ := sim.UpdateMode(r.ID); updating {
+			t.Errorf("bar %d still in update mode", r.Bar)
+		}
+	}
+
+	// A bar that boots for longer than the timeout is reported, not waited for.
+	sim.BootTime = 2 * time.Second
+	start := time.Now()
+	res := l.RebootAndWait([]int{1}, 300*time.Millisecond)
+	if len(res) != 1 || res[0].OK || res[0].Error == "" || res[0].ID != 2 {
+		t.Errorf("slow boot gave %+v, want a failure for bar ID 2", res)
+	}
+	if took := time.Since(start); took > 1500*time.Millisecond {
+		t.Errorf("RebootAndWait took %v with a 300ms timeout", took)
+	}
+}
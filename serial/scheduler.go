@@ -0,0 +1,204 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority orders requests waiting for the bus. Higher values run first;
+// requests of equal priority run in arrival order.
+type Priority int
+
+const (
+	PriorityBackground Priority = iota // live polling (test loop, ADC preview)
+	PriorityNormal                     // calibration sampling and one-off reads
+	PriorityAdmin                      // flash, zero collection, reboot
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBackground:
+		return "background"
+	case PriorityNormal:
+		return "normal"
+	case PriorityAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrBusClosed is returned for requests made after the Leo485 was closed.
+var ErrBusClosed = errors.New("bus closed")
+
+// ErrRequestPanicked is returned for a request whose function panicked. The
+// scheduler survives the panic and goes on with the next request.
+var ErrRequestPanicked = errors.New("bus request panicked")
+
+// BusStats is a snapshot of the scheduler counters.
+type BusStats struct {
+	QueueDepth int            `json:"queueDepth"` // requests waiting, all priorities
+	ByPriority map[string]int `json:"byPriority"` // requests waiting per priority
+	InFlight   bool           `json:"inFlight"`   // a request currently owns the bus
+	Completed  uint64         `json:"completed"`  // requests run since open
+	LastWaitMS float64        `json:"lastWaitMs"` // queue wait of the latest request
+	AvgWaitMS  float64        `json:"avgWaitMs"`  // mean queue wait
+	MaxWaitMS  float64        `json:"maxWaitMs"`  // longest queue wait
+	MaxDepth   int            `json:"maxDepth"`   // deepest queue seen
+	LastPrio   string         `json:"lastPriority,omitempty"`
+}
+
+type busJob struct {
+	prio     Priority
+	fn       func()
+	enqueued time.Time
+	done     chan struct{}
+	err      error // set when fn panicked
+}
+
+// scheduler owns the transport: a single goroutine runs queued jobs one at a
+// time, so only one frame (or multi-frame sequence) is ever on the wire.
+type scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  [numPriorities][]*busJob
+	closed  bool
+	stopped chan struct{}
+
+	inFlight  bool
+	completed uint64
+	totalWait time.Duration
+	lastWait  time.Duration
+	maxWait   time.Duration
+	maxDepth  int
+	lastPrio  Priority
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{stopped: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func (s *scheduler) depthLocked() int {
+	n := 0
+	for _, q := range s.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func (s *scheduler) run() {
+	defer close(s.stopped)
+	for {
+		s.mu.Lock()
+		for s.depthLocked() == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.depthLocked() == 0 {
+			s.mu.Unlock()
+			return
+		}
+		var job *busJob
+		for p := numPriorities - 1; p >= 0; p-- {
+			if len(s.queues[p]) > 0 {
+				job = s.queues[p][0]
+				s.queues[p] = s.queues[p][1:]
+				break
+			}
+		}
+		wait := time.Since(job.enqueued)
+		s.inFlight = true
+		s.lastWait = wait
+		s.totalWait += wait
+		if wait > s.maxWait {
+			s.maxWait = wait
+		}
+		s.lastPrio = job.prio
+		s.mu.Unlock()
+
+		job.err = runJob(job.fn)
+
+		s.mu.Lock()
+		s.inFlight = false
+		s.completed++
+		s.mu.Unlock()
+		close(job.done)
+	}
+}
+
+// runJob calls fn and turns a panic into an ErrRequestPanicked error, so one
+// faulty request cannot take down the scheduler and every caller with it.
+func runJob(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrRequestPanicked, r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// do queues fn at prio and blocks until it has run. Requests already queued
+// when the scheduler closes still run; later ones get ErrBusClosed. A panic in
+// fn is returned as an ErrRequestPanicked error.
+func (s *scheduler) do(prio Priority, fn func()) error {
+	if prio < 0 || prio >= numPriorities {
+		prio = PriorityNormal
+	}
+	job := &busJob{prio: prio, fn: fn, enqueued: time.Now(), done: make(chan struct{})}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrBusClosed
+	}
+	s.queues[prio] = append(s.queues[prio], job)
+	if d := s.depthLocked(); d > s.maxDepth {
+		s.maxDepth = d
+	}
+	s.cond.Signal()
+	s.mu.Unlock()
+	<-job.done
+	return job.err
+}
+
+// close stops accepting requests and waits for the queue to drain.
+func (s *scheduler) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+	<-s.stopped
+}
+
+func (s *scheduler) stats() BusStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := BusStats{
+		QueueDepth: s.depthLocked(),
+		ByPriority: make(map[string]int, numPriorities),
+		InFlight:   s.inFlight,
+		Completed:  s.completed,
+		LastWaitMS: ms(s.lastWait),
+		MaxWaitMS:  ms(s.maxWait),
+		MaxDepth:   s.maxDepth,
+	}
+	for p := Priority(0); p < numPriorities; p++ {
+		st.ByPriority[p.String()] = len(s.queues[p])
+	}
+	if s.completed > 0 {
+		st.AvgWaitMS = ms(s.totalWait) / float64(s.completed)
+		st.LastPrio = s.lastPrio.String()
+	}
+	return st
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
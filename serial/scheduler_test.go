@@ -0,0 +1,168 @@
+package serial
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+)
+
+// pipeLeo485 returns a Leo485 on an in-memory pipe nothing answers on.
+func pipeLeo485(t *testing.T) *Leo485 {
+	t.Helper()
+	host, _ := NewPipe()
+	l, err := NewLeo485WithTransport(host, &models.SERIAL{COMMAND: "M"}, []*models.BAR{{ID: 1, LCS: 3}})
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+// holdBus occupies the bus of l until the returned function is called.
+func holdBus(t *testing.T, l *Leo485) (release func()) {
+	t.Helper()
+	held, hold := make(chan struct{}), make(chan struct{})
+	go func() { _ = l.queue(PriorityAdmin, func() { close(held); <-hold }) }()
+	<-held
+	return func() { close(hold) }
+}
+
+// waitQueued waits until n requests are waiting on the bus of l.
+func waitQueued(t *testing.T, l *Leo485, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for l.BusStats().QueueDepth < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", l.BusStats().QueueDepth, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// orderLog records the order in which queued requests ran.
+type orderLog struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (o *orderLog) add(name string) func() {
+	return func() {
+		o.mu.Lock()
+		o.ran = append(o.ran, name)
+		o.mu.Unlock()
+	}
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	l := pipeLeo485(t)
+	release := holdBus(t, l)
+
+	var order orderLog
+	var wg sync.WaitGroup
+	queue := []struct {
+		name string
+		prio Priority
+	}{
+		{"background 1", PriorityBackground},
+		{"normal 1", PriorityNormal},
+		{"background 2", PriorityBackground},
+		{"admin", PriorityAdmin},
+		{"normal 2", PriorityNormal},
+	}
+	for k, q := range queue {
+		wg.Add(1)
+		go func(name string, prio Priority) {
+			defer wg.Done()
+			_ = l.queue(prio, order.add(name))
+		}(q.name, q.prio)
+		waitQueued(t, l, k+1)
+	}
+	release()
+	wg.Wait()
+
+	want := []string{"admin", "normal 1", "normal 2", "background 1", "background 2"}
+	for i := range want {
+		if i >= len(order.ran) || order.ran[i] != want[i] {
+			t.Fatalf("ran %v, want %v", order.ran, want)
+		}
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	l := pipeLeo485(t)
+	release := holdBus(t, l)
+
+	var order orderLog
+	var wg sync.WaitGroup
+	for k, v := range []*Leo485{l.WithPriority(PriorityBackground), l, l.WithPriority(PriorityAdmin)} {
+		wg.Add(1)
+		go func(v *Leo485) {
+			defer wg.Done()
+			_ = v.queue(v.prio, order.add(v.prio.String()))
+		}(v)
+		waitQueued(t, l, k+1)
+	}
+	release()
+	wg.Wait()
+
+	want := []string{"admin", "normal", "background"}
+	for i := range want {
+		if i >= len(order.ran) || order.ran[i] != want[i] {
+			t.Fatalf("ran %v, want %v", order.ran, want)
+		}
+	}
+	if l.prio != PriorityNormal {
+		t.Errorf("WithPriority changed the priority of l to %s", l.prio)
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	l := pipeLeo485(t)
+
+	var order orderLog
+	inside := make(chan struct{})
+	other := make(chan struct{})
+	go func() {
+		<-inside
+		_ = l.queue(PriorityAdmin, order.add("other"))
+		close(other)
+	}()
+	err := l.Exclusive(PriorityNormal, func(bus *Leo485) error {
+		close(inside)
+		waitQueued(t, l, 1)
+		// Methods of the view talk to the bus directly instead of queueing
+		// behind the request that holds it.
+		bus.Drain(1)
+		order.add("exclusive")()
+		return errors.New("done")
+	})
+	<-other
+	if err == nil || err.Error() != "done" {
+		t.Errorf("Exclusive returned %v, want the error of fn", err)
+	}
+	if len(order.ran) != 2 || order.ran[0] != "exclusive" {
+		t.Errorf("ran %v, want exclusive before other", order.ran)
+	}
+}
+
+func TestSchedulerSurvivesPanic(t *testing.T) {
+	l := pipeLeo485(t)
+	err := l.queue(PriorityNormal, func() { panic("boom") })
+	if !errors.Is(err, ErrRequestPanicked) {
+		t.Fatalf("panicking request returned %v, want ErrRequestPanicked", err)
+	}
+	if IsLinkLost(err) {
+		t.Error("a panicking request counts as a lost link")
+	}
+	err = l.Exclusive(PriorityAdmin, func(*Leo485) error { panic("boom") })
+	if !errors.Is(err, ErrRequestPanicked) {
+		t.Fatalf("panicking Exclusive returned %v, want ErrRequestPanicked", err)
+	}
+	ran := false
+	if err := l.queue(PriorityNormal, func() { ran = true }); err != nil || !ran {
+		t.Fatalf("request after a panic: ran=%v err=%v", ran, err)
+	}
+}
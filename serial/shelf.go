@@ -0,0 +1,361 @@
+package serial
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+)
+
+// A shelf whose bars are split across several RS-485 segments is driven by one
+// Leo485 that joins a single-bus Leo485 per segment. Bar indexes stay
+// positions in BARS, so NLCs, Offset and every per-bar method address the
+// whole shelf; each call is routed to the bus its bar is wired to, and
+// separate buses never wait for each other.
+
+// ShelfBus is one bus of a shelf configuration.
+type ShelfBus struct {
+	Serial *models.SERIAL // settings of the bus, gaps filled in from SERIAL
+	Bars   []int          // positions in BARS of the bars wired to it
+}
+
+// busBar locates a shelf bar: its bus and its index on that bus.
+type busBar struct {
+	bus   int
+	index int
+}
+
+// ShelfBuses splits p.BARS by BAR.BUS. Bars without BUS, or naming
+// SERIAL.NAME, are on SERIAL; every BUSES entry needs a NAME and takes the
+// settings it leaves empty from SERIAL. Buses without bars are left out.
+func ShelfBuses(p *models.PARAMETERS) ([]ShelfBus, error) {
+	if p.SERIAL == nil {
+		return nil, fmt.Errorf("missing SERIAL")
+	}
+	sers := []*models.SERIAL{p.SERIAL}
+	byName := map[string]int{"": 0, p.SERIAL.NAME: 0}
+	for _, b := range p.BUSES {
+		if b == nil || b.NAME == "" {
+			return nil, fmt.Errorf("every BUSES entry needs a NAME")
+		}
+		if _, dup := byName[b.NAME]; dup {
+			return nil, fmt.Errorf("duplicate bus name %q", b.NAME)
+		}
+		byName[b.NAME] = len(sers)
+		sers = append(sers, inheritSerial(b, p.SERIAL))
+	}
+	bars := make([][]int, len(sers))
+	for i, bar := range p.BARS {
+		k, ok := byName[bar.BUS]
+		if !ok {
+			return nil, fmt.Errorf("bar %d (ID %d): unknown BUS %q", i+1, bar.ID, bar.BUS)
+		}
+		bars[k] = append(bars[k], i)
+	}
+	out := make([]ShelfBus, 0, len(sers))
+	ports := make(map[string]string)
+	for k, ser := range sers {
+		if len(bars[k]) == 0 {
+			continue
+		}
+		if other, dup := ports[ser.PORT]; dup && ser.PORT != "" {
+			return nil, fmt.Errorf("buses %s and %s share PORT %s", other, BusName(ser), ser.PORT)
+		}
+		ports[ser.PORT] = BusName(ser)
+		out = append(out, ShelfBus{Serial: ser, Bars: bars[k]})
+	}
+	return out, nil
+}
+
+// inheritSerial copies bus and fills the settings it leaves empty from base.
+// An inherited CAPTURE gets a subdirectory named after the bus, so every bus
+// records into its own directory.
+func inheritSerial(bus, base *models.SERIAL) *models.SERIAL {
+	s := *bus
+	if s.BAUDRATE == 0 {
+		s.BAUDRATE = base.BAUDRATE
+	}
+	if s.COMMAND == "" {
+		s.COMMAND = base.COMMAND
+	}
+	if s.CAPTURE == "" && base.CAPTURE != "" {
+		s.CAPTURE = filepath.Join(base.CAPTURE, fileSafe(s.NAME))
+	}
+	if s.DATABITS == 0 {
+		s.DATABITS = base.DATABITS
+	}
+	if s.PARITY == "" {
+		s.PARITY = base.PARITY
+	}
+	if s.STOPBITS == 0 {
+		s.STOPBITS = base.STOPBITS
+	}
+	if s.TIMEOUTS == nil {
+		s.TIMEOUTS = base.TIMEOUTS
+	}
+	return &s
+}
+
+// BusName names a bus for messages: its NAME, or SERIAL for an unnamed one.
+func BusName(ser *models.SERIAL) string {
+	if ser == nil || ser.NAME == "" {
+		return "SERIAL"
+	}
+	return ser.NAME
+}
+
+// OpenShelf opens every bus of p concurrently and returns one Leo485 over all
+// of p.BARS. A config without BUSES gives a plain single-bus Leo485. Every bus
+// takes its ZeroRead from p.ZEROREAD.
+func OpenShelf(p *models.PARAMETERS) (*Leo485, error) {
+	if len(p.BARS) == 0 {
+		return nil, fmt.Errorf("no BARS configured")
+	}
+	buses, err := ShelfBuses(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range buses {
+		if b.Serial.PORT == "" {
+			return nil, fmt.Errorf("missing PORT for bus %s", BusName(b.Serial))
+		}
+	}
+	if len(buses) == 1 {
+		l, err := openBus(buses[0].Serial, p.BARS)
+		if err != nil {
+			return nil, err
+		}
+		l.ZeroRead = p.ZEROREAD
+		return l, nil
+	}
+
+	links := make([]*Leo485, len(buses))
+	errs := make([]error, len(buses))
+	var wg sync.WaitGroup
+	for k, b := range buses {
+		sub := make([]*models.BAR, len(b.Bars))
+		for j, i := range b.Bars {
+			sub[j] = p.BARS[i]
+		}
+		wg.Add(1)
+		go func(k int, ser *models.SERIAL, sub []*models.BAR) {
+			defer wg.Done()
+			links[k], errs[k] = openBus(ser, sub)
+		}(k, b.Serial, sub)
+	}
+	wg.Wait()
+	for k, err := range errs {
+		if err != nil {
+			for _, l := range links {
+				if l != nil {
+					_ = l.Close()
+				}
+			}
+			return nil, fmt.Errorf("bus %s: %w", BusName(buses[k].Serial), err)
+		}
+	}
+	for _, b := range links {
+		b.ZeroRead = p.ZEROREAD
+	}
+	l, err := JoinBuses(p.BARS, links)
+	if err != nil {
+		for _, b := range links {
+			_ = b.Close()
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewShelf is OpenShelf for the CLI: it exits on error like NewLeo485.
+func NewShelf(p *models.PARAMETERS) *Leo485 {
+	l, err := OpenShelf(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return l
+}
+
+func openBus(ser *models.SERIAL, bars []*models.BAR) (*Leo485, error) {
+	t, err := OpenTransport(ser)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewLeo485WithTransport(t, ser, bars)
+	if err != nil {
+		_ = t.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// JoinBuses builds a shelf over bars from already opened single-bus Leo485s
+// that together hold exactly those bars. The shelf takes ownership of buses.
+func JoinBuses(bars []*models.BAR, buses []*Leo485) (*Leo485, error) {
+	if len(buses) == 0 {
+		return nil, fmt.Errorf("no buses")
+	}
+	if err := ValidateBars(bars); err != nil {
+		return nil, err
+	}
+	pos := make(map[*models.BAR]int, len(bars))
+	for i, b := range bars {
+		pos[b] = i
+	}
+	route := make([]busBar, len(bars))
+	seen := make([]bool, len(bars))
+	for k, b := range buses {
+		if b.buses != nil {
+			return nil, fmt.Errorf("bus %d is itself a shelf", k+1)
+		}
+		for j, bar := range b.Bars {
+			i, ok := pos[bar]
+			if !ok || seen[i] {
+				return nil, fmt.Errorf("bar ID %d on bus %s is not a shelf bar", bar.ID, BusName(b.SerialConfig))
+			}
+			seen[i] = true
+			route[i] = busBar{bus: k, index: j}
+		}
+	}
+	for i, ok := range seen {
+		if !ok {
+			return nil, fmt.Errorf("bar %d (ID %d) is on no bus", i+1, bars[i].ID)
+		}
+	}
+	l := &Leo485{
+		Bars:         bars,
+		SerialConfig: buses[0].SerialConfig,
+		Timeouts:     buses[0].Timeouts,
+		prio:         PriorityNormal,
+		buses:        buses,
+		route:        route,
+	}
+	l.NLCs = make([]int, len(bars))
+	for i, bar := range bars {
+		l.NLCs[i] = bar.NLCs()
+	}
+	return l, nil
+}
+
+// onBus resolves bar index on a shelf of several buses to its bus and its
+// index there; ok is false for a single-bus Leo485.
+func (l *Leo485) onBus(index int) (bus *Leo485, j int, ok bool) {
+	if l.buses == nil {
+		return nil, 0, false
+	}
+	r := l.route[index]
+	return l.buses[r.bus], r.index, true
+}
+
+// Bus returns the single-bus Leo485 bar index is wired to and the bar's index
+// on it; for a single bus that is l and index. Raw frames for a bar must go
+// to that bus's Serial.
+func (l *Leo485) Bus(index int) (*Leo485, int) {
+	if b, j, ok := l.onBus(index); ok {
+		return b, j
+	}
+	return l, index
+}
+
+// Buses returns the single-bus Leo485 of every bus, or just l.
+func (l *Leo485) Buses() []*Leo485 {
+	if l.buses == nil {
+		return []*Leo485{l}
+	}
+	return l.buses
+}
+
+// EachBar calls fn for every bar index. Bars on one bus are visited in order;
+// separate buses are visited concurrently, so fn must be safe to run in
+// parallel for bars on different buses.
+func (l *Leo485) EachBar(fn func(i int)) {
+	if l.buses == nil {
+		for i := range l.Bars {
+			fn(i)
+		}
+		return
+	}
+	perBus := make([][]int, len(l.buses))
+	for i, r := range l.route {
+		perBus[r.bus] = append(perBus[r.bus], i)
+	}
+	var wg sync.WaitGroup
+	for _, idx := range perBus {
+		wg.Add(1)
+		go func(idx []int) {
+			defer wg.Done()
+			for _, i := range idx {
+				fn(i)
+			}
+		}(idx)
+	}
+	wg.Wait()
+}
+
+// GetAllADs reads every bar once, all buses in parallel, and returns each
+// bar's values and error in bar order. timeoutMS <= 0 uses each bus's
+// Measure timeout.
+func (l *Leo485) GetAllADs(timeoutMS int) ([][]uint64, []error) {
+	vals := make([][]uint64, len(l.Bars))
+	errs := make([]error, len(l.Bars))
+	l.EachBar(func(i int) {
+		if timeoutMS > 0 {
+			vals[i], errs[i] = l.GetADsWithTimeout(i, timeoutMS)
+		} else {
+			vals[i], errs[i] = l.GetADs(i)
+		}
+	})
+	return vals, errs
+}
+
+// exclusiveAll holds every bus of a shelf, always in the same order so two
+// shelf-wide holders cannot deadlock, and runs fn on unscheduled views.
+func (l *Leo485) exclusiveAll(prio Priority, fn func(bus *Leo485) error) error {
+	views := make([]*Leo485, len(l.buses))
+	var hold func(k int) error
+	hold = func(k int) error {
+		if k == len(l.buses) {
+			v := *l
+			v.buses = views
+			return fn(&v)
+		}
+		return l.buses[k].Exclusive(prio, func(bus *Leo485) error {
+			views[k] = bus
+			return hold(k + 1)
+		})
+	}
+	return hold(0)
+}
+
+// mergeStats sums the scheduler counters of several buses.
+func mergeStats(stats []BusStats) BusStats {
+	out := BusStats{ByPriority: make(map[string]int, numPriorities)}
+	var wait float64
+	for _, st := range stats {
+		out.QueueDepth += st.QueueDepth
+		for k, v := range st.ByPriority {
+			out.ByPriority[k] += v
+		}
+		out.InFlight = out.InFlight || st.InFlight
+		out.Completed += st.Completed
+		wait += st.AvgWaitMS * float64(st.Completed)
+		if st.LastWaitMS > out.LastWaitMS {
+			out.LastWaitMS = st.LastWaitMS
+		}
+		if st.MaxWaitMS > out.MaxWaitMS {
+			out.MaxWaitMS = st.MaxWaitMS
+		}
+		if st.MaxDepth > out.MaxDepth {
+			out.MaxDepth = st.MaxDepth
+		}
+		if out.LastPrio == "" {
+			out.LastPrio = st.LastPrio
+		}
+	}
+	if out.Completed > 0 {
+		out.AvgWaitMS = wait / float64(out.Completed)
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package serial_test
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// twoBusShelf is a shelf of four bars: IDs 1 and 2 on SERIAL, 3 and 4 on
+// "right", listed interleaved so shelf and bus indexes differ.
+func twoBusShelf() *models.PARAMETERS {
+	return &models.PARAMETERS{
+		SERIAL: &models.SERIAL{PORT: "left0", BAUDRATE: 115200, COMMAND: "M", CAPTURE: "captures"},
+		BUSES:  []*models.SERIAL{{NAME: "right", PORT: "right0"}},
+		BARS: []*models.BAR{
+			{ID: 1, LCS: 3},
+			{ID: 3, LCS: 15, BUS: "right"},
+			{ID: 2, LCS: 7},
+			{ID: 4, LCS: 3, BUS: "right"},
+		},
+	}
+}
+
+func TestShelfBuses(t *testing.T) {
+	p := twoBusShelf()
+	buses, err := serialpkg.ShelfBuses(p)
+	if err != nil {
+		t.Fatalf("ShelfBuses: %v", err)
+	}
+	if len(buses) != 2 {
+		t.Fatalf("%d buses, want 2", len(buses))
+	}
+	left, right := buses[0], buses[1]
+	if left.Serial != p.SERIAL || len(left.Bars) != 2 || left.Bars[0] != 0 || left.Bars[1] != 2 {
+		t.Errorf("SERIAL bus holds %v, want [0 2]", left.Bars)
+	}
+	if len(right.Bars) != 2 || right.Bars[0] != 1 || right.Bars[1] != 3 {
+		t.Errorf("right bus holds %v, want [1 3]", right.Bars)
+	}
+	if right.Serial.BAUDRATE != 115200 || right.Serial.COMMAND != "M" {
+		t.Errorf("right bus did not inherit from SERIAL: %+v", right.Serial)
+	}
+	if want := filepath.Join("captures", "right"); right.Serial.CAPTURE != want {
+		t.Errorf("right bus captures to %q, want %q", right.Serial.CAPTURE, want)
+	}
+	if p.BUSES[0].CAPTURE != "" {
+		t.Error("ShelfBuses changed the BUSES entry")
+	}
+
+	p.BUSES[0].PORT = "left0"
+	if _, err := serialpkg.ShelfBuses(p); err == nil {
+		t.Error("two buses on one PORT accepted")
+	}
+	p = twoBusShelf()
+	p.BARS[1].BUS = "middle"
+	if _, err := serialpkg.ShelfBuses(p); err == nil {
+		t.Error("unknown BUS accepted")
+	}
+}
+
+// pipeBus serves bars on a simulated bus behind an in-memory pipe.
+func pipeBus(t *testing.T, ser *models.SERIAL, bars []*models.BAR) *serialpkg.Leo485 {
+	t.Helper()
+	sim := simulator.NewFromParameters(&models.PARAMETERS{BARS: bars}, 1)
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	t.Cleanup(func() { _ = dev.Close() })
+	l, err := serialpkg.NewLeo485WithTransport(host, ser, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	return l
+}
+
+func TestJoinBusesRouting(t *testing.T) {
+	p := twoBusShelf()
+	buses, err := serialpkg.ShelfBuses(p)
+	if err != nil {
+		t.Fatalf("ShelfBuses: %v", err)
+	}
+	links := make([]*serialpkg.Leo485, len(buses))
+	for k, b := range buses {
+		sub := make([]*models.BAR, len(b.Bars))
+		for j, i := range b.Bars {
+			sub[j] = p.BARS[i]
+		}
+		links[k] = pipeBus(t, b.Serial, sub)
+	}
+	if _, err := serialpkg.JoinBuses(p.BARS, links[:1]); err == nil {
+		t.Error("JoinBuses accepted a shelf with bars on no bus")
+	}
+	shelf, err := serialpkg.JoinBuses(p.BARS, links)
+	if err != nil {
+		t.Fatalf("JoinBuses: %v", err)
+	}
+	defer shelf.Close()
+
+	for i, bar := range p.BARS {
+		bus, j := shelf.Bus(i)
+		if bus.Bars[j] != bar {
+			t.Errorf("bar %d routed to bar ID %d", i+1, bus.Bars[j].ID)
+		}
+	}
+
+	// Every bar answers through the shelf; a bar routed to the wrong bus
+	// would time out, as the simulator there does not have it.
+	var mu sync.Mutex
+	visited := make(map[int]int)
+	shelf.EachBar(func(i int) {
+		ads, err := shelf.GetADs(i)
+		mu.Lock()
+		defer mu.Unlock()
+		visited[i]++
+		if err != nil {
+			t.Errorf("bar %d (ID %d): GetADs: %v", i+1, p.BARS[i].ID, err)
+		} else if len(ads) != p.BARS[i].NLCs() {
+			t.Errorf("bar %d: %d values, want %d", i+1, len(ads), p.BARS[i].NLCs())
+		}
+	})
+	for i := range p.BARS {
+		if visited[i] != 1 {
+			t.Errorf("EachBar visited bar %d %d times", i+1, visited[i])
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	goserial "github.com/tarm/serial"
+)
+
+// Transport is the byte link a Leo485 bus is reached through.
+//
+// Read must return (0, nil) when no byte arrived within the transport's poll
+// window so callers can keep their own overall deadline; SetReadDeadline bounds
+// a single Read where the underlying link supports it.
+type Transport interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// TCPPrefix selects the raw TCP transport when used as SERIAL.PORT,
+// e.g. "tcp://192.168.1.50:4001" for an RS-485-to-Ethernet gateway.
+const TCPPrefix = "tcp://"
+
+// OpenTransport opens the link described by ser: a TCP gateway when PORT starts
+// with TCPPrefix, a recorded capture when it starts with ReplayPrefix,
+// otherwise a local serial port. A non-empty CAPTURE directory records every
+// frame to a new capture file named after the time and the bus.
+func OpenTransport(ser *models.SERIAL) (Transport, error) {
+	if ser == nil {
+		return nil, fmt.Errorf("missing SERIAL")
+	}
+	var (
+		t   Transport
+		err error
+	)
+	switch {
+	case strings.HasPrefix(ser.PORT, TCPPrefix):
+		t, err = OpenTCP(strings.TrimPrefix(ser.PORT, TCPPrefix), 2*time.Second)
+	case strings.HasPrefix(ser.PORT, ReplayPrefix):
+		t, err = OpenReplay(strings.TrimPrefix(ser.PORT, ReplayPrefix))
+	default:
+		t, err = OpenSerial(ser)
+	}
+	if err != nil || ser.CAPTURE == "" {
+		return t, err
+	}
+	f, err := CreateCaptureFile(ser.CAPTURE, BusName(ser))
+	if err != nil {
+		_ = t.Close()
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	return NewCaptureTransport(t, f), nil
+}
+
+// serialTransport adapts a tarm serial port. The port's ReadTimeout acts as
+// the poll window; deadlines are enforced by the caller's read loop.
+type serialTransport struct {
+	port *goserial.Port
+}
+
+// OpenSerial opens the local serial port ser.PORT at ser.BAUDRATE with the
+// framing from DATABITS, PARITY and STOPBITS (8N1 when unset).
+func OpenSerial(ser *models.SERIAL) (Transport, error) {
+	config, err := lineConfig(ser)
+	if err != nil {
+		return nil, err
+	}
+	port, err := goserial.OpenPort(config)
+	if err != nil {
+		return nil, err
+	}
+	return &serialTransport{port: port}, nil
+}
+
+// lineConfig validates the framing of ser and builds the port config.
+func lineConfig(ser *models.SERIAL) (*goserial.Config, error) {
+	size := ser.DATABITS
+	if size == 0 {
+		size = 8
+	}
+	if size < 5 || size > 8 {
+		return nil, fmt.Errorf("invalid SERIAL.DATABITS %d: want 5-8", ser.DATABITS)
+	}
+	parity := goserial.ParityNone
+	switch strings.ToUpper(ser.PARITY) {
+	case "", "N", "NONE":
+	case "E", "EVEN":
+		parity = goserial.ParityEven
+	case "O", "ODD":
+		parity = goserial.ParityOdd
+	case "M", "MARK":
+		parity = goserial.ParityMark
+	case "S", "SPACE":
+		parity = goserial.ParitySpace
+	default:
+		return nil, fmt.Errorf("invalid SERIAL.PARITY %q: want N, E, O, M or S", ser.PARITY)
+	}
+	// The serial driver sets mark and space only through the Windows DCB; on
+	// POSIX the open fails with a bare "unsupported parity setting".
+	if (parity == goserial.ParityMark || parity == goserial.ParitySpace) && runtime.GOOS != "windows" {
+		return nil, fmt.Errorf("SERIAL.PARITY %q (mark/space) is only supported on Windows: use N, E or O", ser.PARITY)
+	}
+	stop := goserial.Stop1
+	switch ser.STOPBITS {
+	case 0, 1:
+	case 2:
+		stop = goserial.Stop2
+	default:
+		return nil, fmt.Errorf("invalid SERIAL.STOPBITS %d: want 1 or 2", ser.STOPBITS)
+	}
+	// ReadTimeout is a short poll window: Read returns as soon as bytes arrive,
+	// and the caller's deadline is overshot by at most one window (POSIX
+	// rounds it up to 100ms).
+	return &goserial.Config{
+		Name:        ser.PORT,
+		Baud:        ser.BAUDRATE,
+		Parity:      parity,
+		Size:        byte(size),
+		StopBits:    stop,
+		ReadTimeout: time.Millisecond * 50,
+	}, nil
+}
+
+func (s *serialTransport) Read(p []byte) (int, error) {
+	n, err := s.port.Read(p)
+	// On POSIX an expired VTIME read surfaces as io.EOF with no data.
+	if n == 0 && err == io.EOF {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *serialTransport) Write(p []byte) (int, error) { return s.port.Write(p) }
+
+func (s *serialTransport) SetReadDeadline(t time.Time) error { return nil }
+
+func (s *serialTransport) Close() error { return s.port.Close() }
+
+// tcpTransport talks to a transparent RS-485-to-Ethernet gateway.
+type tcpTransport struct {
+	conn net.Conn
+}
+
+// OpenTCP dials a raw TCP gateway at addr (host:port).
+func OpenTCP(addr string, dialTimeout time.Duration) (Transport, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTransport{conn: conn}, nil
+}
+
+func (c *tcpTransport) Read(p []byte) (int, error) {
+	n, err := c.conn.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, nil
+	}
+	return n, err
+}
+
+func (c *tcpTransport) Write(p []byte) (int, error) { return c.conn.Write(p) }
+
+func (c *tcpTransport) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+func (c *tcpTransport) Close() error { return c.conn.Close() }
+
+// pipeEnd is one side of an in-memory, buffered, full-duplex pipe.
+type pipeEnd struct {
+	rx    *pipeBuffer
+	tx    *pipeBuffer
+	mu    sync.Mutex
+	ddl   time.Time
+	close sync.Once
+}
+
+type pipeBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// NewPipe returns two connected in-memory transports. Bytes written to one end
+// are read from the other; writes never block. Intended for tests and the
+// in-process simulator.
+func NewPipe() (Transport, Transport) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeEnd{rx: a, tx: b}, &pipeEnd{rx: b, tx: a}
+}
+
+func (p *pipeEnd) Read(out []byte) (int, error) {
+	p.mu.Lock()
+	ddl := p.ddl
+	p.mu.Unlock()
+
+	b := p.rx
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.buf) == 0 && !b.closed {
+		// Wake up once the deadline (or a short poll window) passes.
+		wait := 10 * time.Millisecond
+		if !ddl.IsZero() {
+			if d := time.Until(ddl); d < wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			t := time.AfterFunc(wait, func() {
+				b.mu.Lock()
+				b.cond.Broadcast()
+				b.mu.Unlock()
+			})
+			b.cond.Wait()
+			t.Stop()
+		}
+	}
+	if len(b.buf) == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		return 0, nil
+	}
+	n := copy(out, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (p *pipeEnd) Write(data []byte) (int, error) {
+	b := p.tx
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.buf = append(b.buf, data...)
+	b.cond.Broadcast()
+	return len(data), nil
+}
+
+func (p *pipeEnd) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.ddl = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *pipeEnd) Close() error {
+	p.close.Do(func() {
+		for _, b := range []*pipeBuffer{p.rx, p.tx} {
+			b.mu.Lock()
+			b.closed = true
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,43 @@
+package serial
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	goserial "github.com/tarm/serial"
+)
+
+func TestLineConfigParity(t *testing.T) {
+	tests := []struct {
+		parity  string
+		want    goserial.Parity
+		windows bool // only accepted on Windows
+	}{
+		{"", goserial.ParityNone, false},
+		{"n", goserial.ParityNone, false},
+		{"E", goserial.ParityEven, false},
+		{"ODD", goserial.ParityOdd, false},
+		{"M", goserial.ParityMark, true},
+		{"space", goserial.ParitySpace, true},
+	}
+	for _, tt := range tests {
+		cfg, err := lineConfig(&models.SERIAL{PORT: "COM1", BAUDRATE: 115200, PARITY: tt.parity})
+		if tt.windows && runtime.GOOS != "windows" {
+			if err == nil {
+				t.Errorf("PARITY %q accepted on %s", tt.parity, runtime.GOOS)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PARITY %q: %v", tt.parity, err)
+			continue
+		}
+		if cfg.Parity != tt.want {
+			t.Errorf("PARITY %q gives %q, want %q", tt.parity, cfg.Parity, tt.want)
+		}
+	}
+	if _, err := lineConfig(&models.SERIAL{PARITY: "X"}); err == nil {
+		t.Error("PARITY \"X\" accepted")
+	}
+}
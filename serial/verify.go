@@ -0,0 +1,141 @@
+package serial
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+)
+
+// readyPoll is the pause between V polls while a bar boots.
+const readyPoll = 100 * time.Millisecond
+
+// WaitReady polls a bar with 'V' until it answers or timeout elapses and
+// returns how long that took. Each poll is a separate bus request, so other
+// traffic keeps flowing while the bar boots.
+func (l *Leo485) WaitReady(index int, timeout time.Duration) (time.Duration, error) {
+	took, errs := l.pollReady([]int{index}, []time.Time{time.Now()}, timeout)
+	return took[0], errs[0]
+}
+
+// pollReady polls the bars at positions sel with 'V' until each answers or
+// timeout has passed since its entry in since, and returns per bar how long
+// after since it answered and, for one that never did, the error. Buses are
+// polled in parallel; on each bus the waiting bars are polled in turn, one
+// round every readyPoll starting one readyPoll in, so each time is measured
+// to within one round.
+func (l *Leo485) pollReady(sel []int, since []time.Time, timeout time.Duration) ([]time.Duration, []error) {
+	took := make([]time.Duration, len(sel))
+	errs := make([]error, len(sel))
+	byBus := make(map[*Leo485][]int)
+	for k, i := range sel {
+		bus, _ := l.Bus(i)
+		byBus[bus] = append(byBus[bus], k)
+	}
+	var wg sync.WaitGroup
+	for _, ks := range byBus {
+		wg.Add(1)
+		go func(pending []int) {
+			defer wg.Done()
+			for len(pending) > 0 {
+				time.Sleep(readyPoll)
+				next := pending[:0]
+				for _, k := range pending {
+					_, _, _, err := l.GetVersion(sel[k])
+					took[k] = time.Since(since[k])
+					switch {
+					case err == nil:
+					case took[k] >= timeout:
+						errs[k] = fmt.Errorf("bar %d not ready after %v: %w", sel[k]+1, timeout.Round(time.Millisecond), err)
+					default:
+						next = append(next, k)
+					}
+				}
+				pending = next
+			}
+		}(ks)
+	}
+	wg.Wait()
+	return took, errs
+}
+
+// VerifyResult is the outcome of reading a bar's calibration back after a
+// flash. Zeros is false when the firmware cannot report zeros, in which case
+// only the factors were compared.
+type VerifyResult struct {
+	Bar        int      `json:"bar"` // 1-based, as shown to the operator
+	ID         int      `json:"id"`
+	OK         bool     `json:"ok"`
+	Zeros      bool     `json:"zeros"`
+	Mismatches []string `json:"mismatches,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// VerifyCalibration reads a bar's stored calibration back and compares it
+// with lcs: factors bit-for-bit against their wire-rounded IEEE values, zeros
+// exactly when the firmware reports them. After a flash the bar must have
+// rebooted and answered 'V' first; see RebootAndWait.
+func (l *Leo485) VerifyCalibration(index int, lcs []*models.LC) VerifyResult {
+	res := VerifyResult{Bar: index + 1, ID: l.Bars[index].ID}
+	cal, err := l.ReadCalibration(index)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Zeros = cal.Zeros != nil
+	res.Mismatches = CompareCalibration(cal, lcs)
+	res.OK = len(res.Mismatches) == 0
+	return res
+}
+
+// Unchanged reports whether a bar already holds lcs, zero total included, so
+// a flash can skip it. Without read-back zeros nothing can be proven, so a bar
+// whose firmware does not report them is never unchanged.
+func Unchanged(cal *Calibration, lcs []*models.LC) bool {
+	if cal.Zeros == nil {
+		return false
+	}
+	if total, _ := ZeroTotal(lcs); cal.ZeroTotal != total {
+		return false
+	}
+	return len(CompareCalibration(cal, lcs)) == 0
+}
+
+// ZerosUnavailable names why zeros were not read back from a bar under the
+// zero-read range zr, for telling the operator why it could not be checked.
+func ZerosUnavailable(zr *models.VERSIONRANGE) string {
+	if zr == nil {
+		return "ZEROREAD disabled"
+	}
+	return "firmware not listed in ZEROREAD"
+}
+
+// CompareCalibration lists the differences between a read-back calibration
+// and the expected calibrated-file entries. Factors compare by IEEE bits
+// against the WireFactor of the entry's IEEE value, or of FACTOR when an
+// entry has no valid IEEE string.
+func CompareCalibration(cal *Calibration, lcs []*models.LC) []string {
+	var diffs []string
+	if len(cal.Factors) != len(lcs) {
+		return append(diffs, fmt.Sprintf("%d factors read, %d expected", len(cal.Factors), len(lcs)))
+	}
+	for i, lc := range lcs {
+		factor := lc.FACTOR
+		if bits, err := strconv.ParseUint(strings.TrimSpace(lc.IEEE), 16, 32); err == nil {
+			factor = math.Float32frombits(uint32(bits))
+		}
+		want := fmt.Sprintf("%08X", math.Float32bits(WireFactor(float64(factor))))
+		got := fmt.Sprintf("%08X", math.Float32bits(float32(cal.Factors[i])))
+		if got != want {
+			diffs = append(diffs, fmt.Sprintf("LC %d factor %s, expected %s", i+1, got, want))
+		}
+		if i < len(cal.Zeros) && cal.Zeros[i] != lc.ZERO {
+			diffs = append(diffs, fmt.Sprintf("LC %d zero %d, expected %d", i+1, cal.Zeros[i], lc.ZERO))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,102 @@
+package serial
+
+import (
+	"fmt"
+
+	models "github.com/CK6170/Calrunrilla-go/models"
+)
+
+// VersionCheck is one bar's firmware version and whether it is allowed.
+type VersionCheck struct {
+	Bar        int    `json:"bar"` // 1-based, as shown to the operator
+	ID         int    `json:"id"`
+	Version    string `json:"version,omitempty"` // "<id>.<major>.<minor>"; empty when the bar did not answer
+	Compatible bool   `json:"compatible"`
+	Reason     string `json:"reason,omitempty"` // why an answering bar is not compatible
+	Error      string `json:"error,omitempty"`
+
+	Firmware *models.VERSION `json:"-"` // parsed Version, nil when the bar did not answer
+}
+
+// AllowedVersions is the firmware range the bars of p must fall in: VERSIONS
+// when set, otherwise the firmware family of VERSION (any major and minor of
+// its ID). Without either, every version is allowed.
+func AllowedVersions(p *models.PARAMETERS) models.VERSIONRANGE {
+	if p.VERSIONS != nil {
+		return *p.VERSIONS
+	}
+	if p.VERSION != nil {
+		return models.VERSIONRANGE{ID: p.VERSION.ID}
+	}
+	return models.VERSIONRANGE{}
+}
+
+// CheckVersion reports why v is outside allowed, or "" when it is allowed.
+func CheckVersion(v models.VERSION, allowed models.VERSIONRANGE) string {
+	switch {
+	case allowed.ID != 0 && v.ID != allowed.ID:
+		return fmt.Sprintf("firmware ID %d, expected %d", v.ID, allowed.ID)
+	case allowed.MIN != nil && compareVersion(v, *allowed.MIN) < 0:
+		return fmt.Sprintf("older than %d.%d", allowed.MIN.MAJOR, allowed.MIN.MINOR)
+	case allowed.MAX != nil && compareVersion(v, *allowed.MAX) > 0:
+		return fmt.Sprintf("newer than %d.%d", allowed.MAX.MAJOR, allowed.MAX.MINOR)
+	}
+	return ""
+}
+
+// compareVersion orders a and b by MAJOR, then MINOR.
+func compareVersion(a, b models.VERSION) int {
+	switch {
+	case a.MAJOR != b.MAJOR:
+		return a.MAJOR - b.MAJOR
+	default:
+		return a.MINOR - b.MINOR
+	}
+}
+
+// CheckVersions asks every bar for its version (buses in parallel) and judges
+// each against allowed. A bar that does not answer is not compatible.
+func (l *Leo485) CheckVersions(allowed models.VERSIONRANGE) []VersionCheck {
+	out := make([]VersionCheck, len(l.Bars))
+	l.EachBar(func(i int) {
+		res := VersionCheck{Bar: i + 1, ID: l.Bars[i].ID}
+		id, major, minor, err := l.GetVersion(i)
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Firmware = &models.VERSION{ID: id, MAJOR: major, MINOR: minor}
+			res.Version = FormatVersion(*res.Firmware)
+			res.Reason = CheckVersion(*res.Firmware, allowed)
+			res.Compatible = res.Reason == ""
+		}
+		out[i] = res
+	})
+	return out
+}
+
+// MixedVersions reports whether the bars that answered run different
+// firmware versions.
+func MixedVersions(checks []VersionCheck) bool {
+	first := ""
+	for _, c := range checks {
+		if c.Version == "" {
+			continue
+		}
+		if first == "" {
+			first = c.Version
+		} else if c.Version != first {
+			return true
+		}
+	}
+	return false
+}
+
+// Compatible reports whether every bar answered with an allowed version.
+func Compatible(checks []VersionCheck) bool {
+	for _, c := range checks {
+		if !c.Compatible {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package serial_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+func TestAllowedVersions(t *testing.T) {
+	rng := &models.VERSIONRANGE{ID: 12009, MIN: &models.VERSION{MAJOR: 1, MINOR: 200}}
+	tests := []struct {
+		name string
+		p    models.PARAMETERS
+		want models.VERSIONRANGE
+	}{
+		{"neither allows all", models.PARAMETERS{}, models.VERSIONRANGE{}},
+		{"VERSION gives its family", models.PARAMETERS{VERSION: &models.VERSION{ID: 12009, MAJOR: 1, MINOR: 202}}, models.VERSIONRANGE{ID: 12009}},
+		{"VERSIONS", models.PARAMETERS{VERSIONS: rng}, *rng},
+		{"VERSIONS wins over VERSION", models.PARAMETERS{VERSION: &models.VERSION{ID: 12010}, VERSIONS: rng}, *rng},
+	}
+	for _, tt := range tests {
+		if got := serialpkg.AllowedVersions(&tt.p); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVersions(t *testing.T) {
+	// Bar ID 3 is configured but absent from the bus.
+	sim := simulator.New(simulator.Config{Seed: 1})
+	sim.AddBar(1, 3, 0, 20, 0.01, models.VERSION{ID: 12009, MAJOR: 1, MINOR: 202})
+	sim.AddBar(2, 3, 0, 20, 0.01, models.VERSION{ID: 12009, MAJOR: 2, MINOR: 5})
+	sim.AddBar(4, 3, 0, 20, 0.01, models.VERSION{ID: 12010, MAJOR: 1, MINOR: 0})
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	bars := []*models.BAR{{ID: 1, LCS: 3}, {ID: 2, LCS: 3}, {ID: 3, LCS: 3}, {ID: 4, LCS: 3}}
+	l, err := serialpkg.NewLeo485WithTransport(host, &models.SERIAL{COMMAND: "M"}, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+		_ = dev.Close()
+	})
+
+	versions := []string{"12009.1.202", "12009.2.5", "", "12010.1.0"}
+	tests := []struct {
+		name    string
+		allowed models.VERSIONRANGE
+		reasons []string // per bar; "" means compatible
+	}{
+		{"empty range allows all", models.VERSIONRANGE{}, []string{"", "", "", ""}},
+		{"family", models.VERSIONRANGE{ID: 12009}, []string{"", "", "", "firmware ID 12010, expected 12009"}},
+		{"minimum", models.VERSIONRANGE{ID: 12009, MIN: &models.VERSION{MAJOR: 1, MINOR: 300}},
+			[]string{"older than 1.300", "", "", "firmware ID 12010, expected 12009"}},
+		{"maximum", models.VERSIONRANGE{MAX: &models.VERSION{MAJOR: 1, MINOR: 250}},
+			[]string{"", "newer than 1.250", "", ""}},
+	}
+	for _, tt := range tests {
+		checks := l.CheckVersions(tt.allowed)
+		if len(checks) != len(bars) {
+			t.Fatalf("%s: %d checks, want %d", tt.name, len(checks), len(bars))
+		}
+		for i, c := range checks {
+			if c.Bar != i+1 || c.ID != bars[i].ID || c.Version != versions[i] {
+				t.Errorf("%s: bar %d: %+v, want ID %d version %q", tt.name, i+1, c, bars[i].ID, versions[i])
+			}
+			if bars[i].ID == 3 {
+				// A bar that does not answer is never compatible.
+				if c.Compatible || c.Error == "" || c.Firmware != nil {
+					t.Errorf("%s: missing bar: %+v", tt.name, c)
+				}
+				continue
+			}
+			if c.Reason != tt.reasons[i] || c.Compatible != (tt.reasons[i] == "") || c.Error != "" {
+				t.Errorf("%s: bar %d: compatible %v reason %q, want reason %q", tt.name, i+1, c.Compatible, c.Reason, tt.reasons[i])
+			}
+		}
+		if serialpkg.Compatible(checks) {
+			t.Errorf("%s: shelf with a missing bar reported compatible", tt.name)
+		}
+		if !serialpkg.MixedVersions(checks) {
+			t.Errorf("%s: mixed firmware not reported", tt.name)
+		}
+	}
+}
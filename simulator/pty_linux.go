@@ -0,0 +1,69 @@
+//go:build linux
+
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// PTY is a pseudo-terminal whose slave end looks like a serial port to the
+// CLI and server while the simulator answers on the master end.
+type PTY struct {
+	Path   string
+	master *os.File
+	slave  *os.File
+}
+
+// OpenPTY allocates a pseudo-terminal in raw mode.
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("unlockpt: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("ptsname: %w", err)
+	}
+	path := fmt.Sprintf("/dev/pts/%d", n)
+	// Keep a slave descriptor open for the lifetime of the PTY so the master
+	// does not see EIO while no client has the port open.
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, err
+	}
+	tio, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err == nil {
+		tio.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		tio.Oflag &^= unix.OPOST
+		tio.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		tio.Cflag &^= unix.CSIZE | unix.PARENB
+		tio.Cflag |= unix.CS8
+		err = unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, tio)
+	}
+	if err != nil {
+		_ = slave.Close()
+		_ = master.Close()
+		return nil, fmt.Errorf("raw mode: %w", err)
+	}
+	return &PTY{Path: path, master: master, slave: slave}, nil
+}
+
+// Serve answers frames arriving on the PTY until it is closed.
+func (p *PTY) Serve(b *Bus) error { return b.Serve(p.master) }
+
+// Close releases both ends of the PTY.
+func (p *PTY) Close() error {
+	_ = p.slave.Close()
+	return p.master.Close()
+}
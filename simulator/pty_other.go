@@ -0,0 +1,21 @@
+//go:build !linux
+
+package simulator
+
+import "fmt"
+
+// PTY is only available on Linux; elsewhere use Serve with a TCP listener or NewPipe.
+type PTY struct {
+	Path string
+}
+
+// OpenPTY reports that pseudo-terminals are not supported on this platform.
+func OpenPTY() (*PTY, error) {
+	return nil, fmt.Errorf("pseudo-terminal simulator is only supported on linux")
+}
+
+// Serve is a no-op on unsupported platforms.
+func (p *PTY) Serve(b *Bus) error { return fmt.Errorf("unsupported") }
+
+// Close is a no-op on unsupported platforms.
+func (p *PTY) Close() error { return nil }
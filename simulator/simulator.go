@@ -0,0 +1,609 @@
+// Package simulator emulates a Leo485 bus of load-cell bars so calibration,
+// test mode and flashing can be exercised without a physical shelf.
+//
+// The simulator speaks the same frame format as the firmware: requests are
+// "0<id>" + command + crc16 + CR, responses are "0<id>|" + payload + crc16 +
+// CRLF (binary read-backs omit the pipe). Each load cell is modelled as
+// zero + gain*load + crosstalk from the other cells of the bar + noise.
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+)
+
+// Cell is the physical model of one load cell.
+type Cell struct {
+	Zero  float64 // raw ADC counts with no load
+	Gain  float64 // ADC counts per weight unit
+	Noise float64 // standard deviation of additive noise, in counts
+}
+
+// Bar is one simulated bar on the bus.
+type Bar struct {
+	ID    int
+	LCS   byte
+	Slots int     // load-cell fields in measure and write frames
+	Cells []*Cell // one per active bit of LCS, in bit order
+
+	// Crosstalk is the fraction of every other cell's load that leaks into a cell.
+	Crosstalk float64
+	Version   models.VERSION
+
+	// Device state
+	load        []float64
+	updateMode  bool
+	bootUntil   time.Time
+	zeros       []uint64
+	zeroTotal   uint64
+	factors     []float32
+	totalFactor float32
+
+	// Firmware upload (see serial.UploadFirmware)
+	fwSize    int
+	fwCRC     uint32
+	fwData    []byte // received so far; nil outside an upload
+	fwNext    int    // next block number expected
+	fwPending *models.VERSION
+}
+
+// Config describes a bus to build with New.
+type Config struct {
+	IDs       []int  // bar addresses; defaults to the first Bars valid addresses from 0
+	Bars      int    // number of bars when IDs is empty
+	LCS       byte   // active load-cell mask for every bar (default 3)
+	Slots     int    // load-cell fields per frame; 0 derives it from LCS
+	Command   string // measure command (default "M")
+	Version   models.VERSION
+	Seed      int64
+	Noise     float64 // per-cell noise in counts (default 20)
+	Crosstalk float64 // default 0.01
+}
+
+// Bus is a simulated RS-485 segment with one or more bars.
+type Bus struct {
+	mu      sync.Mutex
+	rng     *rand.Rand
+	bars    []*Bar
+	command string
+
+	// Latency is how long a bar takes to answer a frame.
+	Latency time.Duration
+	// BootTime is how long a bar stays silent after 'R'.
+	BootTime time.Duration
+	// BlockErrorRate is the fraction of firmware blocks answered ERR, to
+	// exercise upload retries.
+	BlockErrorRate float64
+	// CRCErrorRate is the fraction of addressed replies sent with a broken
+	// CRC, to exercise the host's CRC checks and link counters.
+	CRCErrorRate float64
+}
+
+// DefaultVersion is the firmware simulated bars report unless told otherwise.
+var DefaultVersion = models.VERSION{ID: 12009, MAJOR: 1, MINOR: 202}
+
+// New builds a bus with randomized but plausible cell parameters.
+func New(cfg Config) *Bus {
+	if cfg.LCS == 0 {
+		cfg.LCS = 3
+	}
+	if cfg.Command == "" {
+		cfg.Command = "M"
+	}
+	if cfg.Noise == 0 {
+		cfg.Noise = 20
+	}
+	if cfg.Crosstalk == 0 {
+		cfg.Crosstalk = 0.01
+	}
+	if cfg.Version == (models.VERSION{}) {
+		cfg.Version = DefaultVersion
+	}
+	ids := cfg.IDs
+	if len(ids) == 0 {
+		for id := 0; id <= serialpkg.MaxBarID && len(ids) < cfg.Bars; id++ {
+			if serialpkg.ValidateBarID(id) == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	b := &Bus{
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		command:  cfg.Command,
+		Latency:  2 * time.Millisecond,
+		BootTime: 500 * time.Millisecond,
+	}
+	for _, id := range ids {
+		b.AddBar(id, cfg.LCS, cfg.Slots, cfg.Noise, cfg.Crosstalk, cfg.Version)
+	}
+	return b
+}
+
+// NewFromParameters builds a bus matching the BARS, COMMAND and VERSION of a config.
+func NewFromParameters(p *models.PARAMETERS, seed int64) *Bus {
+	cfg := Config{Seed: seed, Version: DefaultVersion}
+	if p.SERIAL != nil {
+		cfg.Command = p.SERIAL.COMMAND
+	}
+	if p.VERSION != nil {
+		cfg.Version = *p.VERSION
+	}
+	b := New(cfg)
+	for _, bar := range p.BARS {
+		b.AddBar(bar.ID, bar.LCS, bar.Slots(), 20, 0.01, cfg.Version)
+	}
+	return b
+}
+
+// AddBar appends a bar with randomized cells to the bus. slots is the number
+// of load-cell fields its frames carry; 0 derives it from lcs like
+// models.BAR.Slots.
+func (b *Bus) AddBar(id int, lcs byte, slots int, noise, crosstalk float64, ver models.VERSION) *Bar {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if slots <= 0 {
+		slots = (&models.BAR{LCS: lcs}).Slots()
+	}
+	bar := &Bar{ID: id, LCS: lcs, Slots: slots, Crosstalk: crosstalk, Version: ver}
+	for i := 0; i < slots; i++ {
+		if lcs&(1<<i) == 0 {
+			continue
+		}
+		bar.Cells = append(bar.Cells, &Cell{
+			Zero:  1.45e8 + b.rng.Float64()*1e7,
+			Gain:  2000 + b.rng.Float64()*2000,
+			Noise: noise,
+		})
+	}
+	n := len(bar.Cells)
+	bar.load = make([]float64, n)
+	bar.zeros = make([]uint64, n)
+	bar.factors = make([]float32, n)
+	for i := range bar.factors {
+		bar.factors[i] = 1
+	}
+	bar.totalFactor = float32(n)
+	b.bars = append(b.bars, bar)
+	return bar
+}
+
+// Bars returns the simulated bars in bus order.
+func (b *Bus) Bars() []*Bar {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Bar(nil), b.bars...)
+}
+
+// Clear removes all virtual weights from the shelf.
+func (b *Bus) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, bar := range b.bars {
+		for i := range bar.load {
+			bar.load[i] = 0
+		}
+	}
+}
+
+// PlaceWeight puts weight on a bay. Bay n spans bar n and bar n+1; the side
+// selects how the weight is shared between those two bars and front/back how
+// it is shared between the cells of each bar.
+func (b *Bus) PlaceWeight(bay models.BAY, side models.LMR, fb models.FB, weight float64) error {
+	depth := 0.25
+	if fb == models.BACK {
+		depth = 0.75
+	}
+	return b.PlaceAt(bay, side, depth, weight)
+}
+
+// PlaceAt is PlaceWeight with the depth given as a fraction of the bar
+// length, 0 being the front edge and 1 the back.
+func (b *Bus) PlaceAt(bay models.BAY, side models.LMR, depth, weight float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	left := int(bay)
+	if left < 0 || left+1 >= len(b.bars) {
+		return fmt.Errorf("bay %s out of range for %d bars", bay, len(b.bars))
+	}
+	share := map[models.LMR]float64{models.LEFT: 0.8, models.MIDDLE: 0.5, models.RIGHT: 0.2}[side]
+	b.bars[left].addLoad(weight*share, depth)
+	b.bars[left+1].addLoad(weight*(1-share), depth)
+	return nil
+}
+
+// PlaceStep places weight the way calibration load index j asks the operator
+// to (see models.CalibrationPlan): each depth row sits at the middle of its
+// share of the bar.
+func (b *Bus) PlaceStep(j int, weight float64) error {
+	b.mu.Lock()
+	bars := make([]*models.BAR, 0, len(b.bars))
+	for _, bar := range b.bars {
+		bars = append(bars, &models.BAR{ID: bar.ID, LCS: bar.LCS, SLOTS: bar.Slots})
+	}
+	b.mu.Unlock()
+	if len(bars) < 2 {
+		return fmt.Errorf("need at least two bars")
+	}
+	plan := models.CalibrationPlan(bars)
+	if j < 0 || j >= len(plan) {
+		return fmt.Errorf("load %d out of range 0..%d", j, len(plan)-1)
+	}
+	ld := plan[j]
+	depth := (float64(ld.Depth) + 0.5) / float64(ld.Depths)
+	return b.PlaceAt(ld.Bay, ld.Side, depth, weight)
+}
+
+// addLoad spreads w over the bar's cells by their distance to depth (0=front, 1=back).
+func (bar *Bar) addLoad(w, depth float64) {
+	n := len(bar.load)
+	if n == 0 {
+		return
+	}
+	if n == 1 {
+		bar.load[0] += w
+		return
+	}
+	weights := make([]float64, n)
+	sum := 0.0
+	for k := 0; k < n; k++ {
+		pos := float64(k) / float64(n-1)
+		weights[k] = math.Max(0, 1-math.Abs(pos-depth))
+		sum += weights[k]
+	}
+	for k := 0; k < n; k++ {
+		bar.load[k] += w * weights[k] / sum
+	}
+}
+
+// adc returns the current raw reading of every active cell.
+func (b *Bus) adc(bar *Bar) []uint64 {
+	out := make([]uint64, len(bar.Cells))
+	total := 0.0
+	for _, l := range bar.load {
+		total += l
+	}
+	for k, c := range bar.Cells {
+		own := bar.load[k]
+		v := c.Zero + c.Gain*own + bar.Crosstalk*c.Gain*(total-own) + b.rng.NormFloat64()*c.Noise
+		if v < 0 {
+			v = 0
+		}
+		out[k] = uint64(v + 0.5)
+	}
+	return out
+}
+
+// Serve answers frames read from t until t returns an error.
+func (b *Bus) Serve(t serialpkg.Transport) error {
+	buf := make([]byte, 0, 512)
+	tmp := make([]byte, 256)
+	for {
+		n, err := t.Read(tmp)
+		if err != nil {
+			return err
+		}
+		for _, c := range tmp[:n] {
+			buf = append(buf, c)
+			if c != '\r' {
+				continue
+			}
+			if resp, ok := b.handle(buf); ok {
+				buf = buf[:0]
+				if resp != nil {
+					if b.Latency > 0 {
+						time.Sleep(b.Latency)
+					}
+					if _, err := t.Write(resp); err != nil {
+						return err
+					}
+				}
+			}
+			if len(buf) > 512 {
+				buf = buf[:0]
+			}
+		}
+	}
+}
+
+// handle tries to interpret the bytes received so far, which end in CR. Frames
+// may contain CR themselves (the addressed Euler handshake and binary CRCs), so
+// every CR-delimited suffix is tried. ok reports whether the buffer was consumed.
+func (b *Bus) handle(buf []byte) (resp []byte, ok bool) {
+	body := buf[:len(buf)-1]
+	starts := []int{0}
+	for i, c := range body {
+		if c == '\r' {
+			starts = append(starts, i+1)
+		}
+	}
+	for _, s := range starts {
+		seg := body[s:]
+		if string(seg) == strings.TrimSuffix(serialpkg.Euler, "\r") {
+			return b.broadcastEnter(), true
+		}
+		id, ok := serialpkg.ParseAddress(seg)
+		if len(seg) < 4 || !ok {
+			continue
+		}
+		data, crc := seg[:len(seg)-2], seg[len(seg)-2:]
+		if !bytes.Equal(serialpkg.CRC16(data), crc) {
+			continue
+		}
+		return b.corrupt(b.dispatch(id, data[:2], data[2:])), true
+	}
+	// A lone CR (bootloader priming) carries nothing to answer.
+	return nil, len(body) == 0
+}
+
+// corrupt flips a bit of the CRC of resp at CRCErrorRate.
+func (b *Bus) corrupt(resp []byte) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if resp == nil || b.CRCErrorRate <= 0 || b.rng.Float64() >= b.CRCErrorRate {
+		return resp
+	}
+	resp[len(resp)-3] ^= 0x01 // last CRC byte, before CRLF
+	return resp
+}
+
+func (b *Bus) broadcastEnter() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	entered := false
+	for _, bar := range b.bars {
+		if now.After(bar.bootUntil) {
+			bar.updateMode = true
+			entered = true
+		}
+	}
+	if !entered {
+		return nil
+	}
+	return []byte("Enter\r\n")
+}
+
+func (b *Bus) find(id int) *Bar {
+	for _, bar := range b.bars {
+		if bar.ID == id {
+			return bar
+		}
+	}
+	return nil
+}
+
+// dispatch executes one addressed command and returns the framed reply, or nil
+// when the addressed bar is absent or still booting.
+func (b *Bus) dispatch(id int, addr, cmd []byte) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bar := b.find(id)
+	if bar == nil || time.Now().Before(bar.bootUntil) || len(cmd) == 0 {
+		return nil
+	}
+	s := string(cmd)
+	switch {
+	case s == strings.TrimSuffix(serialpkg.Euler, "\r") || s == serialpkg.Euler:
+		bar.updateMode = true
+		return textFrame(addr, "Enter")
+	case s == "V":
+		v := bar.Version
+		return textFrame(addr, fmt.Sprintf("Leo485 Version %d.%d.%d", v.ID, v.MAJOR, v.MINOR))
+	case s == b.command:
+		vals := b.adc(bar)
+		fields := make([]string, bar.Slots)
+		k := 0
+		for i := 0; i < bar.Slots; i++ {
+			fields[i] = "0"
+			if bar.LCS&(1<<i) != 0 && k < len(vals) {
+				fields[i] = strconv.FormatUint(vals[k], 10)
+				k++
+			}
+		}
+		return textFrame(addr, strings.Join(fields, "|"))
+	case s == "R":
+		bar.updateMode = false
+		bar.bootUntil = time.Now().Add(b.BootTime)
+		if bar.fwPending != nil {
+			bar.Version = *bar.fwPending
+			bar.fwPending = nil
+		}
+		return textFrame(addr, "Rebooting")
+	case s == "X":
+		payload := make([]byte, 4*(1+len(bar.factors)))
+		binary.BigEndian.PutUint32(payload, math.Float32bits(bar.totalFactor))
+		for k, f := range bar.factors {
+			binary.BigEndian.PutUint32(payload[4*(k+1):], math.Float32bits(f))
+		}
+		return binaryFrame(addr, payload)
+	case s == "O":
+		payload := make([]byte, 4*(1+len(bar.zeros)))
+		binary.BigEndian.PutUint32(payload, uint32(bar.zeroTotal))
+		for k, z := range bar.zeros {
+			binary.BigEndian.PutUint32(payload[4*(k+1):], uint32(z))
+		}
+		return binaryFrame(addr, payload)
+	case s[0] == 'F' || s[0] == 'B' || s == "E":
+		if !bar.updateMode {
+			return textFrame(addr, "ERR")
+		}
+		return textFrame(addr, b.firmware(bar, s))
+	case s[0] == 'O' || s[0] == 'X':
+		if !bar.updateMode {
+			return textFrame(addr, "ERR")
+		}
+		if err := bar.write(s); err != nil {
+			return textFrame(addr, "ERR")
+		}
+		return textFrame(addr, "OK")
+	}
+	return textFrame(addr, "ERR")
+}
+
+// firmware handles the bootloader's start ('F'), block ('B') and end ('E')
+// frames and returns the reply payload. An image accepted by 'E' takes
+// effect at the next 'R': the bar then reports the version in its banner.
+func (b *Bus) firmware(bar *Bar, s string) string {
+	switch s[0] {
+	case 'F':
+		f := strings.Split(strings.TrimSuffix(s[1:], "|"), "|")
+		if len(f) != 2 {
+			return "ERR"
+		}
+		size, err1 := strconv.Atoi(f[0])
+		crc, err2 := strconv.ParseUint(f[1], 16, 32)
+		if err1 != nil || err2 != nil || size <= 0 {
+			return "ERR"
+		}
+		bar.fwSize, bar.fwCRC = size, uint32(crc)
+		bar.fwData, bar.fwNext, bar.fwPending = make([]byte, 0, size), 0, nil
+		return "OK"
+	case 'B':
+		if bar.fwData == nil || len(s) < 5 {
+			return "ERR"
+		}
+		seq, err1 := strconv.ParseUint(s[1:5], 16, 16)
+		chunk, err2 := hex.DecodeString(s[5:])
+		switch {
+		case err1 != nil || err2 != nil:
+			return "ERR"
+		case int(seq) == bar.fwNext-1:
+			// Repeat of a block whose ack was lost; already written.
+		case int(seq) != bar.fwNext || len(bar.fwData)+len(chunk) > bar.fwSize:
+			return "ERR"
+		case b.BlockErrorRate > 0 && b.rng.Float64() < b.BlockErrorRate:
+			return "ERR"
+		default:
+			bar.fwData = append(bar.fwData, chunk...)
+			bar.fwNext++
+		}
+		return fmt.Sprintf("OK%04X", seq)
+	default: // 'E'
+		data := bar.fwData
+		bar.fwData = nil
+		if data == nil || len(data) != bar.fwSize || crc32.ChecksumIEEE(data) != bar.fwCRC {
+			return "ERR"
+		}
+		v := bar.Version
+		if img, err := serialpkg.ParseFirmware(data); err == nil && img.Version != nil {
+			v = *img.Version
+		}
+		bar.fwPending = &v
+		return "OK"
+	}
+}
+
+// FirmwareImage builds a fake firmware image of size bytes that carries the
+// version banner for v, for exercising uploads against the simulator.
+func FirmwareImage(v models.VERSION, size int, seed int64) []byte {
+	banner := []byte(fmt.Sprintf("Leo485 Version %d.%d.%d\x00", v.ID, v.MAJOR, v.MINOR))
+	if size < len(banner) {
+		size = len(banner)
+	}
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	// Random filler must not contain a banner of its own.
+	for i, c := range data {
+		if c == 'L' {
+			data[i] = 0xFF
+		}
+	}
+	pos := size / 2
+	if pos+len(banner) > size {
+		pos = size - len(banner)
+	}
+	copy(data[pos:], banner)
+	return data
+}
+
+// Version returns the firmware version the bar with the given ID reports.
+func (b *Bus) Version(id int) (models.VERSION, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bar := b.find(id)
+	if bar == nil {
+		return models.VERSION{}, false
+	}
+	return bar.Version, true
+}
+
+// write stores an 'O' (zeros + total) or 'X' (factors) frame payload.
+func (bar *Bar) write(s string) error {
+	fields := strings.Split(strings.TrimSuffix(s[1:], "|"), "|")
+	vals := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return err
+		}
+		vals[i] = v
+	}
+	if s[0] == 'O' && len(vals) != bar.Slots+1 || s[0] == 'X' && len(vals) != bar.Slots {
+		return fmt.Errorf("bad field count %d", len(vals))
+	}
+	k := 0
+	total := 0.0
+	for i := 0; i < bar.Slots; i++ {
+		if bar.LCS&(1<<i) == 0 || k >= len(bar.Cells) {
+			continue
+		}
+		if s[0] == 'O' {
+			bar.zeros[k] = uint64(vals[i])
+		} else {
+			bar.factors[k] = float32(vals[i])
+			total += vals[i]
+		}
+		k++
+	}
+	if s[0] == 'O' {
+		bar.zeroTotal = uint64(vals[bar.Slots])
+	} else {
+		bar.totalFactor = float32(total)
+	}
+	return nil
+}
+
+// Stored returns the zeros and factors the bar with the given ID holds in flash.
+func (b *Bus) Stored(id int) ([]uint64, []float32, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bar := b.find(id)
+	if bar == nil {
+		return nil, nil, false
+	}
+	return append([]uint64(nil), bar.zeros...), append([]float32(nil), bar.factors...), true
+}
+
+// UpdateMode reports whether the bar with the given ID is in update mode,
+// where it accepts 'O', 'X' and firmware writes until it is rebooted.
+func (b *Bus) UpdateMode(id int) (updating, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bar := b.find(id)
+	if bar == nil {
+		return false, false
+	}
+	return bar.updateMode, true
+}
+
+func textFrame(addr []byte, payload string) []byte {
+	return binaryFrame(append(append([]byte{}, addr...), '|'), []byte(payload))
+}
+
+func binaryFrame(prefix, payload []byte) []byte {
+	out := append(append([]byte{}, prefix...), payload...)
+	out = append(out, serialpkg.CRC16(out)...)
+	return append(out, '\r', '\n')
+}
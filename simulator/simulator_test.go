@@ -0,0 +1,167 @@
+package simulator_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CK6170/Calrunrilla-go/models"
+	serialpkg "github.com/CK6170/Calrunrilla-go/serial"
+	"github.com/CK6170/Calrunrilla-go/simulator"
+)
+
+// shelf serves a simulated bus of bars to a Leo485 over an in-memory pipe.
+// Reads time out after 100ms so broken replies are given up on quickly.
+func shelf(t *testing.T, bars []*models.BAR) (*serialpkg.Leo485, *simulator.Bus) {
+	t.Helper()
+	sim := simulator.NewFromParameters(&models.PARAMETERS{BARS: bars}, 1)
+	sim.BootTime = 100 * time.Millisecond
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	l, err := serialpkg.NewLeo485WithTransport(host, &models.SERIAL{COMMAND: "M", TIMEOUTS: &models.TIMEOUTS{VERSION: 100, MEASURE: 100, READ: 100}}, bars)
+	if err != nil {
+		t.Fatalf("NewLeo485WithTransport: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+		_ = dev.Close()
+	})
+	return l, sim
+}
+
+func TestRoundTrip(t *testing.T) {
+	bars := []*models.BAR{{ID: 1, LCS: 3}, {ID: 2, LCS: 3}}
+	l, sim := shelf(t, bars)
+
+	// V
+	for i := range bars {
+		id, major, minor, err := l.GetVersion(i)
+		if err != nil {
+			t.Fatalf("GetVersion(%d): %v", i, err)
+		}
+		if v := (models.VERSION{ID: id, MAJOR: major, MINOR: minor}); v != simulator.DefaultVersion {
+			t.Errorf("bar %d reports %+v, want %+v", i+1, v, simulator.DefaultVersion)
+		}
+	}
+
+	// M, empty and with a weight on bay 0 shared by both bars.
+	empty := make([][]uint64, len(bars))
+	for i := range bars {
+		ads, err := l.GetADs(i)
+		if err != nil {
+			t.Fatalf("GetADs(%d): %v", i, err)
+		}
+		if len(ads) != 2 {
+			t.Fatalf("bar %d: %d ADCs, want one per active cell", i+1, len(ads))
+		}
+		empty[i] = ads
+	}
+	if err := sim.PlaceWeight(0, models.MIDDLE, models.FRONT, 1000); err != nil {
+		t.Fatalf("PlaceWeight: %v", err)
+	}
+	for i := range bars {
+		ads, err := l.GetADs(i)
+		if err != nil {
+			t.Fatalf("GetADs(%d) loaded: %v", i, err)
+		}
+		var gain float64
+		for k := range ads {
+			gain += float64(ads[k]) - float64(empty[i][k])
+		}
+		if gain < 1e5 {
+			t.Errorf("bar %d: %.0f counts for 500 weight units", i+1, gain)
+		}
+	}
+	sim.Clear()
+
+	// O and X are refused outside update mode.
+	if l.WriteFactors(0, []float64{2, 2}) {
+		t.Error("factors written outside update mode")
+	}
+
+	// O and X in update mode, read back with the binary X.
+	if err := l.OpenToUpdate(); err != nil {
+		t.Fatalf("OpenToUpdate: %v", err)
+	}
+	zeros := []float64{145000000, 152000000}
+	factors := []float64{0.00125, -0.0005}
+	if !l.WriteZeros(1, zeros, 297000000) {
+		t.Fatal("WriteZeros failed")
+	}
+	if !l.WriteFactors(1, factors) {
+		t.Fatal("WriteFactors failed")
+	}
+	z, f, ok := sim.Stored(2)
+	if !ok || z[0] != 145000000 || z[1] != 152000000 || f[0] != serialpkg.WireFactor(0.00125) || f[1] != serialpkg.WireFactor(-0.0005) {
+		t.Errorf("bar 2 stores zeros %v factors %v", z, f)
+	}
+	got, err := l.ReadFactors(1)
+	if err != nil {
+		t.Fatalf("ReadFactors: %v", err)
+	}
+	if len(got) < 2 || float32(got[0]) != f[0] || float32(got[1]) != f[1] {
+		t.Errorf("X reads back %v, want %v", got, f)
+	}
+
+	// R leaves update mode; the bar is silent while it boots.
+	for _, r := range l.RebootAndWait(nil, 2*time.Second) {
+		if !r.OK || r.BootMS < float64(sim.BootTime/time.Millisecond) {
+			t.Errorf("reboot %+v, want OK after at least %v", r, sim.BootTime)
+		}
+	}
+	for _, bar := range bars {
+		if updating, _ := sim.UpdateMode(bar.ID); updating {
+			t.Errorf("bar ID %d still in update mode after R", bar.ID)
+		}
+	}
+	if z2, f2, _ := sim.Stored(2); z2[0] != z[0] || f2[0] != f[0] {
+		t.Errorf("reboot lost the calibration: zeros %v factors %v", z2, f2)
+	}
+}
+
+func TestBadRequestCRCIgnored(t *testing.T) {
+	sim := simulator.NewFromParameters(&models.PARAMETERS{BARS: []*models.BAR{{ID: 1, LCS: 3}}}, 1)
+	host, dev := serialpkg.NewPipe()
+	go func() { _ = sim.Serve(dev) }()
+	defer host.Close()
+	defer dev.Close()
+
+	cmd := serialpkg.GetCommand(1, []byte("V"))
+	cmd[len(cmd)-2] ^= 0xFF
+	if _, err := serialpkg.GetData(host, cmd, 100); !errors.Is(err, serialpkg.ErrTimeout) {
+		t.Errorf("frame with a broken CRC answered: %v", err)
+	}
+	if _, err := serialpkg.GetData(host, serialpkg.GetCommand(1, []byte("V")), 100); err != nil {
+		t.Errorf("bus stuck after a broken frame: %v", err)
+	}
+}
+
+func TestCRCErrorRate(t *testing.T) {
+	bars := []*models.BAR{{ID: 1, LCS: 3}}
+	l, sim := shelf(t, bars)
+
+	sim.CRCErrorRate = 1
+	for _, read := range []func() error{
+		func() error { _, _, _, err := l.GetVersion(0); return err },
+		func() error { _, err := l.GetADs(0); return err },
+		func() error { _, err := l.ReadFactors(0); return err },
+	} {
+		if err := read(); !errors.Is(err, serialpkg.ErrCRC) {
+			t.Errorf("broken reply gave %v, want a CRC error", err)
+		}
+	}
+	if n := l.LinkReport().Bars[0].CRCErrors; n < 3 {
+		t.Errorf("%d CRC errors counted, want at least 3", n)
+	}
+
+	sim.CRCErrorRate = 0.5
+	var bad int
+	for k := 0; k < 20; k++ {
+		if _, _, _, err := l.GetVersion(0); err != nil {
+			bad++
+		}
+	}
+	if bad == 0 || bad == 20 {
+		t.Errorf("%d of 20 replies broken at rate 0.5", bad)
+	}
+}
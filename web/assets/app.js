@@ -3,7 +3,7 @@ import { state } from "./lib/state.js";
 import { uploadAndConnect, disconnect } from "./entry.js";
 import { abortCalibration, loadCalPlan, pollCalADC, startCalStep } from "./calibration.js";
 import { applyTestConfigIfRunning, setTestTotalsExpanded, startTest, stopTest, toggleTestTotalsExpanded, zeroTest } from "./test.js";
-import { renderFlashPreviewFromFile, stopFlash, uploadAndFlash } from "./flash.js";
+import { backupDevice, dryRunFlash, rebootBars, renderFlashPreviewFromFile, rollbackFlash, stopFlash, uploadAndFlash, uploadFirmware } from "./flash.js";
 
 /**
  * Main UI wiring (no framework).
@@ -123,7 +123,12 @@ $("testADTimeoutMs").onchange = () => applyTestConfigIfRunning().catch((e) => lo
 // Flash controls
 $("flashBack").onclick = () => { stopFlash().finally(() => show("entryCard")); };
 $("flashUploadStart").onclick = () => uploadAndFlash().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
+$("flashDryRun").onclick = () => dryRunFlash().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
 $("flashStop").onclick = () => stopFlash().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
+$("flashBackup").onclick = () => backupDevice().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
+$("flashReboot").onclick = () => rebootBars().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
+$("flashRollback").onclick = () => rollbackFlash().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
+$("firmwareUpload").onclick = () => uploadFirmware().catch((e) => log($("flashLog"), `ERROR: ${e.message}`));
 
 // Preview factors/zeros as soon as a calibrated json file is chosen
 $("calibratedFile").onchange = () => {
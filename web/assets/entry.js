@@ -97,6 +97,10 @@ export async function uploadAndConnect() {
     state.connected = true;
     setStatus(`Connected on ${conn.port} (bars=${conn.bars}, lcs=${conn.lcs})`);
     log($("entryLog"), `Connected on ${conn.port}`);
+    (conn.versions || []).forEach((v) => {
+      const verdict = v.version ? (v.compatible ? "OK" : `NOT ALLOWED (${v.reason})`) : "no reply";
+      log($("entryLog"), `Bar ${v.bar} (ID ${v.id}): firmware ${v.version || "?"} ${verdict}`);
+    });
     if (conn.warning) {
       log($("entryLog"), `WARNING: ${conn.warning}`);
     }
@@ -18,16 +18,125 @@ import { closeWS, connectWS } from "./lib/ws.js";
 export async function uploadAndFlash() {
   const f = $("calibratedFile").files?.[0];
   if (!f) throw new Error("Choose a *_calibrated.json file first");
+  const bars = selectedBars();
   // Ensure preview is rendered before flashing starts
   await renderFlashPreviewFromFile(f).catch(() => {});
   const up = await uploadFile("/api/upload/calibrated", f);
   const calibratedId = up.configId;
   log($("flashLog"), `Uploaded calibrated -> id=${calibratedId}`);
 
+  watchFlash();
+  await apiJSON("/api/flash/start", { calibratedId, bars, force: $("flashForce").checked });
+  log($("flashLog"), "Flash started");
+}
+
+/**
+ * Parse the "Bar IDs" field ("2, 5") into the bar IDs to flash. An empty field
+ * flashes every bar.
+ *
+ * @returns {number[]|undefined}
+ */
+function selectedBars() {
+  const raw = $("flashBars").value.trim();
+  if (!raw) return undefined;
+  return raw.split(/[\s,]+/).filter(Boolean).map((s) => {
+    const id = Number(s);
+    if (!Number.isInteger(id)) throw new Error(`Invalid bar ID "${s}"`);
+    return id;
+  });
+}
+
+/**
+ * Upload a calibrated JSON and list the frames a flash would send, without
+ * touching the device.
+ *
+ * @returns {Promise<void>}
+ */
+export async function dryRunFlash() {
+  const f = $("calibratedFile").files?.[0];
+  if (!f) throw new Error("Choose a *_calibrated.json file first");
+  const up = await uploadFile("/api/upload/calibrated", f);
+  const res = await apiJSON("/api/flash/start", { calibratedId: up.configId, bars: selectedBars(), dryRun: true });
+  const frames = res.frames || [];
+  let rows = "";
+  for (const fr of frames) {
+    const target = fr.barId >= 0 ? `${fr.bar} (ID ${fr.barId})` : "all";
+    const fields = (fr.fields || []).map((x) => `${escapeHTML(x.name)}: ${escapeHTML(x.value)}`).join("<br>");
+    rows += `<tr>
+      <td>${fr.seq}</td>
+      <td>${escapeHTML(fr.step)}</td>
+      <td>${target}</td>
+      <td style="font-family:var(--mono);">${escapeHTML(fr.text)}</td>
+      <td style="font-family:var(--mono);">${fr.crc || ""}</td>
+      <td style="font-family:var(--mono);">${fields}</td>
+    </tr>`;
+  }
+  $("flashPreview").innerHTML = `
+    <div class="pill" style="margin-bottom:8px;">Dry run: ${frames.length} frames (nothing sent)</div>
+    <table class="tbl">
+      <thead><tr><th>#</th><th>Step</th><th>Bar</th><th>Frame</th><th>CRC</th><th>Fields</th></tr></thead>
+      <tbody>${rows}</tbody>
+    </table>
+  `;
+  log($("flashLog"), `Dry run: ${frames.length} frames`);
+}
+
+/**
+ * Re-flash the calibration the device held before the most recent flash.
+ *
+ * @returns {Promise<void>}
+ */
+export async function rollbackFlash() {
+  watchFlash();
+  await apiJSON("/api/flash/rollback");
+  log($("flashLog"), "Rollback started");
+}
+
+/**
+ * Upload a firmware image to the bars named in "Bar IDs" (all when empty)
+ * and follow `/ws/firmware` until every bar reports its new version.
+ *
+ * @returns {Promise<void>}
+ */
+export async function uploadFirmware() {
+  const f = $("firmwareFile").files?.[0];
+  if (!f) throw new Error("Choose a firmware image first");
+  const bars = selectedBars();
+  connectWS("firmware", "/ws/firmware", (msg) => {
+    const d = msg.data || {};
+    if (msg.type === "progress") {
+      const blocks = d.blocks ? ` ${d.block || 0}/${d.blocks}` : "";
+      $("flashProgress").textContent = `Firmware bar ${d.bar} (ID ${d.id}): ${d.stage}${blocks}, ${d.retries || 0} retries`;
+    }
+    if (msg.type === "result" && d.ok) {
+      const v = d.version;
+      log($("flashLog"), `Bar ${d.bar}: firmware ${v.ID}.${v.MAJOR}.${v.MINOR} in ${(d.durationMs / 1000).toFixed(1)}s (${d.retries} retries)`);
+    }
+    if (msg.type === "done") {
+      $("flashProgress").textContent = "Done";
+      log($("flashLog"), "Firmware update complete");
+      closeWS(state.ws.firmware);
+    }
+    if (msg.type === "error") {
+      log($("flashLog"), `ERROR: ${d.bar ? `bar ${d.bar}: ` : ""}${d.error}`);
+      closeWS(state.ws.firmware);
+    }
+  });
+  const res = await uploadFile("/api/firmware/upload", f, bars ? { bars: bars.join(",") } : undefined);
+  log($("flashLog"), `Firmware ${res.version || "(no version banner)"}: ${res.size} bytes, ${res.blocks} blocks, CRC32 ${res.crc}`);
+}
+
+/**
+ * Open `/ws/flash` and log progress, backup and verify events.
+ */
+function watchFlash() {
   connectWS("flash", "/ws/flash", (msg) => {
     if (msg.type === "progress") {
       const p = msg.data || {};
-      $("flashProgress").textContent = `Stage ${p.stage} bar ${p.barIndex + 1}: ${p.message}`;
+      const bar = Number.isFinite(p.barIndex) ? ` bar ${p.barIndex + 1}` : "";
+      $("flashProgress").textContent = `Stage ${p.stage}${bar}: ${p.message}`;
+      if (p.stage === "verified" || p.stage === "unchanged" || p.stage === "skip_unavailable") log($("flashLog"), `Bar ${p.barIndex + 1}: ${p.message}`);
+      if (p.stage === "backup" && p.backupId) log($("flashLog"), `${p.message} (id=${p.backupId})`);
     }
     if (msg.type === "done") {
       $("flashProgress").textContent = "Done";
@@ -37,9 +146,6 @@ export async function uploadAndFlash() {
       log($("flashLog"), `ERROR: ${msg.data.error}`);
     }
   });
-
-  await apiJSON("/api/flash/start", { calibratedId });
-  log($("flashLog"), "Flash started");
 }
 
 /**
@@ -112,6 +218,40 @@ export async function renderFlashPreviewFromFile(file) {
   renderFlashPreview(obj);
 }
 
+/**
+ * Reboot the selected bars (all when none are selected), wait for each to
+ * answer again and log its boot time.
+ *
+ * @returns {Promise<void>}
+ */
+export async function rebootBars() {
+  log($("flashLog"), "Rebooting bars…");
+  const res = await apiJSON("/api/device/reboot", { bars: selectedBars() });
+  (res.results || []).forEach((r) => {
+    if (r.ok) log($("flashLog"), `Bar ${r.bar} (ID ${r.id}): ready after ${Math.round(r.bootMs)} ms`);
+    else log($("flashLog"), `Bar ${r.bar} (ID ${r.id}): ${r.error}`);
+  });
+}
+
+/**
+ * Read the calibration currently stored in the device and download it as a
+ * calibrated JSON.
+ *
+ * @returns {Promise<void>}
+ */
+export async function backupDevice() {
+  log($("flashLog"), "Reading calibration from device…");
+  const res = await apiJSON("/api/device/backup");
+  if (!res.zeros) log($("flashLog"), "Firmware does not report zeros; backup has ZERO 0");
+  log($("flashLog"), `Backup saved as ${res.filename} (id=${res.calibratedId})`);
+  const a = document.createElement("a");
+  a.href = `/api/download?id=${encodeURIComponent(res.calibratedId)}`;
+  a.download = "";
+  document.body.appendChild(a);
+  a.click();
+  a.remove();
+}
+
 /**
  * Request stop/cancel of the current flash operation and close the socket.
  *
@@ -29,12 +29,14 @@ export async function apiJSON(url, body) {
  *
  * @param {string} url - Upload endpoint (ex: `/api/upload/config`).
  * @param {File|Blob} file - The file/blob to upload.
+ * @param {Record<string, string>} [fields] - Extra form fields sent with the file.
  * @returns {Promise<any>} Parsed JSON response body.
  * @throws {Error} When the response is not OK (includes server-provided `error` when available).
  */
-export async function uploadFile(url, file) {
+export async function uploadFile(url, file, fields) {
   const fd = new FormData();
   fd.append("file", file);
+  for (const [k, v] of Object.entries(fields || {})) fd.append(k, v);
   const res = await fetch(url, { method: "POST", body: fd });
   const data = await res.json().catch(() => ({}));
   if (!res.ok) throw new Error(data.error || `${res.status} ${res.statusText}`);
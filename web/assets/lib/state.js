@@ -36,6 +36,7 @@ export const state = {
     test: null,
     cal: null,
     flash: null,
+    firmware: null,
   },
   // DEBUG: websocket message counters
   wsCounts: {
@@ -1,4 +1,4 @@
-import { $, escapeHTML, log } from "./lib/dom.js";
+import { $, escapeHTML, log, setStatus } from "./lib/dom.js";
 import { state } from "./lib/state.js";
 import { apiJSON } from "./lib/api.js";
 import { closeWS, connectWS } from "./lib/ws.js";
@@ -65,6 +65,7 @@ export function toggleTestTotalsExpanded() {
  * - factorsRead (device factors) so UI can display the formula columns
  * - snapshot (live per-bar/per-LC weights + totals)
  * - stopped / error
+ * - disconnected / reconnecting / reconnected while a lost port is reopened
  *
  * @returns {Promise<void>}
  */
@@ -135,6 +136,17 @@ export async function startTest() {
     if (msg.type === "error") {
       log($("testLog"), `ERROR: ${msg.data.error}`);
     }
+    if (msg.type === "disconnected") {
+      log($("testLog"), `Link to ${msg.data.port} lost: ${msg.data.error}`);
+      setStatus(`Link lost on ${msg.data.port}`);
+    }
+    if (msg.type === "reconnecting") {
+      setStatus(`Reconnecting to ${msg.data.port} (attempt ${msg.data.attempt})...`);
+    }
+    if (msg.type === "reconnected") {
+      log($("testLog"), `Reconnected on ${msg.data.port} after ${(msg.data.downMs / 1000).toFixed(1)} s`);
+      setStatus(`Connected on ${msg.data.port}`);
+    }
   });
   const debug = !!$("testDebug")?.checked;
   const tickMs = Number($("testTickMs")?.value || 0);